@@ -0,0 +1,222 @@
+package boptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AuditRecord is one write to a test case: who made it, what it replaced and
+// whether it reached the simulation.
+type AuditRecord struct {
+	Wall     time.Time `json:"wall"`
+	Time     float64   `json:"time"` // simulated seconds since the start of the year when written
+	TestCase string    `json:"test_case,omitempty"`
+	ID       string    `json:"id,omitempty"`
+	Key      string    `json:"key,omitempty"` // as requested, for writes through the server
+	Point    string    `json:"point,omitempty"`
+
+	Caller   string    `json:"caller"` // see Caller.String, or "direct" for SetInput
+	Identity *Identity `json:"identity,omitempty"`
+	Src      string    `json:"src,omitempty"` // of the request header
+	Dst      string    `json:"dst,omitempty"`
+
+	Previous any `json:"previous"` // as last reported by the simulation, nil if it is not
+	Value    any `json:"value"`
+
+	Applied      bool   `json:"applied"`                 // in the next advance
+	AppliedValue any    `json:"applied_value,omitempty"` // after actuator faults
+	Error        string `json:"error,omitempty"`         // why it was not applied
+}
+
+// AuditLog appends a record of every write to a JSON Lines file. Writes to a
+// test case are recorded once the advance after them has settled whether they
+// were applied; rejected writes are recorded straight away. It may be shared
+// by test cases. A nil AuditLog records nothing.
+type AuditLog struct {
+	file *rotatingFile // never rotated, the audit trail is kept whole
+}
+
+// NewAuditLog opens, or creates, the file at path for appending.
+func NewAuditLog(path string) (*AuditLog, error) {
+	file, err := openRotatingFile(path, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{file: file}, nil
+}
+
+// Record appends rec.
+func (a *AuditLog) Record(rec AuditRecord) error {
+	if a == nil {
+		return nil
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = a.file.Write(append(b, '\n'))
+	return err
+}
+
+// Close syncs and closes the file.
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// record every write to the test case in a
+func WithAuditLog(a *AuditLog) testCaseOption {
+	return func(c *TestCase) {
+		c.audit.Store(a)
+	}
+}
+
+// record every Set pair in a, including those rejected, and the writes to
+// test cases without an audit log of their own
+func WithAudit(a *AuditLog) serverOption {
+	return func(s *Server) {
+		s.audit = a
+	}
+}
+
+// writes value to key unless it breaks a safety rule, auditing the write,
+// completed with what the test case knows, once the next advance settles it
+func (c *TestCase) setInput(key string, value any, rec AuditRecord) error {
+	c.logger().Info("setting input", key, value)
+	rec.Wall = time.Now()
+	rec.Time, _ = c.State.Get("time").(float64)
+	rec.TestCase, rec.ID, rec.Point = c.registeredName(), c.ID, key
+	rec.Previous, rec.Value = c.State.Get(key), value
+
+	if err := c.checkInput(key, value); err != nil {
+		c.logger().Warn("write rejected", "test_case", c.ID, "input", key, "value", value, "error", err)
+		rec.Error = err.Error()
+		if err := c.audit.Load().Record(rec); err != nil {
+			c.logger().Error("unable to audit write", "test_case", c.ID, "point", rec.Point, "error", err)
+		}
+		return err
+	}
+
+	c.writeMu.Lock()
+	// an override that is not active holds no value the building does
+	active := c.State.Get(activation(key))
+	if x, _ := InputValue(active); active == nil || x == 1 {
+		c.Safety.Seed(key, rec.Previous, c.heldSince)
+	}
+	if err := c.Safety.Check(key, value, rec.Time, c.writeBuffer.GetAll()); err != nil {
+		c.writeMu.Unlock()
+		c.logger().Warn("write rejected by safety rules", "test_case", c.ID, "input", key, "value", value, "error", err)
+		rec.Error = err.Error()
+		if err := c.audit.Load().Record(rec); err != nil {
+			c.logger().Error("unable to audit write", "test_case", c.ID, "point", rec.Point, "error", err)
+		}
+		return err
+	}
+	c.writeBuffer.Set(key, value)
+	if c.audit.Load() != nil {
+		c.writes = append(c.writes, rec)
+	}
+	c.writeMu.Unlock()
+
+	c.pending.Store(true)
+	c.metrics.Load().buffered(c.writeBuffer.Len())
+	return nil
+}
+
+// checkInput returns an error if key is not an input of the backend or value
+// is not a number, which would fail every advance it is sent with. Inputs the
+// backend cannot list, e.g. when it is unreachable, are not checked by name.
+func (c *TestCase) checkInput(key string, value any) error {
+	if _, err := InputValue(value); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	inputs := c.inputNames()
+	if _, ok := inputs[key]; inputs != nil && !ok {
+		return fmt.Errorf("%s is not an input of %s", key, c.Name)
+	}
+	return nil
+}
+
+// returns the inputs of the backend, nil if they are unknown
+func (c *TestCase) inputNames() map[string]PointProperties {
+	c.inputsMu.Lock()
+	defer c.inputsMu.Unlock()
+	if c.inputs != nil || c.backend == nil {
+		return c.inputs
+	}
+	inputs, err := c.backend.Inputs(c.ID)
+	if err != nil {
+		c.logger().Warn("unable to get inputs, writes are not checked by name", "test_case", c.ID, "error", err)
+		return nil
+	}
+	c.inputs = inputs
+	return inputs
+}
+
+// settle records the writes taken with the inputs of an advance: the last
+// write to each input was applied, unless the advance failed or a safety rule,
+// for the reason in dropped, or an actuator fault dropped it, and those before
+// it were overwritten.
+func (c *TestCase) settle(writes []AuditRecord, applied map[string]any, dropped map[string]error, advanceErr error) {
+	audit := c.audit.Load()
+	last := make(map[string]int, len(writes))
+	for i, rec := range writes {
+		last[rec.Point] = i
+	}
+	for i, rec := range writes {
+		switch {
+		case last[rec.Point] != i:
+			rec.Error = "overwritten before the next advance"
+		case advanceErr != nil:
+			rec.Error = fmt.Sprintf("advance failed: %v", advanceErr)
+		default:
+			rec.AppliedValue, rec.Applied = applied[rec.Point]
+			if err, ok := dropped[rec.Point]; ok {
+				rec.Error = fmt.Sprintf("dropped by safety rules: %v", err)
+			} else if !rec.Applied {
+				rec.Error = "dropped by an actuator fault"
+			}
+		}
+		if err := audit.Record(rec); err != nil {
+			c.logger().Error("unable to audit write", "test_case", c.ID, "point", rec.Point, "error", err)
+		}
+	}
+}
+
+// records the writes never advanced, e.g. when the test case stops
+func (c *TestCase) abandonWrites(reason string) {
+	c.writeMu.Lock()
+	writes := c.writes
+	c.writes = nil
+	c.writeMu.Unlock()
+
+	audit := c.audit.Load()
+	for _, rec := range writes {
+		rec.Error = reason
+		if err := audit.Record(rec); err != nil {
+			c.logger().Error("unable to audit write", "test_case", c.ID, "point", rec.Point, "error", err)
+		}
+	}
+}
+
+// records a Set pair the server rejected, in the audit log of the test case
+// it was routed to, if any, or the server's
+func (s *Server) auditRejected(testCase *TestCase, rec AuditRecord, err error) {
+	audit := s.audit
+	if testCase != nil {
+		if a := testCase.audit.Load(); a != nil {
+			audit = a
+		}
+		rec.Time, _ = testCase.State.Get("time").(float64)
+		rec.TestCase, rec.ID = testCase.registeredName(), testCase.ID
+		rec.Previous = testCase.State.Get(rec.Point)
+	}
+	rec.Wall = time.Now()
+	rec.Error = err.Error()
+	if err := audit.Record(rec); err != nil {
+		s.logger().Error("unable to audit write", "key", rec.Key, "error", err)
+	}
+}
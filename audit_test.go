@@ -0,0 +1,127 @@
+package boptest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jamesryancoleman/bos/common"
+)
+
+func readAudit(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("%q: %v", scanner.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := NewAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faults, err := NewActuatorFaults(ActuatorFault{Input: "ovePum_u", Kind: FaultIgnore})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCase, err := NewTestCase("bestest_hydronic_heat_pump", WithPlant(newRCPlant(t, HVACHeatPump)),
+		WithActuatorFaults(faults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	if err := testCase.Start(); err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{Rules: []PolicyRule{{Subjects: []string{"*"}, Write: []string{"hp/oveTSet_*", "hp/ovePum_u"}}}}
+	s := NewServer("127.0.0.1:0", nil, WithPolicy(policy), WithAudit(audit))
+	if err := s.AddTestCase("hp", testCase); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Set(context.Background(), &common.SetRequest{
+		Header: &common.Header{Src: "bos.local", Dst: "driver.local"},
+		Pairs: []*common.SetPair{
+			{Key: "boptest://hp/oveTSet_activate", Value: "1"},
+			{Key: "boptest://hp/oveTSet_u", Value: "296.15"},
+			{Key: "boptest://hp/oveTSet_u", Value: "297.15"},
+			{Key: "boptest://hp/ovePum_u", Value: "1"},
+			{Key: "boptest://hp/reaTZon_y", Value: "1"},
+			{Key: "boptest://campus/oveTSet_u", Value: "1"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testCase.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	testCase.SetInput("oveTSet_u", "295.15")
+	testCase.Stop()
+	if err := audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readAudit(t, path)
+	if len(records) != 7 {
+		t.Fatalf("got %d records, want 7: %+v", len(records), records)
+	}
+	// rejected pairs are recorded straight away, the others after the advance
+	denied, unroutable := records[0], records[1]
+	if denied.Point != "reaTZon_y" || denied.TestCase != "hp" || !strings.Contains(denied.Error, "permission denied") {
+		t.Errorf("denied write recorded as %+v", denied)
+	}
+	if unroutable.Key != "boptest://campus/oveTSet_u" || unroutable.Error == "" {
+		t.Errorf("unroutable write recorded as %+v", unroutable)
+	}
+
+	activate, overwritten, applied, dropped := records[2], records[3], records[4], records[5]
+	if !activate.Applied || activate.Src != "bos.local" || activate.Dst != "driver.local" || activate.Caller != "anonymous" {
+		t.Errorf("activation recorded as %+v", activate)
+	}
+	if overwritten.Applied || overwritten.Value != "296.15" || !strings.Contains(overwritten.Error, "overwritten") {
+		t.Errorf("overwritten write recorded as %+v", overwritten)
+	}
+	if !applied.Applied || applied.Value != "297.15" || applied.AppliedValue != "297.15" || applied.Wall.IsZero() {
+		t.Errorf("applied write recorded as %+v", applied)
+	}
+	if dropped.Applied || !strings.Contains(dropped.Error, "actuator fault") {
+		t.Errorf("write dropped by a fault recorded as %+v", dropped)
+	}
+
+	// the direct write never reached an advance
+	direct := records[6]
+	if direct.Caller != "direct" || direct.Applied || !strings.Contains(direct.Error, "stopped") {
+		t.Errorf("direct write recorded as %+v", direct)
+	}
+	if direct.Previous == nil || direct.Time <= applied.Time {
+		t.Errorf("direct write recorded previous %v at %v, after the advance at %v", direct.Previous, direct.Time, applied.Time)
+	}
+}
+
+func TestNilAuditLog(t *testing.T) {
+	var a *AuditLog
+	if err := a.Record(AuditRecord{}); err != nil {
+		t.Error(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Error(err)
+	}
+}
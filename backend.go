@@ -0,0 +1,448 @@
+package boptest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backend simulates test cases for a TestCase and its run loop. Test cases
+// are identified by the id Select returns. States have the shape of
+// BOPTEST's: every measurement and input keyed by point name plus "time" in
+// seconds since the start of the year.
+type Backend interface {
+	// Select creates an instance of the named test case and returns its id.
+	Select(testcase string) (string, error)
+	// Initialize resets the test case to start, simulating warmup seconds
+	// before it.
+	Initialize(id string, start, warmup int) (map[string]any, error)
+	// Advance applies inputs for one step and returns the new state. Inputs
+	// that are not sent are released to the baseline control.
+	Advance(id string, inputs map[string]any) (map[string]any, error)
+	Inputs(id string) (map[string]PointProperties, error)
+	Measurements(id string) (map[string]PointProperties, error)
+	Step(id string) (int, error)
+	SetStep(id string, step int) error
+	Stop(id string) error
+	// Status reports whether the test case is running.
+	Status(id string) (bool, error)
+}
+
+// ScenarioBackend is a Backend that supports BOPTEST scenarios.
+type ScenarioBackend interface {
+	Backend
+	// SetScenario returns the state at the start of the scenario's time
+	// period, or nil if it has none.
+	SetScenario(id string, sc Scenario) (map[string]any, error)
+}
+
+// KPIBackend is a Backend that computes BOPTEST's core KPIs.
+type KPIBackend interface {
+	Backend
+	KPI(id string) (map[string]float64, error)
+}
+
+// ResultsBackend is a Backend that keeps the trajectories of points.
+type ResultsBackend interface {
+	Backend
+	Results(id string, points []string, start, final float64) (map[string][]float64, error)
+}
+
+// HTTPBackend is a BOPTEST web service, or anything serving its REST API such
+// as the fakeboptest server.
+type HTTPBackend struct {
+	Host   string       // e.g. localhost:5000
+	Client *http.Client // defaults to the package Client, e.g. to replay a cassette
+	Logger *slog.Logger // defaults to the default logger
+
+	// cancels every request but Stop when done, e.g. at a deadline, none if nil
+	Context context.Context
+}
+
+func (b *HTTPBackend) logger() *slog.Logger {
+	return orDefault(b.Logger)
+}
+
+func (b *HTTPBackend) ctx() context.Context {
+	if b.Context != nil {
+		return b.Context
+	}
+	return context.Background()
+}
+
+func (b *HTTPBackend) client() *http.Client {
+	if b.Client != nil {
+		return b.Client
+	}
+	return Client
+}
+
+func (b *HTTPBackend) url(endpoint, id string) string {
+	return fmt.Sprintf("http://%s/%s/%s", b.Host, endpoint, id)
+}
+
+func httpGet(ctx context.Context, client *http.Client, url string) (HTTPResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return HTTPResponse{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return HTTPResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	return HTTPResponse{
+		Status: resp.Status,
+		Body:   body,
+	}, err
+}
+
+func httpPut(ctx context.Context, client *http.Client, url, contentType string, payload []byte) ([]byte, error) {
+	return httpSend(ctx, client, http.MethodPut, url, contentType, payload)
+}
+
+func httpPost(ctx context.Context, client *http.Client, url, contentType string, payload []byte) ([]byte, error) {
+	return httpSend(ctx, client, http.MethodPost, url, contentType, payload)
+}
+
+func httpSend(ctx context.Context, client *http.Client, method, url, contentType string, payload []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(payload))
+	if err != nil {
+		return []byte{}, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+// returns an error unless the status of the response, if it has one, is 200
+func (r JSONResponse) err(action string) error {
+	if r.Status != 0 && r.Status != http.StatusOK {
+		return fmt.Errorf("unable to %s: %s", action, r.Message)
+	}
+	return nil
+}
+
+func (b *HTTPBackend) Select(testcase string) (string, error) {
+	url := fmt.Sprintf("http://%s/testcases/%s/select", b.Host, testcase)
+	body, err := httpPost(b.ctx(), b.client(), url, "text/raw", []byte{})
+	if err != nil {
+		return "", err
+	}
+	b.logger().Debug("selected test case", "response", string(body))
+
+	var resp struct {
+		JSONResponse
+		TestID string `json:"testid"`
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		b.logger().Error(err.Error())
+		return "", err
+	}
+	if resp.TestID == "" {
+		return "", fmt.Errorf("unable to select %s: %s", testcase, resp.Message)
+	}
+	return resp.TestID, nil
+}
+
+func (b *HTTPBackend) Initialize(id string, start, warmup int) (map[string]any, error) {
+	payload, err := json.Marshal(map[string]int{"start_time": start, "warmup_period": warmup})
+	if err != nil {
+		return nil, err
+	}
+	raw, err := httpPut(b.ctx(), b.client(), b.url("initialize", id), ContentType_ApplicationJSON, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// not fatal, a test case that is not running returns an error and no state
+	var resp StateUpdate
+	err = json.Unmarshal(raw, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.State, nil
+}
+
+// Advance takes a map of inputs to use at the next timestep. The map may be
+// empty.
+func (b *HTTPBackend) Advance(id string, inputs map[string]any) (map[string]any, error) {
+	payload, err := json.Marshal(inputs)
+	if err != nil {
+		return map[string]any{}, err
+	}
+	b.logger().Debug("making advance request", "payload", string(payload))
+	raw, err := httpPost(b.ctx(), b.client(), b.url("advance", id), ContentType_ApplicationJSON, payload)
+	if err != nil {
+		return nil, err
+	}
+	var resp StateUpdate
+	err = json.Unmarshal(raw, &resp)
+	if err != nil {
+		b.logger().Error(err.Error(), "payload", string(raw))
+		return nil, err
+	}
+	if err := resp.err("advance"); err != nil {
+		return nil, err
+	}
+	return resp.State, nil
+}
+
+func (b *HTTPBackend) points(endpoint, id string) (map[string]PointProperties, error) {
+	resp, err := httpGet(b.ctx(), b.client(), b.url(endpoint, id))
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != HTTPStatus_Ok {
+		return nil, fmt.Errorf("unable to get %s: %s", endpoint, resp.Status)
+	}
+
+	var r PointsResponse
+	if err := json.Unmarshal(resp.Body, &r); err != nil {
+		return nil, err
+	}
+	return r.Payload, nil
+}
+
+func (b *HTTPBackend) Inputs(id string) (map[string]PointProperties, error) {
+	return b.points("inputs", id)
+}
+
+func (b *HTTPBackend) Measurements(id string) (map[string]PointProperties, error) {
+	return b.points("measurements", id)
+}
+
+func (b *HTTPBackend) Step(id string) (int, error) {
+	resp, err := httpGet(b.ctx(), b.client(), b.url("step", id))
+	if err != nil {
+		b.logger().Error(err.Error())
+		return 0, err
+	}
+
+	var stepResp SetStepResponse
+	err = json.Unmarshal(resp.Body, &stepResp)
+	if err != nil {
+		b.logger().Error(err.Error())
+		return 0, err
+	}
+	return stepResp.Step, nil
+}
+
+func (b *HTTPBackend) SetStep(id string, step int) error {
+	raw, err := httpPut(b.ctx(), b.client(), b.url("step", id), ContentType_ApplicationJSON,
+		fmt.Appendf([]byte{}, "{\"step\": %d}", step))
+	if err != nil {
+		b.logger().Error(err.Error())
+		return err
+	}
+
+	var resp JSONResponse
+	err = json.Unmarshal(raw, &resp)
+	if err != nil {
+		b.logger().Error(err.Error())
+		return err
+	}
+	if err := resp.err("set step"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Stop stops the test case even if the backend's context is done, giving up
+// after DefaultShutdownTimeout.
+func (b *HTTPBackend) Stop(id string) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(b.ctx()), DefaultShutdownTimeout*time.Second)
+	defer cancel()
+	_, err := httpPut(ctx, b.client(), b.url("stop", id), "", []byte{})
+	return err
+}
+
+func (b *HTTPBackend) Status(id string) (bool, error) {
+	resp, err := httpGet(b.ctx(), b.client(), b.url("status", id))
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "connect: connection refused") {
+			b.logger().Error("fatal: boptest server not running")
+		}
+		return false, err
+	}
+	return string(resp.Body) == `"Running"`, nil
+}
+
+func (b *HTTPBackend) SetScenario(id string, sc Scenario) (map[string]any, error) {
+	payload, err := json.Marshal(sc)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := httpPut(b.ctx(), b.client(), b.url("scenario", id), ContentType_ApplicationJSON, payload)
+	if err != nil {
+		b.logger().Error(err.Error())
+		return nil, err
+	}
+
+	var resp ScenarioResponse
+	err = json.Unmarshal(raw, &resp)
+	if err != nil {
+		b.logger().Error(err.Error())
+		return nil, err
+	}
+	if err := resp.err("set scenario"); err != nil {
+		return nil, err
+	}
+	return resp.Payload.TimePeriod, nil
+}
+
+func (b *HTTPBackend) KPI(id string) (map[string]float64, error) {
+	resp, err := httpGet(b.ctx(), b.client(), b.url("kpi", id))
+	if err != nil {
+		return nil, err
+	}
+
+	var kpiResp KPIResponse
+	err = json.Unmarshal(resp.Body, &kpiResp)
+	if err != nil {
+		b.logger().Error(err.Error())
+		return nil, err
+	}
+	if err := kpiResp.err("get kpis"); err != nil {
+		return nil, err
+	}
+	return kpiResp.KPI, nil
+}
+
+func (b *HTTPBackend) Results(id string, points []string, start, final float64) (map[string][]float64, error) {
+	payload, err := json.Marshal(ResultsRequest{
+		PointNames: points,
+		StartTime:  start,
+		FinalTime:  final,
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw, err := httpPut(b.ctx(), b.client(), b.url("results", id), ContentType_ApplicationJSON, payload)
+	if err != nil {
+		b.logger().Error(err.Error())
+		return nil, err
+	}
+
+	var resp ResultsResponse
+	err = json.Unmarshal(raw, &resp)
+	if err != nil {
+		b.logger().Error(err.Error())
+		return nil, err
+	}
+	if err := resp.err("get results"); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+// PlantBackend runs every test case it selects on a Plant of its own, made
+// in-process by newPlant.
+type PlantBackend struct {
+	newPlant func(testcase string) (Plant, error)
+	plants   map[string]Plant // keyed by id
+	sync.Mutex
+}
+
+func NewPlantBackend(newPlant func(testcase string) (Plant, error)) *PlantBackend {
+	return &PlantBackend{newPlant: newPlant, plants: make(map[string]Plant)}
+}
+
+func (b *PlantBackend) plant(id string) (Plant, error) {
+	b.Lock()
+	defer b.Unlock()
+	p, ok := b.plants[id]
+	if !ok {
+		return nil, fmt.Errorf("invalid testid: %s", id)
+	}
+	return p, nil
+}
+
+func (b *PlantBackend) Select(testcase string) (string, error) {
+	p, err := b.newPlant(testcase)
+	if err != nil {
+		return "", err
+	}
+	id := fmt.Sprintf("%s-%d", testcase, plantIDs.Add(1))
+	b.Lock()
+	defer b.Unlock()
+	b.plants[id] = p
+	return id, nil
+}
+
+func (b *PlantBackend) Initialize(id string, start, warmup int) (map[string]any, error) {
+	p, err := b.plant(id)
+	if err != nil {
+		return nil, err
+	}
+	return p.Initialize(start, warmup)
+}
+
+func (b *PlantBackend) Advance(id string, inputs map[string]any) (map[string]any, error) {
+	p, err := b.plant(id)
+	if err != nil {
+		return nil, err
+	}
+	return p.Advance(inputs)
+}
+
+func (b *PlantBackend) Inputs(id string) (map[string]PointProperties, error) {
+	p, err := b.plant(id)
+	if err != nil {
+		return nil, err
+	}
+	return p.Inputs(), nil
+}
+
+func (b *PlantBackend) Measurements(id string) (map[string]PointProperties, error) {
+	p, err := b.plant(id)
+	if err != nil {
+		return nil, err
+	}
+	return p.Measurements(), nil
+}
+
+func (b *PlantBackend) Step(id string) (int, error) {
+	p, err := b.plant(id)
+	if err != nil {
+		return 0, err
+	}
+	return p.Step(), nil
+}
+
+func (b *PlantBackend) SetStep(id string, step int) error {
+	p, err := b.plant(id)
+	if err != nil {
+		return err
+	}
+	return p.SetStep(step)
+}
+
+func (b *PlantBackend) Stop(id string) error {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.plants, id)
+	return nil
+}
+
+func (b *PlantBackend) Status(id string) (bool, error) {
+	b.Lock()
+	defer b.Unlock()
+	_, ok := b.plants[id]
+	return ok, nil
+}
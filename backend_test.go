@@ -0,0 +1,131 @@
+package boptest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jamesryancoleman/bos/common"
+)
+
+// a Backend that records the inputs of every advance of the test cases it
+// runs on RC plants
+type recordingBackend struct {
+	*PlantBackend
+	advances []map[string]any
+}
+
+func (b *recordingBackend) Advance(id string, inputs map[string]any) (map[string]any, error) {
+	b.advances = append(b.advances, inputs)
+	return b.PlantBackend.Advance(id, inputs)
+}
+
+func TestBackend(t *testing.T) {
+	b := &recordingBackend{PlantBackend: NewPlantBackend(func(testcase string) (Plant, error) {
+		return NewRCPlant(DefaultRCConfig(HVACFor(testcase)))
+	})}
+
+	s := NewServer("127.0.0.1:0", nil)
+	testCase, err := s.CreateTestCase("hp", "bestest_hydronic_heat_pump", WithBackend(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	defer s.Shutdown(ctx)
+
+	if err := testCase.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if !testCase.Status() {
+		t.Fatalf("test case not running")
+	}
+
+	set, err := s.Set(ctx, &common.SetRequest{Pairs: []*common.SetPair{
+		{Key: "boptest://hp/oveTSet_activate", Value: "1"},
+		{Key: "boptest://hp/oveTSet_u", Value: "295.15"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range set.GetPairs() {
+		if p.Error != nil {
+			t.Errorf("%s: %s", p.GetKey(), p.GetErrorMsg())
+		}
+	}
+	if err := testCase.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.advances) != 1 || b.advances[0]["oveTSet_u"] != "295.15" {
+		t.Errorf("advances are %v", b.advances)
+	}
+
+	get, err := s.Get(ctx, &common.GetRequest{Keys: []string{"boptest://hp/reaTSetHea_y"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := get.GetPairs()[0].GetValue(); v != "295.15" {
+		t.Errorf("reaTSetHea_y = %q, want 295.15", v)
+	}
+
+	// the plant backend has no scenarios, kpis or results
+	if err := testCase.SetScenario(Scenario{TimePeriod: "peak_heat_day"}); err == nil {
+		t.Errorf("expected an error setting a scenario")
+	}
+	if _, err := testCase.KPI(); err == nil {
+		t.Errorf("expected an error getting kpis")
+	}
+
+	if err := s.StopTestCase("hp"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := b.Status(testCase.ID); ok {
+		t.Errorf("test case still running on the backend after stop")
+	}
+}
+
+func TestHTTPBackend(t *testing.T) {
+	fake := startFake(t)
+	b := &HTTPBackend{Host: fake.Host()}
+
+	if _, err := b.Select("not_a_test_case"); err == nil {
+		t.Errorf("expected an error selecting an unknown test case")
+	}
+	id, err := b.Select(testcase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var _ ScenarioBackend = b
+	var _ KPIBackend = b
+	var _ ResultsBackend = b
+
+	state, err := b.Initialize(id, 3600, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state["time"] != 3600.0 {
+		t.Errorf("time is %v", state["time"])
+	}
+	if err := b.SetStep(id, 60); err != nil {
+		t.Fatal(err)
+	}
+	if step, _ := b.Step(id); step != 60 {
+		t.Errorf("step is %d, want 60", step)
+	}
+	if _, err := b.Advance(id, map[string]any{"not_a_point_u": 1}); err == nil {
+		t.Errorf("expected an error advancing with an unknown input")
+	}
+	if ok, err := b.Status(id); !ok || err != nil {
+		t.Errorf("status is %v %v", ok, err)
+	}
+	if err := b.Stop(id); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := b.Status(id); ok {
+		t.Errorf("running after stop")
+	}
+	if _, err := b.KPI(id); err == nil {
+		t.Errorf("expected an error getting the kpis of a stopped test case")
+	}
+	if _, err := b.Results(id, []string{"reaTZon_y"}, 3600, 7200); err == nil {
+		t.Errorf("expected an error getting the results of a stopped test case")
+	}
+}
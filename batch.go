@@ -0,0 +1,298 @@
+package boptest
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sweep runs an experiment for every combination of test cases, scenarios
+// and setpoint offsets, several at a time, e.g. on a BOPTEST service hosting
+// many test cases. A run that fails is retried, one that takes too long is
+// cancelled, and every test case selected is stopped.
+type Sweep struct {
+	Name       string     `json:"name" yaml:"name"`
+	Experiment Experiment `json:"experiment" yaml:"experiment"` // run for every combination
+
+	TestCases []string   `json:"test_cases" yaml:"test_cases"` // the experiment's if empty
+	Scenarios []Scenario `json:"scenarios" yaml:"scenarios"`   // the experiment's if empty
+	Offsets   []float64  `json:"offsets" yaml:"offsets"`       // added to the override values written to OffsetInputs
+	// path.Match patterns, e.g. oveTSet*_u
+	OffsetInputs []string `json:"offset_inputs" yaml:"offset_inputs"`
+
+	Parallel int     `json:"parallel" yaml:"parallel"` // runs at once, 1 if 0
+	Retries  int     `json:"retries" yaml:"retries"`   // of a failed run
+	Timeout  float64 `json:"timeout" yaml:"timeout"`   // wall seconds per attempt, 0 for none
+}
+
+// LoadSweep reads a sweep from a yaml or json file and validates it. Its
+// experiment's host is overridden by BOPTEST_HOST if set.
+func LoadSweep(path string) (*Sweep, error) {
+	var s Sweep
+	if err := decodeFile(path, &s); err != nil {
+		return nil, err
+	}
+
+	if host, ok := os.LookupEnv(EnvHost); ok {
+		s.Experiment.Host = host
+	}
+	s.SetDefaults()
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// SetDefaults names the sweep after its experiment and runs one at a time.
+// The experiment's defaults are those of each test case, filled in by Expand.
+func (s *Sweep) SetDefaults() {
+	if s.Name == "" {
+		s.Name = orString(s.Experiment.Name, s.Experiment.TestCase)
+	}
+	if s.Experiment.Runs == 0 {
+		s.Experiment.Runs = 1
+	}
+	if s.Parallel == 0 {
+		s.Parallel = 1
+	}
+}
+
+// Validate returns every problem with the sweep, and the experiments it
+// expands to, joined into one error.
+func (s *Sweep) Validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{field}, args...)...))
+	}
+
+	if s.Parallel < 0 {
+		fail("parallel", "must not be negative")
+	}
+	if s.Retries < 0 {
+		fail("retries", "must not be negative")
+	}
+	if s.Timeout < 0 {
+		fail("timeout", "must not be negative")
+	}
+	for i, p := range s.OffsetInputs {
+		if _, err := path.Match(p, ""); err != nil {
+			fail(fmt.Sprintf("offset_inputs[%d]", i), "%v", err)
+		}
+	}
+	if len(s.Offsets) > 0 && len(s.OffsetInputs) == 0 {
+		fail("offset_inputs", "are required with offsets")
+	}
+	for _, run := range s.Expand() {
+		if err := run.Experiment.Validate(); err != nil {
+			fail("experiment "+run.Experiment.Name, "%v", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// BatchRun is one combination of a sweep: its experiment and, once run, the
+// last attempt at it.
+type BatchRun struct {
+	Experiment Experiment `json:"-"`
+	Scenario   Scenario   `json:"scenario"`
+	Offset     float64    `json:"offset"`
+	Attempts   int        `json:"attempts"`
+
+	ExperimentRun
+}
+
+// Expand returns the runs of the sweep: an experiment named after its
+// combination for each run of every combination.
+func (s *Sweep) Expand() []BatchRun {
+	testCases := s.TestCases
+	if len(testCases) == 0 {
+		testCases = []string{s.Experiment.TestCase}
+	}
+	scenarios := s.Scenarios
+	if len(scenarios) == 0 {
+		scenarios = []Scenario{s.Experiment.Scenario}
+	}
+	offsets := s.Offsets
+	if len(offsets) == 0 {
+		offsets = []float64{0}
+	}
+
+	var runs []BatchRun
+	for _, testCase := range testCases {
+		for _, scenario := range scenarios {
+			for _, offset := range offsets {
+				e := s.Experiment
+				e.TestCase, e.Scenario, e.Runs = testCase, scenario, 1
+				e.Overrides = s.offset(e.Overrides, offset)
+
+				// named by what varies
+				name := []string{s.Name}
+				if len(testCases) > 1 {
+					name = append(name, testCase)
+				}
+				if len(scenarios) > 1 {
+					name = append(name, orString(scenario.TimePeriod, "default"), orString(scenario.ElectricityPrice, "default"))
+				}
+				if len(offsets) > 1 {
+					name = append(name, strconv.FormatFloat(offset, 'f', -1, 64))
+				}
+				e.Name = strings.Join(name, "_")
+				e.SetDefaults()
+
+				for i := 1; i <= s.Experiment.Runs; i++ {
+					runs = append(runs, BatchRun{Experiment: e, Scenario: scenario, Offset: offset,
+						ExperimentRun: ExperimentRun{Experiment: e.Name, Run: i, TestCase: testCase}})
+				}
+			}
+		}
+	}
+	return runs
+}
+
+// returns s, or otherwise if it is empty
+func orString(s, otherwise string) string {
+	if s == "" {
+		return otherwise
+	}
+	return s
+}
+
+// returns a copy of overrides with offset added to the values written to
+// the offset inputs
+func (s *Sweep) offset(overrides []Override, offset float64) []Override {
+	offsetted := make([]Override, len(overrides))
+	for i, o := range overrides {
+		offsetted[i] = Override{At: o.At, Set: maps.Clone(o.Set)}
+		for input, v := range o.Set {
+			if !slices.ContainsFunc(s.OffsetInputs, func(p string) bool { return matches(p, input) }) {
+				continue
+			}
+			if x, err := InputValue(v); err == nil {
+				offsetted[i].Set[input] = x + offset
+			}
+		}
+	}
+	return offsetted
+}
+
+// Run runs the sweep, Parallel runs at a time, writing a results bundle for
+// every attempt and the KPIs of the last attempt at every run, side by side,
+// to kpis.csv in a new directory in dir. Run returns the runs, in the order
+// of Expand, and the errors of those that failed every attempt joined.
+func (s *Sweep) Run(ctx context.Context, dir string, log *slog.Logger) ([]BatchRun, error) {
+	log = orDefault(log)
+	dir = filepath.Join(dir, fmt.Sprintf("%s-%s", s.Name, time.Now().Format("20060102T150405")))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	runs := s.Expand()
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range max(s.Parallel, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				s.attempt(ctx, dir, &runs[i], log)
+			}
+		}()
+	}
+	for i := range runs {
+		if ctx.Err() != nil {
+			runs[i].Error = fmt.Sprintf("not run: %v", ctx.Err())
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var errs []error
+	for _, run := range runs {
+		if run.Error != "" {
+			errs = append(errs, fmt.Errorf("%s run %d: %s", run.Experiment.Name, run.Run, run.Error))
+		}
+	}
+	f, err := os.Create(filepath.Join(dir, "kpis.csv"))
+	if err != nil {
+		return runs, errors.Join(append(errs, err)...)
+	}
+	errs = append(errs, WriteKPITable(f, runs), f.Close(), writeJSON(filepath.Join(dir, "batch.json"), runs))
+	log.Info("sweep finished", "sweep", s.Name, "runs", len(runs), "dir", dir)
+	return runs, errors.Join(errs...)
+}
+
+// runs a combination until it succeeds, it is out of retries or ctx is done
+func (s *Sweep) attempt(ctx context.Context, dir string, run *BatchRun, log *slog.Logger) {
+	for run.Attempts = 1; ; run.Attempts++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if s.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, time.Duration(s.Timeout*float64(time.Second)))
+		}
+		runDir := filepath.Join(dir, fmt.Sprintf("%s-%d-%d", run.Experiment.Name, run.Run, run.Attempts))
+		r, _ := run.Experiment.run(attemptCtx, runDir, run.Run, log)
+		cancel()
+		run.ExperimentRun = r
+		if r.Error == "" || run.Attempts > s.Retries || ctx.Err() != nil {
+			return
+		}
+		log.Warn("retrying experiment run", "experiment", run.Experiment.Name, "run", run.Run,
+			"attempt", run.Attempts, "error", r.Error)
+	}
+}
+
+// WriteKPITable writes the runs to w as CSV, one row each with its test
+// case, scenario, offset, outcome and rejected overrides, and a column for
+// every KPI any of them has.
+func WriteKPITable(w io.Writer, runs []BatchRun) error {
+	kpis := make(map[string]bool)
+	for _, run := range runs {
+		for k := range run.KPI {
+			kpis[k] = true
+		}
+	}
+	names := slices.Sorted(maps.Keys(kpis))
+
+	cw := csv.NewWriter(w)
+	header := []string{"experiment", "run", "test_case", "time_period", "electricity_price", "offset",
+		"attempts", "advances", "error", "rejected"}
+	cw.Write(append(header, names...))
+	for _, run := range runs {
+		row := []string{
+			run.Experiment.Name,
+			strconv.Itoa(run.Run),
+			run.TestCase,
+			run.Scenario.TimePeriod,
+			run.Scenario.ElectricityPrice,
+			strconv.FormatFloat(run.Offset, 'f', -1, 64),
+			strconv.Itoa(run.Attempts),
+			strconv.Itoa(run.Advances),
+			orString(run.Error, run.KPIError),
+			strings.Join(run.Rejected, "; "),
+		}
+		for _, k := range names {
+			v, ok := run.KPI[k]
+			if !ok {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,212 @@
+package boptest
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSweep() *Sweep {
+	s := &Sweep{
+		Experiment: Experiment{
+			TestCaseConfig: TestCaseConfig{Name: "setback", TestCase: "bestest_hydronic_heat_pump", Plant: PlantRC, Step: 900},
+			Duration:       2 * 3600,
+			Overrides: []Override{
+				{At: 0, Set: map[string]any{"oveTSet_activate": 1, "oveTSet_u": 294.15}},
+				{At: 3600, Set: map[string]any{"oveTSet_u": "289.15"}},
+			},
+			Results: []string{"reaTZon_y", "oveTSet_u"},
+			Runs:    2,
+		},
+		Offsets:      []float64{-1, 1},
+		OffsetInputs: []string{"oveTSet*_u"},
+		Parallel:     3,
+	}
+	s.SetDefaults()
+	return s
+}
+
+func TestSweepExpand(t *testing.T) {
+	s := testSweep()
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	runs := s.Expand()
+	if len(runs) != 4 {
+		t.Fatalf("expanded to %d runs, want 2 offsets twice", len(runs))
+	}
+	for i, want := range []struct {
+		name   string
+		run    int
+		offset float64
+		u      float64
+	}{{"setback_-1", 1, -1, 288.15}, {"setback_-1", 2, -1, 288.15}, {"setback_1", 1, 1, 290.15}, {"setback_1", 2, 1, 290.15}} {
+		run := runs[i]
+		if run.Experiment.Name != want.name || run.Run != want.run || run.Offset != want.offset {
+			t.Errorf("run %d is %s run %d offset %v", i, run.Experiment.Name, run.Run, run.Offset)
+		}
+		if u := run.Experiment.Overrides[1].Set["oveTSet_u"]; u != want.u {
+			t.Errorf("run %d sets oveTSet_u to %v, want %v", i, u, want.u)
+		}
+		if a := run.Experiment.Overrides[0].Set["oveTSet_activate"]; a != 1 {
+			t.Errorf("run %d sets oveTSet_activate to %v, which is not offset", i, a)
+		}
+	}
+	if u := s.Experiment.Overrides[1].Set["oveTSet_u"]; u != "289.15" {
+		t.Errorf("the sweep's experiment was changed, oveTSet_u is %v", u)
+	}
+
+	// each test case has the rc plant of its kind
+	s.TestCases = []string{"bestest_hydronic_heat_pump", "bestest_air"}
+	s.Offsets, s.Experiment.Runs = nil, 1
+	runs = s.Expand()
+	if len(runs) != 2 || runs[1].Experiment.Name != "setback_bestest_air" || runs[1].Experiment.RC.HVAC != HVACFanCoil {
+		t.Errorf("expanded test cases to %+v", runs)
+	}
+
+	s = testSweep()
+	s.Parallel, s.Experiment.Duration, s.OffsetInputs = -1, 0, []string{"["}
+	err := s.Validate()
+	for _, want := range []string{"parallel:", "offset_inputs[0]:", "experiment setback_-1: duration:"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v does not mention %s", err, want)
+		}
+	}
+}
+
+func TestSweepRun(t *testing.T) {
+	s := testSweep()
+	dir := t.TempDir()
+	runs, err := s.Run(context.Background(), dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, run := range runs {
+		if run.Error != "" || run.Attempts != 1 || run.Advances != 8 || run.ID == "" {
+			t.Errorf("run is %+v", run)
+		}
+		if _, err := os.Stat(filepath.Join(run.Dir, "summary.json")); err != nil {
+			t.Errorf("bundle: %v", err)
+		}
+	}
+
+	batches, _ := filepath.Glob(filepath.Join(dir, "setback-*"))
+	if len(batches) != 1 {
+		t.Fatalf("batch directories are %v", batches)
+	}
+	if _, err := os.Stat(filepath.Join(batches[0], "batch.json")); err != nil {
+		t.Error(err)
+	}
+	f, err := os.Open(filepath.Join(batches[0], "kpis.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 || rows[0][0] != "experiment" || rows[3][0] != "setback_1" || rows[3][5] != "1" {
+		t.Errorf("kpi table is %v", rows)
+	}
+	// the rc plant has no kpis
+	if !strings.Contains(rows[1][8], "not supported") {
+		t.Errorf("kpi table has error %q", rows[1][8])
+	}
+}
+
+func TestSweepRetry(t *testing.T) {
+	s := testSweep()
+	s.Offsets, s.OffsetInputs, s.Experiment.Runs = nil, nil, 1
+	s.Retries, s.Timeout = 2, 1e-9
+	runs, err := s.Run(context.Background(), t.TempDir(), nil)
+	if err == nil || len(runs) != 1 {
+		t.Fatalf("got %d runs, error %v", len(runs), err)
+	}
+	if run := runs[0]; run.Attempts != 3 || !strings.Contains(run.Error, "deadline exceeded") {
+		t.Errorf("run is %+v", run)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runs, err = s.Run(ctx, t.TempDir(), nil)
+	if err == nil || len(runs) != 1 || runs[0].Attempts != 0 || !strings.Contains(runs[0].Error, "not run") {
+		t.Errorf("cancelled sweep ran %+v, error %v", runs, err)
+	}
+}
+
+func TestLoadSweep(t *testing.T) {
+	s, err := LoadSweep("cmd/experiment/sweep.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "setback-sweep" || s.Parallel != 3 || len(s.Expand()) != 6 {
+		t.Errorf("loaded %+v", s)
+	}
+
+	path := filepath.Join(t.TempDir(), "sweep.json")
+	os.WriteFile(path, []byte(`{"experiment": {"test_case": "bestest_air", "plant": "rc", "duration": 3600}, "offsets": [1], "retries": -1}`), 0644)
+	_, err = LoadSweep(path)
+	for _, want := range []string{"retries:", "offset_inputs:"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v does not mention %s", err, want)
+		}
+	}
+}
+
+func TestSweepTimeoutHungBackend(t *testing.T) {
+	fake := startFake(t)
+	// advances never return, until the request is cancelled or the test ends
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/advance/") {
+			io.Copy(io.Discard, r.Body) // so that a cancelled request is noticed
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		fake.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	s := &Sweep{
+		Experiment: Experiment{
+			Host:           strings.TrimPrefix(hung.URL, "http://"),
+			TestCaseConfig: TestCaseConfig{Name: "hung", TestCase: "bestest_air", Step: 900},
+			Duration:       3600,
+		},
+		Retries: 1,
+		Timeout: 0.2,
+	}
+	s.SetDefaults()
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	runs, err := s.Run(context.Background(), t.TempDir(), nil)
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("sweep took %v with a 0.2s timeout", took)
+	}
+	if err == nil || len(runs) != 1 {
+		t.Fatalf("got %d runs, error %v", len(runs), err)
+	}
+	run := runs[0]
+	if run.Attempts != 2 || !strings.Contains(run.Error, "deadline exceeded") || run.Advances != 0 {
+		t.Errorf("run is %+v", run)
+	}
+	// the test case of the last attempt was stopped
+	if run.ID == "" || fake.Running(run.ID) {
+		t.Errorf("test case %q of a timed out attempt still running", run.ID)
+	}
+}
@@ -1,17 +1,15 @@
 package boptest
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"math"
 	"net/http"
-	"os"
 	"regexp"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"maps"
@@ -20,11 +18,9 @@ import (
 var (
 	Host = "0.0.0.0"
 
-	termLogLevel = new(slog.LevelVar)
-	fileLogLevel = new(slog.LevelVar)
-
-	TermLog *slog.Logger
-	FileLog *slog.Logger
+	// Client makes every request to the boptest server. Its Transport can be
+	// replaced, e.g. with a Recorder or Replayer.
+	Client = &http.Client{}
 
 	schemaRe = regexp.MustCompile(`^boptest://(?P<testCase>[a-zA-Z0-9\_\-.]*)/(?P<point>[a-zA-Z0-9\_\-.]+)$`)
 )
@@ -37,32 +33,9 @@ const (
 
 	DefaultStep       = 3600 // 1 hour, per BOPTEST
 	DefaultUpdateFreq = 1    // seecond
-)
-
-// called when the package is imported
-func init() {
-	// You can set the logging level programmatically, from a config file, or env variable.
-	termLogLevel.Set(slog.LevelDebug)
-	fileLogLevel.Set(slog.LevelDebug)
-
-	// standard terminal logger
-	TermLog = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level: termLogLevel, // this can be set programmatically
-	}))
-
-	// create a file, if it doesn't exist, and write json log there
-	file, err := os.OpenFile("boptest_log.json", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		TermLog.Error("Failed to open log file", "error", err)
-		os.Exit(1)
-	}
-	// assign the terminal logger
-	FileLog = slog.New(slog.NewJSONHandler(file, &slog.HandlerOptions{
-		Level: fileLogLevel, // this can be set programmatically
-	}))
 
-	TermLog.Info("TZ confirmation", "TZ", time.Local)
-}
+	DefaultHistoryLength = 24 * 60 // states kept by each TestCase
+)
 
 // a concurrency safe map
 type SafeMap struct {
@@ -89,12 +62,24 @@ func (m *SafeMap) SetAll(values map[string]any) {
 	maps.Copy(m.data, values)
 }
 
+func (m *SafeMap) Delete(key string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.data, key)
+}
+
 func (m *SafeMap) Clear() {
 	m.Lock()
 	defer m.Unlock()
 	clear(m.data)
 }
 
+func (m *SafeMap) Len() int {
+	m.RLock()
+	defer m.RUnlock()
+	return len(m.data)
+}
+
 func (m *SafeMap) GetAll() map[string]any {
 	m.RLock()
 	defer m.RUnlock()
@@ -171,17 +156,33 @@ func (m *StateMap) Time() (time.Time, error) {
 		return time.Now(), fmt.Errorf("could not cast time as float")
 	}
 
-	// return time.Time using seconds
-	currentYear := time.Date(time.Now().Year(), 1, 1, 0, 0, int(seconds), 0, time.Local)
-	return currentYear, nil
+	return SimTime(seconds), nil
+}
+
+// SimTime maps seconds since the start of the simulation year onto the
+// current year in local time.
+func SimTime(seconds float64) time.Time {
+	return time.Date(time.Now().Year(), 1, 1, 0, 0, int(seconds), 0, time.Local)
+}
+
+// SimSeconds is the inverse of SimTime.
+func SimSeconds(t time.Time) float64 {
+	start := time.Date(time.Now().Year(), 1, 1, 0, 0, 0, 0, time.Local)
+	return t.Sub(start).Seconds()
 }
 
 type TestCase struct {
 	ID   string `json:"testid"`
+	Name string `json:"-"` // the name of the boptest test case, e.g. bestest_air
 	Host string `json:"-"`
 
-	stopCh chan struct{} `json:"-"`
-	ticker *time.Ticker  `json:"-"`
+	stopCh  chan struct{} `json:"-"`
+	done    chan struct{} `json:"-"` // closed when the run loop exits
+	stopErr error         `json:"-"` // returned by the run loop's stop
+	ticker  *time.Ticker  `json:"-"`
+
+	advanceMu sync.Mutex  `json:"-"` // held while advancing the simulation
+	pending   atomic.Bool `json:"-"` // true if inputs were set since the last advance
 
 	startNow bool `json:"-"`
 
@@ -195,13 +196,74 @@ type TestCase struct {
 	StartTime int `json:"start_time"`    // seconds since start of year
 	WarmUp    int `json:"warmup_period"` // seconds before startTime
 
-	State StateMap `json:"-"`
+	State          StateMap        `json:"-"`
+	History        *History        `json:"-"` // past states, oldest first
+	SensorFaults   *SensorFaults   `json:"-"` // corrupt what clients read, nil for none
+	ActuatorFaults *ActuatorFaults `json:"-"` // corrupt what clients write, nil for none
+	Safety         *Safety         `json:"-"` // protects the building from what clients write, nil for none
+
+	historyLength int `json:"-"`
+
+	scenario  Scenario  `json:"-"`
+	writeMode WriteMode `json:"-"`
 
-	writeBuffer SafeMap `json:"-"`
+	writeBuffer SafeMap       `json:"-"`
+	writeMu     sync.Mutex    `json:"-"` // held while writing to or flushing the write buffer, to keep writes in step
+	writes      []AuditRecord `json:"-"` // audited since the last advance
+	heldSince   float64       `json:"-"` // simulated time the inputs in State were applied at, guarded by writeMu
+
+	inputs   map[string]PointProperties `json:"-"` // of the backend, fetched on the first write
+	inputsMu sync.Mutex                 `json:"-"`
+
+	backend Backend         `json:"-"` // simulates the test case, the boptest server at Host by default
+	ctx     context.Context `json:"-"` // of the requests to the default backend, none if nil
+	series  *SeriesRecorder `json:"-"` // records every advance, nil for none
+
+	controllers []Controller `json:"-"` // stepped after every advance
+
+	log        *slog.Logger                `json:"-"` // the default logger if nil
+	metrics    atomic.Pointer[caseMetrics] `json:"-"` // set when registered on a server with metrics
+	audit      atomic.Pointer[AuditLog]    `json:"-"` // records every write, nil for none
+	registered atomic.Pointer[string]      `json:"-"` // the name the test case is registered with on a server, Name if nil
+	progressed atomic.Int64                `json:"-"` // unix nanoseconds when the simulated time last increased
+}
+
+// WriteMode determines how long an input is applied for.
+type WriteMode string
+
+const (
+	WriteOnce  WriteMode = "once"  // inputs are applied to the next advance only
+	WriteLatch WriteMode = "latch" // inputs are applied until overwritten
+)
+
+// Scenario is a BOPTEST scenario. A time period replaces the start time and
+// warm up of the test case.
+type Scenario struct {
+	TimePeriod       string `json:"time_period,omitempty" yaml:"time_period"`             // e.g. peak_heat_day
+	ElectricityPrice string `json:"electricity_price,omitempty" yaml:"electricity_price"` // constant, dynamic or highly_dynamic
+}
+
+type ScenarioResponse struct {
+	JSONResponse
+	Payload struct {
+		TimePeriod       map[string]any `json:"time_period"`
+		ElectricityPrice string         `json:"electricity_price"`
+	} `json:"payload"`
 }
 
 type testCaseOption func(*TestCase)
 
+// log to l rather than the default logger, see slog.SetDefault
+func WithLogger(l *slog.Logger) testCaseOption {
+	return func(c *TestCase) {
+		c.log = l
+	}
+}
+
+func (c *TestCase) logger() *slog.Logger {
+	return orDefault(c.log)
+}
+
 // seconds since start of year
 func WithHost(addr string) testCaseOption {
 	return func(c *TestCase) {
@@ -238,12 +300,41 @@ func WithUpdateFrequency(d int) testCaseOption {
 	}
 }
 
+// number of past states to keep, 0 disables the history
+func WithHistoryLength(n int) testCaseOption {
+	return func(c *TestCase) {
+		c.historyLength = n
+	}
+}
+
+// the scenario to run, set when the test case is started
+func WithScenario(sc Scenario) testCaseOption {
+	return func(c *TestCase) {
+		c.scenario = sc
+	}
+}
+
+// how long inputs are applied for, WriteOnce by default
+func WithWriteMode(m WriteMode) testCaseOption {
+	return func(c *TestCase) {
+		c.writeMode = m
+	}
+}
+
 func WithStartNow() testCaseOption {
 	return func(c *TestCase) {
 		c.startNow = true
 	}
 }
 
+// cancel the requests to the boptest server at Host when ctx is done, but
+// the one stopping the test case
+func WithContext(ctx context.Context) testCaseOption {
+	return func(c *TestCase) {
+		c.ctx = ctx
+	}
+}
+
 type HTTPResponse struct {
 	Status string
 	Body   []byte
@@ -281,6 +372,22 @@ type RunningResponse struct {
 	Step int `json:"payload"`
 }
 
+type KPIResponse struct {
+	JSONResponse
+	KPI map[string]float64 `json:"payload"`
+}
+
+type ResultsRequest struct {
+	PointNames []string `json:"point_names"`
+	StartTime  float64  `json:"start_time"`
+	FinalTime  float64  `json:"final_time"`
+}
+
+type ResultsResponse struct {
+	JSONResponse
+	Results map[string][]float64 `json:"payload"`
+}
+
 type ErrorList struct {
 	Errors []BoptestError
 }
@@ -293,71 +400,33 @@ type BoptestError struct {
 }
 
 func Get(url string) (HTTPResponse, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		TermLog.Error(err.Error())
-		FileLog.Error(err.Error())
-		return HTTPResponse{}, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		TermLog.Error(err.Error())
-	}
-	return HTTPResponse{
-		Status: resp.Status,
-		Body:   body,
-	}, nil
+	return httpGet(context.Background(), Client, url)
 }
 
 func Put(url, contentType string, payload []byte) ([]byte, error) {
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(payload))
-	if err != nil {
-		return []byte{}, err
-	}
-	req.Header.Set("Content-Type", contentType)
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return []byte{}, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		TermLog.Error(err.Error())
-	}
-	return body, nil
+	return httpPut(context.Background(), Client, url, contentType, payload)
 }
 
-func Post(url, contentType string, payload []byte) []byte {
-	postBody := bytes.NewBuffer(payload)
-	resp, err := http.Post(url, contentType, postBody)
-	if err != nil {
-		TermLog.Error(err.Error())
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		TermLog.Error(err.Error())
-	}
-	return body
+func Post(url, contentType string, payload []byte) ([]byte, error) {
+	return httpPost(context.Background(), Client, url, contentType, payload)
 }
 
 // takes the name of the testcase and returns the test id.
 func NewTestCase(testcase string, opts ...testCaseOption) (*TestCase, error) {
 	var c = &TestCase{}
 	// initialize fields
+	c.Name = testcase
 	c.Host = Host // holds a default value
 	c.State = StateMap{
 		data: make(map[string]any),
 	}
 	c.step = DefaultStep
 	c.updateFreq = DefaultUpdateFreq
+	c.historyLength = DefaultHistoryLength
+	c.writeMode = WriteOnce
 
 	c.stopCh = make(chan struct{})
+	c.done = make(chan struct{})
 	c.Created = time.Now()
 
 	// apply optional parameters
@@ -365,40 +434,38 @@ func NewTestCase(testcase string, opts ...testCaseOption) (*TestCase, error) {
 	for _, opt := range opts {
 		opt(c)
 	}
+	c.History = NewHistory(c.historyLength)
 
-	url := fmt.Sprintf("http://%s/testcases/%s/select", c.Host, testcase)
-
-	postBody := bytes.NewBuffer([]byte{})
-	resp, err := http.Post(url, "text/raw", postBody)
-	if err != nil {
-		TermLog.Error(err.Error())
-		return nil, err
+	if c.backend == nil {
+		c.backend = &HTTPBackend{Host: c.Host, Logger: c.log, Context: c.ctx}
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	fmt.Println(string(body))
+	id, err := c.backend.Select(testcase)
 	if err != nil {
-		TermLog.Error(err.Error())
+		c.logger().Error(err.Error())
 		return nil, err
 	}
+	c.ID = id
 
-	err = json.Unmarshal(body, &c)
-	if err != nil {
-		TermLog.Error(err.Error())
+	c.logger().Info("created test case", "id", c.ID, "time", c.Created.String())
+	if err := c.begin(); err != nil {
+		// without a run loop, Shutdown stops the selected test case itself
+		close(c.done)
 		return c, err
 	}
+	return c, nil
+}
 
-	FileLog.Info("created test case", "id", c.ID, "time", c.Created.String())
-
+// sets the step and starts the run loop of a selected test case
+func (c *TestCase) begin() error {
 	// set the step if its not the default
 	if c.step != DefaultStep {
 		// because advance moves the simluation forward at the rate of c.step
 		_step := int(math.Round(float64(c.step) * float64(c.updateFreq)))
 		err := c.SetStep(_step)
 		if err != nil {
-			FileLog.Error("unable to set step", "test_case", c.ID)
-			return c, err
+			c.logger().Error("unable to set step", "test_case", c.ID)
+			return err
 		}
 	}
 
@@ -409,128 +476,111 @@ func NewTestCase(testcase string, opts ...testCaseOption) (*TestCase, error) {
 
 	go c.run()
 
-	return c, nil
+	return nil
 }
 
+// StopTestCase stops a test case on the boptest server at Host.
 func StopTestCase(testId string) error {
-	url := fmt.Sprintf("http://%s/stop/%s", Host, testId)
-	_, err := Put(url, "", []byte{})
-	if err != nil {
-		return err
-	}
-	// fmt.Println(string(resp))
-	return nil
+	return (&HTTPBackend{Host: Host}).Stop(testId)
 }
 
 func (c *TestCase) stop() error {
 	if c.ticker != nil {
 		c.ticker.Stop()
 	}
-	err := StopTestCase(c.ID)
+	if !c.Stopped.IsZero() {
+		return nil // already stopped
+	}
+	err := c.backend.Stop(c.ID)
 	if err != nil {
 		return err
 	}
 	c.Stopped = time.Now()
-	FileLog.Info("stopped test case", "id", c.ID, "time", c.Stopped.String())
+	c.abandonWrites("test case stopped before the next advance")
+	c.logger().Info("stopped test case", "id", c.ID, "time", c.Stopped.String())
 	return nil
 }
 
-func (c *TestCase) Stop() {
-	// TermLog.Info("s†op called")
-	c.stopCh <- struct{}{}
-	// TermLog.Info("first send on stop chan")
-	<-c.stopCh // unblocked by the run loop
+// the name the test case is registered with on a server, which the keys and
+// records of its points are under, or the name of the boptest test case
+func (c *TestCase) registeredName() string {
+	if name := c.registered.Load(); name != nil {
+		return *name
+	}
+	return c.Name
 }
 
-// takes the testid and returns all possible measurements
-func measurements(testid string) (map[string]PointProperties, error) {
-	url := fmt.Sprintf("http://%s/measurements/%s", Host, testid)
-	// fmt.Println(url)
-
-	resp, err := http.Get(url)
+func (c *TestCase) Stop() {
+	err := c.Shutdown(context.Background())
 	if err != nil {
-		TermLog.Error(err.Error())
+		c.logger().Error("unable to stop", "test_case", c.ID, "error", err)
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.Status == HTTPStatus_BadRequest {
-		fmt.Printf("'%s'\n", resp.Status) // turn this to a log
-		return nil, fmt.Errorf("%s", resp.Status)
+// Shutdown stops the run loop and the boptest test case, waiting until both
+// are done or ctx expires.
+func (c *TestCase) Shutdown(ctx context.Context) error {
+	select {
+	case c.stopCh <- struct{}{}:
+	case <-c.done:
+		// the run loop has already exited
+		return c.stop()
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	select {
+	case <-c.done: // the run loop stopped the test case and exited
+		return c.stopErr
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-
-	var r PointsResponse
-	if err := json.Unmarshal(body, &r); err != nil {
-		return nil, err
-	}
-
-	return r.Payload, nil
 }
 
 func (c *TestCase) Measurements() (map[string]PointProperties, error) {
-	return measurements(c.ID)
-}
-
-func inputs(testid string) (map[string]PointProperties, error) {
-	url := fmt.Sprintf("http://%s/inputs/%s", Host, testid)
-	// fmt.Println(url)
-
-	resp, err := Get(url)
-	if err != nil {
-		return nil, err
-	}
-
-	var r PointsResponse
-	if err := json.Unmarshal(resp.Body, &r); err != nil {
-		panic(err)
-	}
-	return r.Payload, nil
+	return c.backend.Measurements(c.ID)
 }
 
 func (c *TestCase) Inputs() (map[string]PointProperties, error) {
-	return inputs(c.ID)
+	return c.backend.Inputs(c.ID)
 }
 
 // the TestCase gets a ticker assigned and the that activates the run loop
 // that was created with NewTestCase().
 func (c *TestCase) Start() error {
 	// define t=0 and start simulation
-	payload, err := json.Marshal(c)
+	state, err := c.backend.Initialize(c.ID, c.StartTime, c.WarmUp)
 	if err != nil {
-		FileLog.Error(err.Error())
+		c.logger().Error(err.Error())
 		return err
 	}
+	c.State.SetAll(state)
+	c.History.Add(state)
+	c.metrics.Load().initialized(state)
+	c.writeMu.Lock()
+	c.heldSince, _ = state["time"].(float64)
+	c.writeMu.Unlock()
+	c.progressed.Store(time.Now().UnixNano())
 
-	url := fmt.Sprintf("http://%s/initialize/%s", Host, c.ID)
-	b, err := Put(url, "application/json", payload)
-	if err != nil {
-		FileLog.Error(err.Error())
-		return err
-	}
+	c.logger().Info("intialized test case", "id", c.ID, "time", c.Stopped.String())
 
-	var resp StateUpdate
-	err = json.Unmarshal(b, &resp)
-	if err != nil {
-		FileLog.Error(err.Error())
-		return err
+	if c.scenario != (Scenario{}) {
+		err = c.SetScenario(c.scenario)
+		if err != nil {
+			return err
+		}
 	}
-	c.State.SetAll(resp.State)
-
-	FileLog.Info("intialized test case", "id", c.ID, "time", c.Stopped.String())
+	c.stepControllers()
 
 	// start a ticker
 	if c.ticker != nil {
-		FileLog.Warn("start called on running simulation")
+		c.logger().Warn("start called on running simulation")
 		return nil
 	}
 
 	d := time.Duration(c.updateFreq * int(time.Second))
 	c.ticker = time.NewTicker(d)
-	FileLog.Debug("ticker started", "interval", d)
+	c.logger().Debug("ticker started", "interval", d)
 
 	return nil
 }
@@ -538,53 +588,125 @@ func (c *TestCase) Start() error {
 // run should be called on a gorountine and will wait for 1 time step to call
 // then start working in a loop.
 func (c *TestCase) run() {
-	TermLog.Debug("waiting for second time step", "step_duration", c.step)
+	defer close(c.done)
+	c.logger().Debug("waiting for second time step", "step_duration", c.step)
 	for {
 		select {
 		case <-c.ticker.C:
-			inputs := c.writeBuffer.Flush() // may be empty
-			// TermLog.Debug("flushed write buffer", "data", inputs)
-			newState, err := advance(c.ID, inputs)
+			// a failed advance is retried on the next tick
+			err := c.advanceOnce()
 			if err != nil {
-				FileLog.Error("unable to advance", "test_case", c.ID)
-				return
+				c.logger().Error("unable to advance", "test_case", c.ID, "error", err)
 			}
-			c.State.SetAll(newState)
-			// TermLog.Debug("state update", "new_state", newState)
 		case <-c.stopCh:
-			err := c.stop()
-			if err != nil {
-				FileLog.Error("unable to stop", "test_case", c.ID)
+			c.stopErr = c.stop()
+			if c.stopErr != nil {
+				c.logger().Error("unable to stop", "test_case", c.ID, "error", c.stopErr)
 			}
-			c.stopCh <- struct{}{}
 			return
 		}
 	}
 }
 
-func (c *TestCase) SetInput(key string, value any) {
-	TermLog.Info("setting input", key, value)
-	c.writeBuffer.Set(key, value)
-}
+// advanceOnce flushes the write buffer into an advance of the simulation and
+// updates the state with the result.
+func (c *TestCase) advanceOnce() error {
+	c.advanceMu.Lock()
+	defer c.advanceMu.Unlock()
 
-// advance takes a testCaseID and a map of inputs to use at the next timestep.
-// The map may be empty.
-func advance(testCaseID string, inputs map[string]any) (map[string]any, error) {
-	url := fmt.Sprintf("http://%s/advance/%s", Host, testCaseID)
-	payload, err := json.Marshal(inputs)
+	start := time.Now()
+	err := c.advance()
 	if err != nil {
-		return map[string]any{}, err
+		c.metrics.Load().failed()
+		return err
 	}
-	TermLog.Debug("making advance request", "payload", string(payload))
-	raw := Post(url, "application/json", payload)
-	var resp StateUpdate
-	err = json.Unmarshal(raw, &resp)
+	c.metrics.Load().advanced(time.Since(start), c.State.GetAll())
+	return nil
+}
+
+// advance does the work of advanceOnce, with advanceMu held
+func (c *TestCase) advance() error {
+	c.pending.Store(false)
+	var inputs map[string]any // may be empty
+	c.writeMu.Lock()
+	if c.writeMode == WriteLatch {
+		inputs = c.writeBuffer.GetAll()
+	} else {
+		inputs = c.writeBuffer.Flush()
+	}
+	writes := c.writes
+	c.writes = nil
+	c.writeMu.Unlock()
+	c.metrics.Load().buffered(c.writeBuffer.Len())
+	// TermLog.Debug("flushed write buffer", "data", inputs)
+	t, _ := c.State.Get("time").(float64)
+	allowed, dropped := c.Safety.Apply(inputs, t)
+	for input, err := range dropped {
+		c.logger().Warn("input dropped by safety rules", "test_case", c.ID, "input", input, "error", err)
+	}
+	if c.writeMode == WriteLatch && c.Safety.Tripped() {
+		// release the latched inputs until the controller writes again
+		c.writeMu.Lock()
+		if c.Safety.Tripped() {
+			c.writeBuffer.Clear()
+		}
+		c.writeMu.Unlock()
+	}
+	applied, faulted := c.ActuatorFaults.Apply(allowed, t)
+	for input, err := range faulted {
+		c.logger().Warn("input dropped by actuator faults", "test_case", c.ID, "input", input, "error", err)
+	}
+	if c.ActuatorFaults != nil && !maps.Equal(applied, inputs) {
+		c.logger().Debug("actuator faults applied", "test_case", c.ID, "time", t,
+			"commanded", inputs, "applied", applied)
+	}
+	newState, err := c.backend.Advance(c.ID, applied)
+	c.settle(writes, applied, dropped, err)
 	if err != nil {
-		FileLog.Error(err.Error())
-		TermLog.Error(err.Error(), "payload", string(raw))
-		return nil, err
+		return err
 	}
-	return resp.State, nil
+	if next, _ := newState["time"].(float64); next > t {
+		c.progressed.Store(time.Now().UnixNano())
+	}
+	c.State.SetAll(newState)
+	c.History.AddInputs(newState, inputs, applied)
+	c.writeMu.Lock()
+	c.heldSince = t
+	c.writeMu.Unlock()
+	t, _ = newState["time"].(float64)
+	rec := SeriesRecord{
+		TestCase: c.registeredName(),
+		ID:       c.ID,
+		Time:     t,
+		Wall:     time.Now(),
+		Inputs:   applied,
+		State:    newState,
+	}
+	if !maps.Equal(applied, inputs) {
+		rec.Commanded = inputs
+	}
+	if err := c.series.Record(rec); err != nil {
+		c.logger().Error("unable to record advance", "test_case", c.ID, "error", err)
+	}
+	// TermLog.Debug("state update", "new_state", newState)
+	c.stepControllers()
+	return nil
+}
+
+// Drain advances the simulation once if there are inputs waiting in the write
+// buffer, so that writes made just before stopping are applied.
+func (c *TestCase) Drain() error {
+	if !c.pending.Load() {
+		return nil
+	}
+	return c.advanceOnce()
+}
+
+// SetInput writes value to the input key in the next advance, or returns an
+// error if key is not an input, value is not a number or it breaks a safety
+// rule.
+func (c *TestCase) SetInput(key string, value any) error {
+	return c.setInput(key, value, AuditRecord{Caller: "direct"})
 }
 
 // func setInputs(testCaseID string, m map[string]string) error {
@@ -605,75 +727,69 @@ func advance(testCaseID string, inputs map[string]any) (map[string]any, error) {
 // 	return nil
 // }
 
-func step(testID string) (int, error) {
-	url := fmt.Sprintf("http://%s/step/%s", Host, testID)
-
-	resp, err := Get(url)
-	if err != nil {
-		FileLog.Error(err.Error())
-		return 0, err
-	}
-
-	var stepResp SetStepResponse
-	err = json.Unmarshal(resp.Body, &stepResp)
-	if err != nil {
-		FileLog.Error(err.Error())
-		return 0, err
-	}
-
-	return stepResp.Step, nil
-}
-
 func (c *TestCase) Step() (int, error) {
-	return step(c.ID)
+	return c.backend.Step(c.ID)
 }
 
-// this should
-func setStep(testID string, step int) error {
-	url := fmt.Sprintf("http://%s/step/%s", Host, testID)
-	raw, err := Put(url, "application/json", fmt.Appendf([]byte{}, "{\"step\": %d}", step))
+func (c *TestCase) SetStep(step int) error {
+	err := c.backend.SetStep(c.ID, step)
 	if err != nil {
-		FileLog.Error(err.Error())
 		return err
 	}
+	c.step = step
+	return nil
+}
 
-	var resp JSONResponse
-	err = json.Unmarshal(raw, &resp)
+// SetScenario sets the scenario of the test case. If it has a time period
+// the simulation is reinitialized at the start of that period.
+func (c *TestCase) SetScenario(sc Scenario) error {
+	b, ok := c.backend.(ScenarioBackend)
+	if !ok {
+		return fmt.Errorf("scenarios are not supported by %T", c.backend)
+	}
+	state, err := b.SetScenario(c.ID, sc)
 	if err != nil {
-		FileLog.Error(err.Error())
 		return err
 	}
 
+	c.scenario = sc
+	if state != nil {
+		c.State.SetAll(state)
+		c.History.Add(state)
+	}
+	c.logger().Info("set scenario", "id", c.ID, "time_period", sc.TimePeriod,
+		"electricity_price", sc.ElectricityPrice)
 	return nil
 }
 
-func (c *TestCase) SetStep(step int) error {
-	err := setStep(c.ID, step)
-	if err != nil {
-		return err
+// KPI returns the core KPIs of the test case, e.g. tdis_tot and ener_tot,
+// computed from the start of the simulation until now.
+func (c *TestCase) KPI() (map[string]float64, error) {
+	b, ok := c.backend.(KPIBackend)
+	if !ok {
+		return nil, fmt.Errorf("kpis are not supported by %T", c.backend)
 	}
-	c.step = step
-	return nil
+	return b.KPI(c.ID)
+}
+
+// Results returns the trajectories of points between start and final, in
+// seconds since the start of the year, keyed by point with a "time" entry.
+func (c *TestCase) Results(points []string, start, final float64) (map[string][]float64, error) {
+	b, ok := c.backend.(ResultsBackend)
+	if !ok {
+		return nil, fmt.Errorf("results are not supported by %T, see History", c.backend)
+	}
+	return b.Results(c.ID, points, start, final)
 }
 
 // True for running, false for an error
 func (c *TestCase) Status() bool {
-	url := fmt.Sprintf("http://%s/status/%s", Host, c.ID)
-	httpResp, err := Get(url)
+	ok, err := c.backend.Status(c.ID)
 	if err != nil {
-		errMsg := err.Error()
-		if strings.HasSuffix(errMsg, "connect: connection refused") {
-			FileLog.Error("fatal: boptest server not running")
-		}
+		c.logger().Error("unable to get status", "test_case", c.ID, "error", err)
 		return false
 	}
-
-	if string(httpResp.Body) != `"Running"` {
-		return false
-	}
-
-	// fmt.Printf("status is: %s\n", string(httpResp.Body))
-	return true
+	return ok
 }
 
 // // the only way to see if something is runnig is to use status.
@@ -714,15 +830,15 @@ func (c *TestCase) Status() bool {
 func TestIdTimeout(testId string) string {
 	url := fmt.Sprintf("http://%s/inputs/%s", Host, testId)
 
-	resp, err := http.Get(url)
+	resp, err := Client.Get(url)
 	if err != nil {
-		TermLog.Error(err.Error())
+		slog.Error(err.Error())
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		TermLog.Error(err.Error())
+		slog.Error(err.Error())
 	}
 
 	fmt.Printf("'%s'\n", resp.Status)
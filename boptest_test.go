@@ -1,10 +1,14 @@
 package boptest
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"os"
 	"testing"
 	"time"
+
+	"github.com/jamesryancoleman/grpc-boptest/fakeboptest"
 )
 
 var (
@@ -13,6 +17,26 @@ var (
 	host     = "0.0.0.0:1025"
 )
 
+// runs the tests against the boptest server at BOPTEST_HOST if it is set and
+// against an in-process fake otherwise
+func TestMain(m *testing.M) {
+	if h, ok := os.LookupEnv(EnvHost); ok {
+		host = h
+		os.Exit(m.Run())
+	}
+
+	points, err := fakeboptest.LoadPoints("msgs/inputs.json", "msgs/measurements.json")
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	fake := fakeboptest.New(fakeboptest.WithPoints("bestest_hydronic_heat_pump", points))
+	host = fake.Host()
+	code := m.Run()
+	fake.Close()
+	os.Exit(code)
+}
+
 // func TestLaunchTestCase(t *testing.T) {
 // 	Host = host
 // 	testID, err := NewTestCase(testcase)
@@ -23,16 +47,6 @@ var (
 // 	fmt.Printf("started test case \"%s\" with id \"%s\".\n", testcase, testID)
 // }
 
-func TestLoggingLevels(t *testing.T) {
-	fmt.Printf("The term log level is %s\n", termLogLevel.Level().String())
-	termLogLevel.Set(slog.LevelDebug)
-	fmt.Printf("The term log level is %s\n", termLogLevel.Level().String())
-	fileLogLevel.Set(slog.LevelWarn)
-	fmt.Printf("The file log level is %s\n", fileLogLevel.Level().String())
-	TermLog.Info("this should print to the terminal")
-	FileLog.Info("this should print log file")
-}
-
 func TestStopTest(t *testing.T) {
 	Host = host
 	fmt.Printf("stopping test case \"%s\"\n", testID)
@@ -70,7 +84,7 @@ func startTestCase() *TestCase {
 	Host = host
 	testCase, err := NewTestCase(testcase)
 	if err != nil {
-		FileLog.Error(err.Error())
+		slog.Error(err.Error())
 		return nil
 	}
 	// fmt.Printf("started test case \"%s\" with id \"%s\" @ %v.\n",
@@ -79,7 +93,7 @@ func startTestCase() *TestCase {
 }
 
 func TestMeasurements(t *testing.T) {
-	TermLog.Info("getting measurements")
+	slog.Info("getting measurements")
 	testCase := startTestCase()
 	if testCase == nil {
 		t.FailNow()
@@ -88,7 +102,7 @@ func TestMeasurements(t *testing.T) {
 
 	m, err := testCase.Measurements()
 	if err != nil {
-		FileLog.Error(err.Error())
+		slog.Error(err.Error())
 		t.FailNow()
 	}
 	for k, p := range m {
@@ -119,12 +133,12 @@ func TestStopWithChannel(t *testing.T) {
 		WithStep(2), // seconds
 	)
 	if err != nil {
-		FileLog.Error(err.Error())
+		slog.Error(err.Error())
 	}
 
 	err = testCase.Start()
 	if err != nil {
-		FileLog.Error(err.Error())
+		slog.Error(err.Error())
 	}
 
 	fmt.Println("sleeping until stop channel send")
@@ -171,8 +185,6 @@ loop:
 }
 
 func TestGetMultiple(t *testing.T) {
-	fileLogLevel.Set(slog.LevelDebug)
-	termLogLevel.Set(slog.LevelDebug)
 	Host = host
 
 	testCase, err := NewTestCase(
@@ -214,8 +226,6 @@ func TestGetMultiple(t *testing.T) {
 }
 
 func TestSet(t *testing.T) {
-	fileLogLevel.Set(slog.LevelDebug)
-	termLogLevel.Set(slog.LevelDebug)
 	Host = host
 
 	testCase, err := NewTestCase(testcase,
@@ -328,13 +338,13 @@ func TestCaseStatus(t *testing.T) {
 		WithStep(2), // seconds
 	)
 	if err != nil {
-		FileLog.Error(err.Error())
+		slog.Error(err.Error())
 	}
 	testCase.Stop()
 
 	err = testCase.Start()
 	if err != nil {
-		FileLog.Error(err.Error())
+		slog.Error(err.Error())
 		t.FailNow()
 	}
 
@@ -352,13 +362,13 @@ func TestCaseStatus(t *testing.T) {
 		WithStep(2), // seconds
 	)
 	if err != nil {
-		FileLog.Error(err.Error())
+		slog.Error(err.Error())
 	}
 	defer testCase.Stop()
 
 	err = testCase.Start()
 	if err != nil {
-		FileLog.Error(err.Error())
+		slog.Error(err.Error())
 		t.FailNow()
 	}
 
@@ -394,7 +404,7 @@ func TestTime(t *testing.T) {
 
 	_time, err := testCase.State.Time()
 	if err != nil {
-		TermLog.Error(err.Error())
+		slog.Error(err.Error())
 		t.Fail()
 	}
 	fmt.Printf("%v\n", _time)
@@ -403,9 +413,181 @@ func TestTime(t *testing.T) {
 
 	_time, err = testCase.State.Time()
 	if err != nil {
-		TermLog.Error(err.Error())
+		slog.Error(err.Error())
 		t.Fail()
 	}
 	fmt.Printf("%v\n", _time)
 
 }
+
+// starts a fake boptest server for the duration of the test, whatever server
+// the other tests run against
+func startFake(t *testing.T) *fakeboptest.Server {
+	fake := fakeboptest.New()
+	Host = fake.Host()
+	t.Cleanup(func() {
+		fake.Close()
+		Host = host
+	})
+	return fake
+}
+
+// a test case that was selected but failed to begin is stopped without a run
+// loop to stop it
+func TestBeginFailed(t *testing.T) {
+	fake := startFake(t)
+	testCase, err := NewTestCase(testcase, WithHost(fake.Host()), WithStep(-60))
+	if err == nil || testCase == nil {
+		t.Fatalf("got %v, %v, want the selected test case and an error", testCase, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := testCase.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if fake.Running(testCase.ID) {
+		t.Errorf("%s still running after shutdown", testCase.ID)
+	}
+}
+
+func TestRunLoopFake(t *testing.T) {
+	fake := startFake(t)
+	testCase, err := NewTestCase(testcase, WithHost(fake.Host()), WithStep(3600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+
+	err = testCase.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := testCase.State.Get("zon_reaTRooAir_y"); v != 293.15 {
+		t.Fatalf("initial zone temperature is %v, want 293.15", v)
+	}
+
+	// nothing is written, so nothing is advanced
+	err = testCase.Drain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Advances(testCase.ID)); n != 0 {
+		t.Fatalf("%d advances without inputs, want 0", n)
+	}
+
+	testCase.SetInput("con_oveTSetHea_activate", 1)
+	testCase.SetInput("con_oveTSetHea_u", 298.15)
+	err = testCase.Drain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	advances := fake.Advances(testCase.ID)
+	if len(advances) != 1 || advances[0]["con_oveTSetHea_u"] != 298.15 {
+		t.Fatalf("advances are %v", advances)
+	}
+	if v := testCase.State.Get("zon_reaTRooAir_y"); v != 298.15 {
+		t.Errorf("zone temperature is %v, want 298.15", v)
+	}
+	if v := testCase.State.Get("time"); v != 3600.0 {
+		t.Errorf("time is %v, want 3600", v)
+	}
+	if n := testCase.History.Len(); n != 2 {
+		t.Errorf("history has %d snapshots, want 2", n)
+	}
+
+	kpi, err := testCase.KPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kpi["ener_tot"] <= 0 {
+		t.Errorf("ener_tot is %v, want > 0", kpi["ener_tot"])
+	}
+
+	results, err := testCase.Results([]string{"zon_reaTRooAir_y"}, 0, 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := results["zon_reaTRooAir_y"]; len(got) != 2 || got[1] != 298.15 {
+		t.Errorf("results are %v", results)
+	}
+
+	testCase.Stop()
+	if fake.Running(testCase.ID) {
+		t.Errorf("test case still running after Stop")
+	}
+}
+
+func TestAdvanceError(t *testing.T) {
+	fake := startFake(t)
+	testCase, err := NewTestCase(testcase, WithHost(fake.Host()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+
+	err = testCase.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testCase.SetInput("not_a_point_u", 1); err == nil {
+		t.Errorf("expected an error writing an unknown input")
+	}
+	if err := testCase.SetInput("con_oveTSetHea_u", "warm"); err == nil {
+		t.Errorf("expected an error writing a value that is not a number")
+	}
+	if n := testCase.writeBuffer.Len(); n != 0 {
+		t.Errorf("rejected writes buffered: %v", testCase.writeBuffer.GetAll())
+	}
+
+	// one that gets past the check fails the advance
+	testCase.writeBuffer.Set("not_a_point_u", 1)
+	testCase.pending.Store(true)
+	if err := testCase.Drain(); err == nil {
+		t.Errorf("expected an error advancing with an unknown input")
+	}
+}
+
+// a Backend on RC plants whose Stop waits until released
+type slowStopBackend struct {
+	*PlantBackend
+	release chan struct{}
+}
+
+func (b *slowStopBackend) Stop(id string) error {
+	<-b.release
+	return b.PlantBackend.Stop(id)
+}
+
+func TestShutdownExpired(t *testing.T) {
+	b := &slowStopBackend{
+		PlantBackend: NewPlantBackend(func(testcase string) (Plant, error) {
+			return NewRCPlant(DefaultRCConfig(HVACFor(testcase)))
+		}),
+		release: make(chan struct{}),
+	}
+	testCase, err := NewTestCase("bestest_hydronic_heat_pump", WithBackend(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the context expires while the run loop is stopping the test case
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := testCase.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want the deadline exceeded", err)
+	}
+	close(b.release)
+	select {
+	case <-testCase.done:
+	case <-time.After(time.Second):
+		t.Fatalf("run loop still running after the test case stopped")
+	}
+	if testCase.Stopped.IsZero() {
+		t.Errorf("test case not stopped")
+	}
+	if err := testCase.Shutdown(context.Background()); err != nil {
+		t.Errorf("shutting down again: %v", err)
+	}
+}
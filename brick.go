@@ -0,0 +1,230 @@
+package boptest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const (
+	BrickNamespace    = "https://brickschema.org/schema/Brick#"
+	BrickRefNamespace = "https://brickschema.org/schema/Brick/ref#"
+	QUDTUnitNamespace = "http://qudt.org/vocab/unit/"
+)
+
+// BOPTEST units to QUDT units
+var qudtUnits = map[string]string{
+	"K":      "K",
+	"degC":   "DEG_C",
+	"W":      "W",
+	"W/m2":   "W-PER-M2",
+	"ppm":    "PPM",
+	"Pa":     "PA",
+	"m":      "M",
+	"m/s":    "M-PER-SEC",
+	"m3/s":   "M3-PER-SEC",
+	"kg/s":   "KiloGM-PER-SEC",
+	"s":      "SEC",
+	"rad":    "RAD",
+	"J":      "J",
+	"1":      "UNITLESS",
+	"%":      "PERCENT",
+	"kWh":    "KiloW-HR",
+	"J/kg":   "J-PER-KiloGM",
+	"kg/kg":  "KiloGM-PER-KiloGM",
+	"Pa.s":   "PA-SEC",
+	"W/m2.K": "W-PER-M2-K",
+}
+
+// BOPTEST component prefixes to Brick equipment and location classes
+var brickComponents = map[string]string{
+	"zon":    "HVAC_Zone",
+	"fcu":    "Fan_Coil_Unit",
+	"ahu":    "AHU",
+	"hp":     "Heat_Pump",
+	"heaPum": "Heat_Pump",
+	"boi":    "Boiler",
+	"chi":    "Chiller",
+	"pum":    "Pump",
+	"fan":    "Fan",
+	"vav":    "VAV",
+	"weaSta": "Weather_Station",
+}
+
+// BrickClass returns the local name of the Brick class that best describes a
+// BOPTEST point, e.g. "Zone_Air_Temperature_Sensor" for "zon_reaTRooAir_y".
+func BrickClass(name string, p PointProperties) string {
+	n := ParsePointName(name)
+	if n.IsActivate() {
+		return "Enable_Command"
+	}
+
+	water := strings.Contains(strings.ToLower(p.Description), "water")
+	medium := "Air"
+	if water {
+		medium = "Water"
+	}
+
+	switch n.Quantity(p.Unit) {
+	case QuantityTemperature:
+		if n.IsSetpoint() || n.IsOverride() {
+			switch {
+			case n.has("Hea") && n.IsZone(), n.has("Hea") && n.Component == "":
+				return "Zone_Air_Heating_Temperature_Setpoint"
+			case n.has("Coo") && n.IsZone(), n.has("Coo") && n.Component == "":
+				return "Zone_Air_Cooling_Temperature_Setpoint"
+			case n.has("Hea"):
+				return "Heating_Temperature_Setpoint"
+			case n.has("Coo"):
+				return "Cooling_Temperature_Setpoint"
+			case n.has("Sup"):
+				return "Supply_" + medium + "_Temperature_Setpoint"
+			case n.has("Ret"):
+				return "Return_" + medium + "_Temperature_Setpoint"
+			case n.IsZone() || n.Component == "":
+				return "Zone_Air_Temperature_Setpoint"
+			}
+			return "Temperature_Setpoint"
+		}
+		switch {
+		case n.has("DewPoi"):
+			return "Dewpoint_Sensor"
+		case n.has("WetBul"):
+			return "Outside_Air_Wet_Bulb_Temperature_Sensor"
+		case n.IsOutside():
+			return "Outside_Air_Temperature_Sensor"
+		case n.has("Sup"):
+			return "Supply_" + medium + "_Temperature_Sensor"
+		case n.has("Ret"):
+			return "Return_" + medium + "_Temperature_Sensor"
+		case n.IsZone():
+			return "Zone_Air_Temperature_Sensor"
+		}
+		return "Temperature_Sensor"
+	case QuantityCO2:
+		return "CO2_Level_Sensor"
+	case QuantityHumidity:
+		if n.IsOutside() {
+			return "Outside_Air_Humidity_Sensor"
+		}
+		return "Relative_Humidity_Sensor"
+	case QuantityPower:
+		return "Electric_Power_Sensor"
+	case QuantityThermalPower:
+		return "Thermal_Power_Sensor"
+	case QuantityIrradiance:
+		return "Solar_Radiance_Sensor"
+	case QuantityFlow:
+		if n.IsOverride() || n.IsSetpoint() {
+			return medium + "_Flow_Setpoint"
+		}
+		if n.has("Sup") {
+			return "Supply_" + medium + "_Flow_Sensor"
+		}
+		return medium + "_Flow_Sensor"
+	case QuantityPressure:
+		return "Pressure_Sensor"
+	case QuantitySpeed:
+		if n.IsOutside() {
+			return "Wind_Speed_Sensor"
+		}
+	case QuantityDirection:
+		if n.IsOutside() {
+			return "Wind_Direction_Sensor"
+		}
+	}
+
+	switch {
+	case n.IsOverride():
+		return "Command"
+	case n.IsSetpoint():
+		return "Setpoint"
+	}
+	return "Sensor"
+}
+
+// escapes a string for use as a turtle literal
+func turtleString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// WriteBrickModel writes a Brick model in RDF Turtle describing the points of
+// testCase. Every point is linked to its boptest:// uri by an external
+// reference so that it can be read and written through the driver.
+func WriteBrickModel(w io.Writer, testCase string, measurements, inputs map[string]PointProperties) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "@prefix brick: <%s> .\n", BrickNamespace)
+	fmt.Fprintf(bw, "@prefix ref: <%s> .\n", BrickRefNamespace)
+	fmt.Fprintf(bw, "@prefix unit: <%s> .\n", QUDTUnitNamespace)
+	fmt.Fprintf(bw, "@prefix rdfs: <http://www.w3.org/2000/01/rdf-schema#> .\n")
+	fmt.Fprintf(bw, "@prefix bldg: <urn:boptest:%s#> .\n\n", testCase)
+
+	fmt.Fprintf(bw, "bldg:building a brick:Building ;\n")
+	fmt.Fprintf(bw, "    rdfs:label %s .\n\n", turtleString(testCase))
+
+	points := make(map[string]PointProperties, len(measurements)+len(inputs))
+	maps.Copy(points, measurements)
+	maps.Copy(points, inputs)
+	names := slices.Sorted(maps.Keys(points))
+
+	// equipment and zones that points belong to
+	components := make(map[string]bool)
+	for _, name := range names {
+		if c := ParsePointName(name).Component; c != "" {
+			components[c] = true
+		}
+	}
+	for _, c := range slices.Sorted(maps.Keys(components)) {
+		class, ok := brickComponents[c]
+		if !ok {
+			class = "Equipment"
+		}
+		fmt.Fprintf(bw, "bldg:%s a brick:%s ;\n", c, class)
+		fmt.Fprintf(bw, "    rdfs:label %s ;\n", turtleString(c))
+		fmt.Fprintf(bw, "    brick:isPartOf bldg:building .\n\n")
+	}
+
+	for _, name := range names {
+		p := points[name]
+		n := ParsePointName(name)
+
+		fmt.Fprintf(bw, "bldg:%s a brick:%s ;\n", name, BrickClass(name, p))
+		fmt.Fprintf(bw, "    rdfs:label %s ;\n", turtleString(name))
+		if p.Description != "" {
+			fmt.Fprintf(bw, "    rdfs:comment %s ;\n", turtleString(p.Description))
+		}
+		if u, ok := qudtUnits[p.Unit]; ok {
+			fmt.Fprintf(bw, "    brick:hasUnit unit:%s ;\n", u)
+		}
+		if n.Component != "" {
+			fmt.Fprintf(bw, "    brick:isPointOf bldg:%s ;\n", n.Component)
+		} else {
+			fmt.Fprintf(bw, "    brick:isPointOf bldg:building ;\n")
+		}
+		fmt.Fprintf(bw, "    ref:hasExternalReference [\n")
+		fmt.Fprintf(bw, "        a ref:TimeseriesReference ;\n")
+		fmt.Fprintf(bw, "        ref:hasTimeseriesId %s ;\n", turtleString(PointURI(testCase, name)))
+		fmt.Fprintf(bw, "    ] .\n\n")
+	}
+
+	return bw.Flush()
+}
+
+// WriteBrickModel writes a Brick model of the test case's measurements and
+// inputs to w, with the keys of the server it is registered with.
+func (c *TestCase) WriteBrickModel(w io.Writer) error {
+	measurements, err := c.Measurements()
+	if err != nil {
+		return err
+	}
+	inputs, err := c.Inputs()
+	if err != nil {
+		return err
+	}
+	return WriteBrickModel(w, c.registeredName(), measurements, inputs)
+}
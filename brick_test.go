@@ -0,0 +1,100 @@
+package boptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func loadPoints(t *testing.T, path string) map[string]PointProperties {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r PointsResponse
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatal(err)
+	}
+	return r.Payload
+}
+
+func TestParsePointName(t *testing.T) {
+	var cases = map[string]PointName{
+		"zon_reaTRooAir_y":       {"zon_reaTRooAir_y", "zon", "reaTRooAir", SuffixMeasurement},
+		"fcu_oveFan_activate":    {"fcu_oveFan_activate", "fcu", "oveFan", SuffixActivate},
+		"oveTSet_u":              {"oveTSet_u", "", "oveTSet", SuffixOverride},
+		"weaSta_reaWeaTDryBul_y": {"weaSta_reaWeaTDryBul_y", "weaSta", "reaWeaTDryBul", SuffixMeasurement},
+	}
+	for name, want := range cases {
+		if got := ParsePointName(name); got != want {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+}
+
+func TestBrickClass(t *testing.T) {
+	var cases = map[string]PointProperties{
+		"zon_reaTRooAir_y":       {Unit: "K", Description: "Zone air temperature"},
+		"con_oveTSetHea_u":       {Unit: "K", Description: "Zone temperature setpoint for heating"},
+		"fcu_oveTSup_u":          {Unit: "K", Description: "Supply air temperature setpoint"},
+		"fcu_oveFan_activate":    {Description: "Activation for fan control signal"},
+		"fcu_reaFloSup_y":        {Unit: "kg/s", Description: "Supply air mass flow rate"},
+		"reaTSup_y":              {Unit: "K", Description: "Supply water temperature to radiant floor"},
+		"weaSta_reaWeaTDryBul_y": {Unit: "K", Description: "Outside drybulb temperature measurement"},
+		"weaSta_reaWeaRelHum_y":  {Unit: "1", Description: "Outside relative humidity measurement"},
+		"reaPHeaPum_y":           {Unit: "W", Description: "Heat pump electrical power"},
+		"reaQFloHea_y":           {Unit: "W", Description: "Floor heating thermal power released to the zone"},
+		"weaSta_reaWeaPAtm_y":    {Unit: "Pa", Description: "Atmospheric pressure measurement"},
+		"oveHeaPumY_u":           {Unit: "1", Description: "Heat pump modulating signal"},
+		"reaCO2RooAir_y":         {Unit: "ppm", Description: "CO2 concentration in the zone"},
+	}
+	var want = map[string]string{
+		"zon_reaTRooAir_y":       "Zone_Air_Temperature_Sensor",
+		"con_oveTSetHea_u":       "Heating_Temperature_Setpoint",
+		"fcu_oveTSup_u":          "Supply_Air_Temperature_Setpoint",
+		"fcu_oveFan_activate":    "Enable_Command",
+		"fcu_reaFloSup_y":        "Supply_Air_Flow_Sensor",
+		"reaTSup_y":              "Supply_Water_Temperature_Sensor",
+		"weaSta_reaWeaTDryBul_y": "Outside_Air_Temperature_Sensor",
+		"weaSta_reaWeaRelHum_y":  "Outside_Air_Humidity_Sensor",
+		"reaPHeaPum_y":           "Electric_Power_Sensor",
+		"reaQFloHea_y":           "Thermal_Power_Sensor",
+		"weaSta_reaWeaPAtm_y":    "Pressure_Sensor",
+		"oveHeaPumY_u":           "Command",
+		"reaCO2RooAir_y":         "CO2_Level_Sensor",
+	}
+	for name, p := range cases {
+		if got := BrickClass(name, p); got != want[name] {
+			t.Errorf("%s: got %s, want %s", name, got, want[name])
+		}
+	}
+}
+
+func TestWriteBrickModel(t *testing.T) {
+	measurements := loadPoints(t, "msgs/measurements.json")
+	inputs := loadPoints(t, "msgs/inputs.json")
+
+	var buf bytes.Buffer
+	err := WriteBrickModel(&buf, "bestest_hydronic_heat_pump", measurements, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := buf.String()
+
+	for _, s := range []string{
+		"bldg:reaTZon_y a brick:Zone_Air_Temperature_Sensor ;",
+		"bldg:oveTSet_activate a brick:Enable_Command ;",
+		"bldg:weaSta a brick:Weather_Station ;",
+		"brick:isPointOf bldg:weaSta ;",
+		`ref:hasTimeseriesId "boptest://bestest_hydronic_heat_pump/oveTSet_u" ;`,
+	} {
+		if !strings.Contains(model, s) {
+			t.Errorf("model is missing %q", s)
+		}
+	}
+	if n := strings.Count(model, "a ref:TimeseriesReference"); n != len(measurements)+len(inputs) {
+		t.Errorf("got %d external references, want %d", n, len(measurements)+len(inputs))
+	}
+}
@@ -0,0 +1,291 @@
+package boptest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Interaction is one request to the boptest server and its response, as
+// stored in a cassette. The host is not stored so that a cassette can be
+// replayed whatever the server was called. Bodies are stored as json when
+// they are json and as text otherwise.
+type Interaction struct {
+	Method       string          `json:"method"`
+	Path         string          `json:"path"` // includes the query, if any
+	Request      json.RawMessage `json:"request,omitempty"`
+	RequestText  string          `json:"request_text,omitempty"`
+	Status       int             `json:"status"`
+	ContentType  string          `json:"content_type,omitempty"`
+	Response     json.RawMessage `json:"response,omitempty"`
+	ResponseText string          `json:"response_text,omitempty"`
+	Time         time.Time       `json:"time"` // when the request was made
+}
+
+// splits a body into its json and text forms, only one of which is set
+func encodeBody(b []byte) (json.RawMessage, string) {
+	switch {
+	case len(bytes.TrimSpace(b)) == 0:
+		return nil, ""
+	case json.Valid(b):
+		return json.RawMessage(b), ""
+	}
+	return nil, string(b)
+}
+
+func decodeBody(raw json.RawMessage, text string) []byte {
+	if raw != nil {
+		return raw
+	}
+	return []byte(text)
+}
+
+// Recorder is an http.RoundTripper that appends every interaction with the
+// boptest server to a cassette file, one json object per line, so that a
+// session can later be replayed. Interactions are written as they happen and
+// survive a crash.
+type Recorder struct {
+	Logger *slog.Logger // defaults to the default logger
+
+	next http.RoundTripper
+	file *os.File
+	enc  *json.Encoder
+	sync.Mutex
+}
+
+// NewRecorder creates the cassette at path and records the requests sent
+// through next, or http.DefaultTransport if next is nil.
+func NewRecorder(path string, next http.RoundTripper) (*Recorder, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{next: next, file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = b
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+
+	started := time.Now()
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	in := Interaction{
+		Method:      req.Method,
+		Path:        req.URL.RequestURI(),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Time:        started,
+	}
+	in.Request, in.RequestText = encodeBody(reqBody)
+	in.Response, in.ResponseText = encodeBody(respBody)
+
+	r.Lock()
+	defer r.Unlock()
+	err = r.enc.Encode(in)
+	if err != nil {
+		orDefault(r.Logger).Error("unable to record interaction", "error", err)
+	}
+	return resp, nil
+}
+
+// Close flushes the cassette to disk.
+func (r *Recorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	if err := r.file.Sync(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// LoadCassette reads the interactions recorded by a Recorder.
+func LoadCassette(path string) ([]Interaction, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var interactions []Interaction
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var i Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &i); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		interactions = append(interactions, i)
+	}
+	return interactions, scanner.Err()
+}
+
+// Divergence is a request made during replay whose body differs from the one
+// recorded, e.g. an advance with different inputs.
+type Divergence struct {
+	Index    int // of the interaction in the cassette
+	Method   string
+	Path     string
+	Recorded string // request body
+	Replayed string
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("%s %s (interaction %d): recorded %s, replayed %s",
+		d.Method, d.Path, d.Index, d.Recorded, d.Replayed)
+}
+
+// Replayer is an http.RoundTripper that serves requests from a cassette
+// instead of a boptest server. Requests are matched to the next unused
+// interaction with the same method and path, so the order of requests to
+// different endpoints, e.g. status checks between advances, does not matter.
+// A request whose body differs from the recording is flagged as a Divergence
+// and, unless strict, still served the recorded response.
+type Replayer struct {
+	Logger *slog.Logger // defaults to the default logger
+
+	interactions []Interaction
+	queues       map[string][]int // method and path to unused interactions
+	strict       bool
+	divergences  []Divergence
+	sync.Mutex
+}
+
+// NewReplayer replays the cassette at path. If strict, a divergent request
+// fails instead of being served.
+func NewReplayer(path string, strict bool) (*Replayer, error) {
+	interactions, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Replayer{
+		interactions: interactions,
+		queues:       make(map[string][]int),
+		strict:       strict,
+	}
+	for i, in := range interactions {
+		key := in.Method + " " + in.Path
+		r.queues[key] = append(r.queues[key], i)
+	}
+	return r, nil
+}
+
+// bodies are the same if they are equal json, whatever the order of their
+// keys, or equal text
+func sameBody(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var x, y any
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = b
+	}
+	path := req.URL.RequestURI()
+
+	r.Lock()
+	defer r.Unlock()
+	key := req.Method + " " + path
+	queue := r.queues[key]
+	if len(queue) == 0 {
+		return nil, fmt.Errorf("replay: no recorded interaction left for %s", key)
+	}
+	index := queue[0]
+	in := r.interactions[index]
+
+	if recorded := decodeBody(in.Request, in.RequestText); !sameBody(recorded, reqBody) {
+		d := Divergence{
+			Index:    index,
+			Method:   req.Method,
+			Path:     path,
+			Recorded: string(recorded),
+			Replayed: string(reqBody),
+		}
+		r.divergences = append(r.divergences, d)
+		orDefault(r.Logger).Warn("replay diverged from recording", "index", index,
+			"method", d.Method, "path", d.Path, "recorded", d.Recorded, "replayed", d.Replayed)
+		if r.strict {
+			return nil, fmt.Errorf("replay: %s", d)
+		}
+	}
+	r.queues[key] = queue[1:]
+
+	header := make(http.Header)
+	if in.ContentType != "" {
+		header.Set("Content-Type", in.ContentType)
+	}
+	body := decodeBody(in.Response, in.ResponseText)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+		StatusCode:    in.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// Divergences returns the requests that differed from the recording so far.
+func (r *Replayer) Divergences() []Divergence {
+	r.Lock()
+	defer r.Unlock()
+	return append([]Divergence{}, r.divergences...)
+}
+
+// Remaining returns the number of recorded interactions not yet replayed.
+func (r *Replayer) Remaining() int {
+	r.Lock()
+	defer r.Unlock()
+	var n int
+	for _, queue := range r.queues {
+		n += len(queue)
+	}
+	return n
+}
@@ -0,0 +1,116 @@
+package boptest
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// runs a short session: select, initialize, one advance with a heating
+// setpoint and stop
+func cassetteSession(t *testing.T, setpoint any) (*TestCase, any) {
+	t.Helper()
+	testCase, err := NewTestCase(testcase, WithHost(Host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testCase.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCase.SetInput("con_oveTSetHea_activate", 1)
+	testCase.SetInput("con_oveTSetHea_u", setpoint)
+	err = testCase.Drain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone := testCase.State.Get("zon_reaTRooAir_y")
+	testCase.Stop()
+	return testCase, zone
+}
+
+// swaps the transport of Client for the duration of the test
+func withTransport(t *testing.T, rt http.RoundTripper) {
+	prev := Client.Transport
+	Client.Transport = rt
+	t.Cleanup(func() { Client.Transport = prev })
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+
+	// record against the fake
+	fake := startFake(t)
+	rec, err := NewRecorder(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withTransport(t, rec)
+	recorded, zone := cassetteSession(t, 298.15)
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fake.Close()
+
+	interactions, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(interactions) != 5 {
+		t.Fatalf("recorded %d interactions, want select, initialize, inputs, advance and stop", len(interactions))
+	}
+
+	// replay with the fake gone
+	rep, err := NewReplayer(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Client.Transport = rep
+	replayed, replayedZone := cassetteSession(t, 298.15)
+	if replayed.ID != recorded.ID {
+		t.Errorf("replayed test id %q, recorded %q", replayed.ID, recorded.ID)
+	}
+	if replayedZone != zone {
+		t.Errorf("replayed zone temperature %v, recorded %v", replayedZone, zone)
+	}
+	if d := rep.Divergences(); len(d) != 0 {
+		t.Errorf("unexpected divergences %v", d)
+	}
+	if n := rep.Remaining(); n != 0 {
+		t.Errorf("%d interactions not replayed", n)
+	}
+
+	// a different input is flagged but still served the recording
+	rep, err = NewReplayer(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Client.Transport = rep
+	_, replayedZone = cassetteSession(t, 300.15)
+	if replayedZone != zone {
+		t.Errorf("replayed zone temperature %v, recorded %v", replayedZone, zone)
+	}
+	divergences := rep.Divergences()
+	if len(divergences) != 1 || divergences[0].Path != "/advance/"+recorded.ID {
+		t.Fatalf("divergences are %v", divergences)
+	}
+
+	// and fails when strict
+	rep, err = NewReplayer(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Client.Transport = rep
+	testCase, err := NewTestCase(testcase, WithHost(Host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	if err := testCase.Start(); err != nil {
+		t.Fatal(err)
+	}
+	testCase.SetInput("con_oveTSetHea_u", 300.15)
+	if err := testCase.Drain(); err == nil {
+		t.Errorf("expected a strict replay to fail on a divergent advance")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	boptest "github.com/jamesryancoleman/grpc-boptest"
+	_ "github.com/jamesryancoleman/grpc-boptest/controllers" // the controller kinds of the config
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] EXPERIMENT...\n       %s -sweep [flags] SWEEP...\n\nruns each yaml or json experiment or sweep file in turn\n\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	outPtr := flag.String("out", "experiments", "write a results bundle per run to a new directory in DIR")
+	hostPtr := flag.String("host", "", "address of the boptest server, overrides the experiments and "+boptest.EnvHost)
+	runsPtr := flag.Int("runs", 0, "repeat every experiment N times, overrides the experiments")
+	sweepPtr := flag.Bool("sweep", false, "the files are sweeps, run in parallel with their KPIs compared")
+	parallelPtr := flag.Int("parallel", 0, "run N runs of a sweep at once, overrides the sweeps")
+	logLevelPtr := flag.String("log-level", "info", "log at LEVEL: debug, info, warn or error")
+	logFormatPtr := flag.String("log-format", "text", "log to stderr as text or json")
+	logFilePtr := flag.String("log-file", "", "also log json to FILE")
+
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	logger, logFile, err := boptest.NewLogger(boptest.LoggingConfig{
+		Level:  *logLevelPtr,
+		Format: *logFormatPtr,
+		File:   *logFilePtr,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	defer logFile.Close()
+	slog.SetDefault(logger) // for the parts of the package not given a logger
+
+	if *hostPtr != "" {
+		os.Setenv(boptest.EnvHost, *hostPtr)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var failed bool
+	if *sweepPtr {
+		failed = sweep(ctx, flag.Args(), *outPtr, *runsPtr, *parallelPtr, logger)
+	} else {
+		failed = experiment(ctx, flag.Args(), *outPtr, *runsPtr, logger)
+	}
+	if failed {
+		logFile.Close()
+		os.Exit(1)
+	}
+}
+
+func experiment(ctx context.Context, paths []string, out string, n int, logger *slog.Logger) (failed bool) {
+	// load them all first, so that a typo does not fail the last one hours in
+	var experiments []*boptest.Experiment
+	for _, path := range paths {
+		e, err := boptest.LoadExperiment(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if n > 0 {
+			e.Runs = n
+		}
+		experiments = append(experiments, e)
+	}
+
+	for _, e := range experiments {
+		runs, err := e.Run(ctx, out, logger)
+		for _, run := range runs {
+			status := "ok"
+			if run.Error != "" {
+				status = run.Error
+			} else if len(run.Rejected) > 0 {
+				status = fmt.Sprintf("%d overrides rejected", len(run.Rejected))
+			}
+			fmt.Printf("%s\trun %d\t%d advances\t%s\t%s\n", run.Experiment, run.Run, run.Advances, run.Dir, status)
+		}
+		if err != nil {
+			failed = true
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return failed
+}
+
+func sweep(ctx context.Context, paths []string, out string, n, parallel int, logger *slog.Logger) (failed bool) {
+	var sweeps []*boptest.Sweep
+	for _, path := range paths {
+		s, err := boptest.LoadSweep(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if n > 0 {
+			s.Experiment.Runs = n
+		}
+		if parallel > 0 {
+			s.Parallel = parallel
+		}
+		sweeps = append(sweeps, s)
+	}
+
+	for _, s := range sweeps {
+		runs, err := s.Run(ctx, out, logger)
+		for _, run := range runs {
+			status := "ok"
+			if run.Error != "" {
+				status = run.Error
+			} else if len(run.Rejected) > 0 {
+				status = fmt.Sprintf("%d overrides rejected", len(run.Rejected))
+			}
+			fmt.Printf("%s\trun %d\t%d attempts\t%d advances\t%s\t%s\n", run.Experiment.Name, run.Run, run.Attempts, run.Advances, run.Dir, status)
+		}
+		if err != nil {
+			failed = true
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return failed
+}
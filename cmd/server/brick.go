@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+
+	boptest "github.com/jamesryancoleman/grpc-boptest"
+)
+
+// writes a Brick model of the first test case of config to the file at path,
+// stdout if empty, with the uris of the points under the name it is served
+// with. Selecting the test case is the only way to list its points, it is
+// stopped again before returning.
+func writeBrick(config *boptest.Config, path string, logger *slog.Logger) error {
+	tc := config.TestCases[0]
+	opts, err := tc.Options(config.Host)
+	if err != nil {
+		return err
+	}
+	testCase, err := boptest.NewTestCase(tc.TestCase, append(opts, boptest.WithLogger(logger))...)
+	if testCase != nil {
+		defer testCase.Stop()
+	}
+	if err != nil {
+		return err
+	}
+	// registered, but not started
+	s := boptest.NewServer(config.Listen, nil)
+	if err := s.AddTestCase(tc.Name, testCase); err != nil {
+		return err
+	}
+
+	if path == "" {
+		return testCase.WriteBrickModel(os.Stdout)
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = testCase.WriteBrickModel(out)
+	return errors.Join(err, out.Close())
+}
@@ -1,48 +1,260 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	boptest "github.com/jamesryancoleman/grpc-boptest"
+	_ "github.com/jamesryancoleman/grpc-boptest/controllers" // the controller kinds of the config
 )
 
-const defaultAddr string = "0.0.0.0:50066"
-
 func main() {
+	configPtr := flag.String("config", "", "YAML or JSON config FILE, replaces the flags below")
+	hostPtr := flag.String("host", "nuc.local:1025", "address of the boptest server")
 	caseNamePtr := flag.String("case", "bestest_air", "name of the testcase")
 	// Default is July 1st
 	startTimePtr := flag.Int("start", 181*24*60*60, "seconds since start of year")
 	stepPtr := flag.Int("step", 60, "seconds to advance the simluation per update")
 	freqPtr := flag.Int("freq", 15, "update simlulation every SECONDS")
+	haystackPtr := flag.String("haystack", "", "serve Haystack over HTTP on ADDR")
+	plantPtr := flag.String("plant", "boptest", "simulate with the boptest server or an in-process rc model")
+	recordPtr := flag.String("record", "", "record the boptest session to cassette FILE")
+	replayPtr := flag.String("replay", "", "serve the boptest session from cassette FILE instead of the host")
+	strictPtr := flag.Bool("strict", false, "with -replay, fail requests that diverge from the cassette")
+	// override the logging of the config file, if set
+	logLevelPtr := flag.String("log-level", "info", "log at LEVEL: debug, info, warn or error")
+	logFormatPtr := flag.String("log-format", "text", "log to stderr as text or json")
+	logFilePtr := flag.String("log-file", "", "also log json to FILE")
+	logMaxBytesPtr := flag.Int64("log-max-bytes", 0, "rotate the log file beyond BYTES, 0 for never")
+	logMaxFilesPtr := flag.Int("log-max-files", boptest.DefaultLogFiles, "rotated log files kept")
+	outPtr := flag.String("o", "", "with brick, write the model to FILE instead of stdout")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [brick] [flags]\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "brick writes a Brick model of the first test case and exits\n\n")
+		flag.PrintDefaults()
+	}
+	// the brick subcommand takes the flags of the server
+	brick := len(os.Args) > 1 && os.Args[1] == "brick"
+	if brick {
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+
+	var config *boptest.Config
+	if *configPtr != "" {
+		var err error
+		config, err = boptest.LoadConfig(*configPtr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	} else {
+		config = &boptest.Config{
+			Host: *hostPtr,
+			TestCases: []boptest.TestCaseConfig{{
+				TestCase: *caseNamePtr,
+				Start:    *startTimePtr,
+				Step:     *stepPtr,
+				Freq:     *freqPtr,
+				Plant:    *plantPtr,
+			}},
+		}
+		config.ApplyEnv()
+		config.SetDefaults()
+		if err := config.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	logFlags := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "log-level":
+			config.Logging.Level = *logLevelPtr
+		case "log-format":
+			config.Logging.Format = *logFormatPtr
+		case "log-file":
+			config.Logging.File = *logFilePtr
+		case "log-max-bytes":
+			config.Logging.MaxBytes = *logMaxBytesPtr
+		case "log-max-files":
+			config.Logging.MaxFiles = *logMaxFilesPtr
+		default:
+			return
+		}
+		logFlags = true
+	})
+	if logFlags {
+		if err := config.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	logger, logFile, err := boptest.NewLogger(config.Logging)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	slog.SetDefault(logger) // for the parts of the package not given a logger
+
+	if brick {
+		err := writeBrick(config, *outPtr, logger)
+		if lerr := logFile.Close(); lerr != nil {
+			fmt.Fprintln(os.Stderr, lerr.Error())
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	var recorder *boptest.Recorder
+	var replayer *boptest.Replayer
+	switch {
+	case *recordPtr != "" && *replayPtr != "":
+		fmt.Fprintln(os.Stderr, "-record and -replay cannot be used together")
+		os.Exit(1)
+	case *recordPtr != "":
+		recorder, err = boptest.NewRecorder(*recordPtr, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		recorder.Logger = logger
+		boptest.Client.Transport = recorder
+	case *replayPtr != "":
+		replayer, err = boptest.NewReplayer(*replayPtr, *strictPtr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		replayer.Logger = logger
+		boptest.Client.Transport = replayer
+	}
+
+	var series *boptest.SeriesRecorder
+	if config.Timeseries != nil {
+		series, err = boptest.NewSeriesRecorder(*config.Timeseries)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	var audit *boptest.AuditLog
+	if config.Audit != "" {
+		audit, err = boptest.NewAuditLog(config.Audit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
 
-	flag.Parse()
-
-	// create boptest test case
-	testCase, err := boptest.NewTestCase(
-		*caseNamePtr,
-		boptest.WithHost("nuc.local:1025"), // the boptest docker container
-		boptest.WithStartTime(*startTimePtr),
-		boptest.WithStep(*stepPtr),
-		boptest.WithUpdateFrequency(*freqPtr),
-		boptest.WithStartNow(),
-	)
+	serverOpts, err := config.ServerOptions()
 	if err != nil {
-		boptest.FileLog.Error(err.Error())
-		boptest.TermLog.Error(err.Error())
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
 	}
-	defer testCase.Stop()
+	serverOpts = append(serverOpts, boptest.WithAudit(audit), boptest.WithServerLogger(logger))
+	s := boptest.NewServer(config.Listen, nil, serverOpts...)
 
-	s := boptest.NewServer(defaultAddr, testCase)
-	s.Start()
-	fmt.Printf("boptest server started @ %s\n", defaultAddr)
+	// create boptest test cases, every one configured or none
+	failed := false
+	for _, tc := range config.TestCases {
+		opts, err := tc.Options(config.Host)
+		if err != nil {
+			logger.Error(err.Error())
+			failed = true
+			continue
+		}
+		opts = append(opts, boptest.WithSeriesRecorder(series), boptest.WithLogger(logger))
+		testCase, err := boptest.NewTestCase(tc.TestCase, opts...)
+		if err == nil {
+			err = s.AddTestCase(tc.Name, testCase)
+		}
+		if err != nil {
+			logger.Error(err.Error(), "test_case", tc.Name)
+			failed = true
+			if testCase != nil {
+				// selected, even if it failed to begin
+				testCase.Stop()
+			}
+		}
+	}
+	if failed {
+		// stops the test cases that were created
+		s.Shutdown(context.Background())
+		os.Exit(1)
+	}
+
+	err = s.Start()
+	if err != nil {
+		logger.Error(err.Error())
+		s.Shutdown(context.Background())
+		os.Exit(1)
+	}
+	fmt.Printf("boptest server started @ %s\n", config.Listen)
+
+	var haystack *boptest.HaystackServer
+	if *haystackPtr != "" {
+		if testCase, ok := s.TestCase(""); ok {
+			haystack, err = boptest.NewHaystackServer(*haystackPtr, testCase)
+			if err == nil {
+				haystack.Logger = logger
+				err = haystack.Start()
+			}
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
 	fmt.Println("server running, press ctrl+c to exit...")
 	<-done // Will block here until user hits ctrl+c
 	fmt.Println("\nshutting down.")
+
+	timeout := time.Duration(config.Shutdown.Timeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if haystack != nil {
+		haystack.Shutdown(ctx)
+	}
+	err = s.Shutdown(ctx)
+	if serr := series.Close(); serr != nil {
+		fmt.Fprintln(os.Stderr, serr.Error())
+	}
+	if aerr := audit.Close(); aerr != nil {
+		fmt.Fprintln(os.Stderr, aerr.Error())
+	}
+	if recorder != nil {
+		if rerr := recorder.Close(); rerr != nil {
+			fmt.Fprintln(os.Stderr, rerr.Error())
+		}
+	}
+	if replayer != nil {
+		for _, d := range replayer.Divergences() {
+			fmt.Fprintf(os.Stderr, "diverged: %s\n", d)
+		}
+	}
+	if lerr := logFile.Close(); lerr != nil {
+		fmt.Fprintln(os.Stderr, lerr.Error())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,494 @@
+package boptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// environment variables that override the config file
+const (
+	EnvHost     = "BOPTEST_HOST"
+	EnvListen   = "BOPTEST_LISTEN"
+	EnvLogLevel = "BOPTEST_LOG_LEVEL"
+	EnvLogFile  = "BOPTEST_LOG_FILE"
+	EnvTLSCert  = "BOPTEST_TLS_CERT"
+	EnvTLSKey   = "BOPTEST_TLS_KEY"
+	EnvTLSCA    = "BOPTEST_TLS_CA"
+)
+
+const (
+	DefaultListenAddr      = "0.0.0.0:50066"
+	DefaultShutdownTimeout = 10 // seconds
+)
+
+var nameRe = regexp.MustCompile(`^[a-zA-Z0-9\_\-.]+$`)
+
+// Config describes a driver: the boptest server it connects to, the address
+// it listens on and the test cases it fronts.
+type Config struct {
+	Host      string           `json:"host" yaml:"host"`       // e.g. localhost:5000
+	Listen    string           `json:"listen" yaml:"listen"`   // e.g. 0.0.0.0:50066
+	Default   string           `json:"default" yaml:"default"` // name of the default test case
+	TestCases []TestCaseConfig `json:"test_cases" yaml:"test_cases"`
+	Logging   LoggingConfig    `json:"logging" yaml:"logging"`
+	TLS       TLSConfig        `json:"tls" yaml:"tls"`
+	Shutdown  ShutdownConfig   `json:"shutdown" yaml:"shutdown"`
+	Network   NetworkConfig    `json:"network" yaml:"network"` // emulated, none by default
+
+	Timeseries *SeriesConfig `json:"timeseries" yaml:"timeseries"` // records every advance, if set
+	Audit      string        `json:"audit" yaml:"audit"`           // JSON Lines file recording every write, if set
+	Metrics    MetricsConfig `json:"metrics" yaml:"metrics"`
+	Reflection bool          `json:"reflection" yaml:"reflection"` // register grpc server reflection
+	Policy     string        `json:"policy" yaml:"policy"`         // authorization policy file, everything is allowed if empty
+}
+
+type TestCaseConfig struct {
+	Name     string    `json:"name" yaml:"name"`           // the uri authority, defaults to test_case
+	TestCase string    `json:"test_case" yaml:"test_case"` // e.g. bestest_air
+	Scenario Scenario  `json:"scenario" yaml:"scenario"`
+	Start    int       `json:"start" yaml:"start"`   // seconds since start of year
+	WarmUp   int       `json:"warmup" yaml:"warmup"` // seconds before start
+	Step     int       `json:"step" yaml:"step"`     // seconds to advance per update
+	Freq     int       `json:"freq" yaml:"freq"`     // seconds between updates
+	Write    WriteMode `json:"write" yaml:"write"`   // once or latch
+	Plant    string    `json:"plant" yaml:"plant"`   // boptest or rc
+	RC       *RCConfig `json:"rc" yaml:"rc"`         // the rc plant, defaults to DefaultRCConfig
+
+	SensorFaults []SensorFault `json:"sensor_faults" yaml:"sensor_faults"`
+	FaultSeed    int64         `json:"fault_seed" yaml:"fault_seed"` // of fault noise and dropouts
+
+	ActuatorFaults []ActuatorFault `json:"actuator_faults" yaml:"actuator_faults"`
+
+	Safety *SafetyRules `json:"safety" yaml:"safety"` // enforced on the inputs written, if set
+
+	Schedules string `json:"schedules" yaml:"schedules"` // yaml or json file of schedules to write, none if empty
+
+	Controllers []ControllerConfig `json:"controllers" yaml:"controllers"` // stepped after every advance
+}
+
+// plants a test case can be simulated by
+const (
+	PlantBOPTEST = "boptest" // the boptest server at Host
+	PlantRC      = "rc"      // an in-process RCPlant
+)
+
+type LoggingConfig struct {
+	Level    string `json:"level" yaml:"level"`         // debug, info, warn or error
+	File     string `json:"file" yaml:"file"`           // json log file, none if empty
+	Format   string `json:"format" yaml:"format"`       // terminal format, text or json
+	MaxBytes int64  `json:"max_bytes" yaml:"max_bytes"` // rotate the file beyond this size, 0 for never
+	MaxFiles int    `json:"max_files" yaml:"max_files"` // rotated files kept, DefaultLogFiles if 0
+}
+
+type TLSConfig struct {
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+	CAFile   string `json:"ca_file" yaml:"ca_file"` // verifies client certificates, i.e. mutual tls
+}
+
+type ShutdownConfig struct {
+	Timeout      int  `json:"timeout" yaml:"timeout"`             // seconds to wait for rpcs in flight
+	FinalAdvance bool `json:"final_advance" yaml:"final_advance"` // apply pending writes before stopping
+}
+
+type MetricsConfig struct {
+	Listen string   `json:"listen" yaml:"listen"` // serves /metrics, e.g. 0.0.0.0:9090, disabled if empty
+	Points []string `json:"points" yaml:"points"` // exported as gauges, e.g. zon_reaTRooAir_y
+}
+
+// NetworkConfig describes the network conditions to emulate, see
+// NetworkConditions.
+type NetworkConfig struct {
+	Latency struct {
+		Distribution LatencyDistribution `json:"distribution" yaml:"distribution"` // constant, uniform, normal or exponential
+		Mean         float64             `json:"mean" yaml:"mean"`                 // milliseconds
+		Jitter       float64             `json:"jitter" yaml:"jitter"`             // milliseconds
+	} `json:"latency" yaml:"latency"`
+	ErrorRate float64            `json:"error_rate" yaml:"error_rate"` // probability of UNAVAILABLE
+	Timeouts  map[string]float64 `json:"timeouts" yaml:"timeouts"`     // milliseconds by key pattern
+	Seed      int64              `json:"seed" yaml:"seed"`
+}
+
+// true if any condition is set
+func (n NetworkConfig) enabled() bool {
+	return n.Latency.Mean != 0 || n.Latency.Jitter != 0 || n.ErrorRate != 0 || len(n.Timeouts) > 0
+}
+
+func (n NetworkConfig) Conditions() NetworkConditions {
+	ms := func(v float64) time.Duration { return time.Duration(v * float64(time.Millisecond)) }
+	c := NetworkConditions{
+		Latency: Latency{
+			Distribution: n.Latency.Distribution,
+			Mean:         ms(n.Latency.Mean),
+			Jitter:       ms(n.Latency.Jitter),
+		},
+		ErrorRate: n.ErrorRate,
+		Seed:      n.Seed,
+	}
+	if len(n.Timeouts) > 0 {
+		c.Timeouts = make(map[string]time.Duration, len(n.Timeouts))
+		for key, v := range n.Timeouts {
+			c.Timeouts[key] = ms(v)
+		}
+	}
+	return c
+}
+
+// decodeFile decodes the yaml or json file at path, by its extension, into v,
+// failing on fields v does not have.
+func decodeFile(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		err = dec.Decode(v)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(v)
+	default:
+		return fmt.Errorf("%s: unsupported format %q, use .yaml, .yml or .json", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadConfig reads a YAML or JSON config file, chosen by its extension,
+// applies environment overrides and defaults and validates the result.
+func LoadConfig(path string) (*Config, error) {
+	var c Config
+	if err := decodeFile(path, &c); err != nil {
+		return nil, err
+	}
+
+	c.ApplyEnv()
+	c.SetDefaults()
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// ApplyEnv overrides the config with any BOPTEST_* environment variables.
+func (c *Config) ApplyEnv() {
+	for env, field := range map[string]*string{
+		EnvHost:     &c.Host,
+		EnvListen:   &c.Listen,
+		EnvLogLevel: &c.Logging.Level,
+		EnvLogFile:  &c.Logging.File,
+		EnvTLSCert:  &c.TLS.CertFile,
+		EnvTLSKey:   &c.TLS.KeyFile,
+		EnvTLSCA:    &c.TLS.CAFile,
+	} {
+		if v, ok := os.LookupEnv(env); ok {
+			*field = v
+		}
+	}
+}
+
+// SetDefaults fills in the fields left empty.
+func (c *Config) SetDefaults() {
+	if c.Listen == "" {
+		c.Listen = DefaultListenAddr
+	}
+	for i := range c.TestCases {
+		tc := &c.TestCases[i]
+		if tc.Name == "" {
+			tc.Name = tc.TestCase
+		}
+		if tc.Step == 0 {
+			tc.Step = DefaultStep
+		}
+		if tc.Freq == 0 {
+			tc.Freq = DefaultUpdateFreq
+		}
+		if tc.Write == "" {
+			tc.Write = WriteOnce
+		}
+		if tc.Plant == "" {
+			tc.Plant = PlantBOPTEST
+		}
+		if tc.Plant == PlantRC && tc.RC == nil {
+			rc := DefaultRCConfig(HVACFor(tc.TestCase))
+			tc.RC = &rc
+		}
+	}
+	if c.Default == "" && len(c.TestCases) > 0 {
+		c.Default = c.TestCases[0].Name
+	}
+	if c.Logging.Level == "" {
+		c.Logging.Level = "info"
+	}
+	if c.Logging.Format == "" {
+		c.Logging.Format = "text"
+	}
+	if c.Shutdown.Timeout == 0 {
+		c.Shutdown.Timeout = DefaultShutdownTimeout
+	}
+}
+
+// Validate returns every problem with the config joined into one error.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{field}, args...)...))
+	}
+
+	needsHost := false
+	for _, tc := range c.TestCases {
+		needsHost = needsHost || tc.Plant != PlantRC
+	}
+	if c.Host == "" {
+		if needsHost {
+			fail("host", "is required")
+		}
+	} else if _, _, err := net.SplitHostPort(c.Host); err != nil {
+		fail("host", "%v", err)
+	}
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		fail("listen", "%v", err)
+	}
+	if c.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			fail("metrics.listen", "%v", err)
+		}
+	} else if len(c.Metrics.Points) > 0 {
+		fail("metrics.points", "require metrics.listen")
+	}
+
+	if len(c.TestCases) == 0 {
+		fail("test_cases", "at least one test case is required")
+	}
+	names := make(map[string]bool, len(c.TestCases))
+	for i, tc := range c.TestCases {
+		field := fmt.Sprintf("test_cases[%d]", i)
+		switch {
+		case tc.TestCase == "":
+			fail(field+".test_case", "is required")
+		case !nameRe.MatchString(tc.Name):
+			fail(field+".name", "%q is not a valid uri authority", tc.Name)
+		case names[tc.Name]:
+			fail(field+".name", "%q is used more than once", tc.Name)
+		}
+		names[tc.Name] = true
+
+		if tc.Start < 0 {
+			fail(field+".start", "must not be negative")
+		}
+		if tc.WarmUp < 0 {
+			fail(field+".warmup", "must not be negative")
+		}
+		if tc.Step <= 0 {
+			fail(field+".step", "must be positive")
+		}
+		if tc.Freq <= 0 {
+			fail(field+".freq", "must be positive")
+		}
+		if tc.Write != WriteOnce && tc.Write != WriteLatch {
+			fail(field+".write", "must be %q or %q, not %q", WriteOnce, WriteLatch, tc.Write)
+		}
+		switch tc.Plant {
+		case PlantBOPTEST:
+		case PlantRC:
+			if tc.RC != nil {
+				if err := tc.RC.Validate(); err != nil {
+					fail(field+".rc", "%v", err)
+				}
+			}
+			if tc.Scenario != (Scenario{}) {
+				fail(field+".scenario", "is not supported by the rc plant")
+			}
+		default:
+			fail(field+".plant", "must be %q or %q, not %q", PlantBOPTEST, PlantRC, tc.Plant)
+		}
+		for j, f := range tc.SensorFaults {
+			if err := f.validate(); err != nil {
+				fail(fmt.Sprintf("%s.sensor_faults[%d]", field, j), "%v", err)
+			}
+		}
+		for j, f := range tc.ActuatorFaults {
+			if err := f.validate(); err != nil {
+				fail(fmt.Sprintf("%s.actuator_faults[%d]", field, j), "%v", err)
+			}
+		}
+		if tc.Safety != nil {
+			if err := tc.Safety.Validate(); err != nil {
+				fail(field+".safety", "%v", err)
+			}
+		}
+		if tc.Schedules != "" {
+			if _, err := readScheduleFile(tc.Schedules); err != nil {
+				fail(field+".schedules", "%v", err)
+			}
+		}
+		for j, ctrl := range tc.Controllers {
+			if _, err := ctrl.Controller(); err != nil {
+				fail(fmt.Sprintf("%s.controllers[%d]", field, j), "%v", err)
+			}
+		}
+	}
+	if c.Default != "" && !names[c.Default] {
+		fail("default", "unknown test case %q", c.Default)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		fail("logging.level", "%q is not one of debug, info, warn or error", c.Logging.Level)
+	}
+	if c.Logging.Format != "text" && c.Logging.Format != "json" {
+		fail("logging.format", "must be text or json, not %q", c.Logging.Format)
+	}
+	if c.Logging.MaxBytes < 0 || c.Logging.MaxFiles < 0 {
+		fail("logging", "max_bytes and max_files must not be negative")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls", "cert_file and key_file must be set together")
+	}
+	if c.TLS.CAFile != "" && c.TLS.CertFile == "" {
+		fail("tls.ca_file", "requires cert_file and key_file")
+	}
+	for field, path := range map[string]string{"tls.cert_file": c.TLS.CertFile, "tls.key_file": c.TLS.KeyFile, "tls.ca_file": c.TLS.CAFile} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			fail(field, "%v", err)
+		}
+	}
+
+	if c.Policy != "" {
+		if _, err := LoadPolicy(c.Policy); err != nil {
+			fail("policy", "%v", err)
+		}
+	}
+
+	if c.Shutdown.Timeout < 0 {
+		fail("shutdown.timeout", "must not be negative")
+	}
+
+	if err := c.Network.Conditions().Validate(); err != nil {
+		fail("network", "%v", err)
+	}
+
+	if c.Timeseries != nil {
+		if err := c.Timeseries.Validate(); err != nil {
+			fail("timeseries", "%v", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Options returns the options to create the test case with, including a new
+// plant if it is not simulated by the boptest server and new controllers.
+func (tc TestCaseConfig) Options(host string) ([]testCaseOption, error) {
+	opts := []testCaseOption{
+		WithScenario(tc.Scenario),
+		WithStartTime(tc.Start),
+		WithWarmUp(tc.WarmUp),
+		WithStep(tc.Step),
+		WithUpdateFrequency(tc.Freq),
+		WithWriteMode(tc.Write),
+		WithStartNow(),
+	}
+	if host != "" {
+		opts = append(opts, WithHost(host))
+	}
+	if tc.Plant == PlantRC {
+		rc := DefaultRCConfig(HVACFor(tc.TestCase))
+		if tc.RC != nil {
+			rc = *tc.RC
+		}
+		plant, err := NewRCPlant(rc)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithPlant(plant))
+	}
+	if len(tc.SensorFaults) > 0 {
+		faults, err := NewSensorFaults(tc.FaultSeed, tc.SensorFaults...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithSensorFaults(faults))
+	}
+	if len(tc.ActuatorFaults) > 0 {
+		faults, err := NewActuatorFaults(tc.ActuatorFaults...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithActuatorFaults(faults))
+	}
+	if tc.Safety != nil {
+		safety, err := NewSafety(*tc.Safety)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithSafety(safety))
+	}
+	if tc.Schedules != "" {
+		scheduler, err := LoadSchedules(tc.Schedules)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithController(scheduler))
+	}
+	for _, cfg := range tc.Controllers {
+		ctrl, err := cfg.Controller()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithController(ctrl))
+	}
+	return opts, nil
+}
+
+// ServerOptions returns the options to create the server with, loading its
+// policy file if any.
+func (c *Config) ServerOptions() ([]serverOption, error) {
+	opts := []serverOption{WithDefaultTestCase(c.Default)}
+	if c.TLS.CertFile != "" {
+		opts = append(opts, WithTLS(c.TLS.CertFile, c.TLS.KeyFile))
+	}
+	if c.TLS.CAFile != "" {
+		opts = append(opts, WithClientCA(c.TLS.CAFile))
+	}
+	if c.Shutdown.FinalAdvance {
+		opts = append(opts, WithFinalAdvance())
+	}
+	if c.Reflection {
+		opts = append(opts, WithReflection())
+	}
+	if c.Metrics.Listen != "" {
+		opts = append(opts, WithMetrics(NewMetrics(c.Metrics.Listen, c.Metrics.Points...)))
+	}
+	if c.Network.enabled() {
+		opts = append(opts, WithNetworkConditions(c.Network.Conditions()))
+	}
+	if c.Policy != "" {
+		policy, err := LoadPolicy(c.Policy)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithPolicy(policy))
+	}
+	return opts, nil
+}
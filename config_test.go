@@ -0,0 +1,165 @@
+package boptest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	c, err := LoadConfig("cmd/server/config.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.TestCases) != 2 || c.Default != "air" {
+		t.Fatalf("unexpected config %+v", c)
+	}
+	hp := c.TestCases[1]
+	if hp.Scenario.TimePeriod != "peak_heat_day" || hp.Write != WriteLatch {
+		t.Errorf("unexpected test case %+v", hp)
+	}
+	// defaults
+	if hp.Start != 0 || c.TestCases[0].Write != WriteOnce {
+		t.Errorf("defaults not applied %+v", c.TestCases)
+	}
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{
+		"host": "localhost:5000",
+		"test_cases": [{"test_case": "bestest_air", "step": 60}]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(EnvListen, "127.0.0.1:50071")
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != "127.0.0.1:50071" {
+		t.Errorf("environment override not applied, listen is %s", c.Listen)
+	}
+	if c.TestCases[0].Name != "bestest_air" || c.TestCases[0].Freq != DefaultUpdateFreq {
+		t.Errorf("defaults not applied %+v", c.TestCases[0])
+	}
+}
+
+func TestDecodeFile(t *testing.T) {
+	dir := t.TempDir()
+	var v struct {
+		Name string `json:"name" yaml:"name"`
+	}
+	for file, content := range map[string]string{
+		"ok.yml":     "name: a",
+		"ok.json":    `{"name": "a"}`,
+		"bad.yaml":   "name: a\nnmae: b",
+		"bad.json":   `{"name": "a", "nmae": "b"}`,
+		"bad.toml":   `name = "a"`,
+		"empty.yaml": "",
+	} {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := decodeFile(path, &v)
+		if ok := strings.HasPrefix(file, "ok"); ok != (err == nil) {
+			t.Errorf("%s: got error %v", file, err)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), path) {
+			t.Errorf("%s: error %q does not name the file", file, err)
+		}
+	}
+	if err := decodeFile(filepath.Join(dir, "missing.yaml"), &v); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	c := Config{
+		Host: "localhost",
+		TestCases: []TestCaseConfig{
+			{TestCase: "bestest_air", Step: -1, Write: "sometimes"},
+			{
+				TestCase:       "bestest_air",
+				SensorFaults:   []SensorFault{{Point: "zon_reaTRooAir_y", Kind: "flaky"}},
+				ActuatorFaults: []ActuatorFault{{Input: "fcu_oveFan_u", Kind: FaultDelay}},
+				Safety:         &SafetyRules{RateLimits: []RateLimit{{Input: "oveTSet_u"}}},
+				Schedules:      "missing.yaml",
+				Controllers:    []ControllerConfig{{"kind": "mpc"}},
+			},
+		},
+		Logging: LoggingConfig{Level: "loud"},
+		TLS:     TLSConfig{CertFile: "server.crt"},
+		Network: NetworkConfig{ErrorRate: 1.5},
+		Policy:  "missing.yaml",
+	}
+	c.SetDefaults()
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, field := range []string{
+		"host:",
+		"test_cases[0].step",
+		"test_cases[0].write",
+		"test_cases[1].name",
+		"test_cases[1].sensor_faults[0]",
+		"test_cases[1].actuator_faults[0]",
+		"test_cases[1].safety",
+		"test_cases[1].schedules",
+		"test_cases[1].controllers[0]",
+		"logging.level",
+		"tls:",
+		"network:",
+		"policy:",
+	} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("missing error for %s in:\n%v", field, err)
+		}
+	}
+
+	if _, err := LoadConfig("config.toml"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestConfigRCPlant(t *testing.T) {
+	c := Config{
+		TestCases: []TestCaseConfig{
+			{TestCase: "bestest_hydronic_heat_pump", Plant: PlantRC},
+		},
+	}
+	c.SetDefaults()
+	if err := c.Validate(); err != nil {
+		t.Fatalf("an rc plant needs no host: %v", err)
+	}
+	if tc := c.TestCases[0]; tc.RC == nil || tc.RC.HVAC != HVACHeatPump {
+		t.Fatalf("rc defaults not applied %+v", tc)
+	}
+
+	opts, err := c.TestCases[0].Options(c.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCase, err := NewTestCase(c.TestCases[0].TestCase, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	if _, ok := testCase.backend.(*PlantBackend); !ok {
+		t.Errorf("test case simulated by %T", testCase.backend)
+	}
+
+	c.TestCases[0].RC.CAir = 0
+	c.TestCases[0].Plant = "fmu"
+	err = c.Validate()
+	for _, field := range []string{"host:", "test_cases[0].plant"} {
+		if err == nil || !strings.Contains(err.Error(), field) {
+			t.Errorf("missing error for %s in:\n%v", field, err)
+		}
+	}
+}
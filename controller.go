@@ -0,0 +1,100 @@
+package boptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Controller is a control loop run inside the driver rather than by an
+// external client. It is stepped once the test case is initialized and after
+// every advance, reads TestCase.State and writes its inputs for the next
+// advance with SetInput. See the controllers package for reference ones.
+type Controller interface {
+	Step(c *TestCase) error
+}
+
+// step ctrl after every advance of the test case
+func WithController(ctrl Controller) testCaseOption {
+	return func(c *TestCase) {
+		c.controllers = append(c.controllers, ctrl)
+	}
+}
+
+// steps the controllers, logging rather than returning their errors so that
+// one rejected write does not stop the simulation
+func (c *TestCase) stepControllers() {
+	for _, ctrl := range c.controllers {
+		if err := ctrl.Step(c); err != nil {
+			c.logger().Warn("controller step failed", "test_case", c.ID, "controller", ctrl, "error", err)
+		}
+	}
+}
+
+// ControllerConfig configures a controller by its kind and the settings of
+// that kind, e.g.
+//
+//	controllers:
+//	  - kind: pid
+//	    measurement: reaTZon_y
+//	    output: oveHeaPumY_u
+//	    setpoint: 294.15
+//	    kp: 0.5
+//
+// Kinds are registered with RegisterController, the controllers package
+// registers its reference ones when it is imported.
+type ControllerConfig map[string]any
+
+var (
+	controllerKindsMu sync.RWMutex
+	controllerKinds   = make(map[string]func() Controller)
+)
+
+// RegisterController makes the controllers returned by new configurable as
+// kind. The settings of a ControllerConfig are decoded into the controller
+// new returns by its json tags, and checked by its Validate method if it has
+// one.
+func RegisterController(kind string, new func() Controller) {
+	controllerKindsMu.Lock()
+	defer controllerKindsMu.Unlock()
+	controllerKinds[kind] = new
+}
+
+// Controller returns a new controller of the kind and with the settings of
+// cfg.
+func (cfg ControllerConfig) Controller() (Controller, error) {
+	kind, _ := cfg["kind"].(string)
+	if kind == "" {
+		return nil, errors.New("kind is required")
+	}
+	controllerKindsMu.RLock()
+	new, ok := controllerKinds[kind]
+	kinds := slices.Sorted(maps.Keys(controllerKinds))
+	controllerKindsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown kind %q, registered are %v", kind, kinds)
+	}
+
+	settings := maps.Clone(cfg)
+	delete(settings, "kind")
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	ctrl := new()
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(ctrl); err != nil {
+		return nil, fmt.Errorf("%s: %w", kind, err)
+	}
+	if v, ok := ctrl.(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", kind, err)
+		}
+	}
+	return ctrl, nil
+}
@@ -0,0 +1,73 @@
+package boptest
+
+import (
+	"errors"
+	"testing"
+)
+
+// a controller that writes the step count to an input
+type countingController struct {
+	steps int
+}
+
+func (c *countingController) Step(tc *TestCase) error {
+	c.steps++
+	if c.steps == 2 {
+		return errors.New("measurement missing")
+	}
+	return tc.SetInput("con_oveTSetHea_u", float64(c.steps))
+}
+
+func TestController(t *testing.T) {
+	ctrl := &countingController{}
+	testCase, err := NewTestCase("bestest_air", WithPlant(newRCPlant(t, HVACFanCoil)), WithController(ctrl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	if err := testCase.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if ctrl.steps != 1 {
+		t.Fatalf("stepped %d times once initialized", ctrl.steps)
+	}
+	for range 3 {
+		if err := testCase.advanceOnce(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ctrl.steps != 4 {
+		t.Errorf("stepped %d times after 3 advances", ctrl.steps)
+	}
+	// the step that failed wrote nothing for the third advance
+	var applied []any
+	for _, s := range testCase.History.All()[1:] {
+		applied = append(applied, s.Applied["con_oveTSetHea_u"])
+	}
+	if applied[0] != 1.0 || applied[1] != nil || applied[2] != 3.0 {
+		t.Errorf("applied %v", applied)
+	}
+}
+
+func TestControllerConfig(t *testing.T) {
+	RegisterController("counting", func() Controller { return &countingController{} })
+	cfg := ControllerConfig{"kind": "counting"}
+	a, err := cfg.Controller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := cfg.Controller()
+	if a == b {
+		t.Error("controllers of one config share their state")
+	}
+
+	for _, cfg := range []ControllerConfig{
+		{},
+		{"kind": "mpc"},
+		{"kind": "counting", "steps": 2}, // unexported
+	} {
+		if _, err := cfg.Controller(); err == nil {
+			t.Errorf("expected an error for %v", cfg)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Package controllers provides reference controllers that run inside the
+// driver against a boptest.TestCase, so that a test case can be benchmarked
+// with a baseline controller and no external client:
+//
+//	pid := &controllers.PID{Measurement: "reaTZon_y", Output: "oveHeaPumY_u", Setpoint: 294.15, Kp: 0.5, Ki: 0.001, Max: 1}
+//	testCase, err := boptest.NewTestCase("bestest_hydronic_heat_pump", boptest.WithController(pid))
+//
+// Every controller is configured by point names, reads the test case's State
+// and writes its output with SetInput on each step. A _u output has its
+// _activate input written with it.
+//
+// Importing the package registers the controllers as the pid, hysteresis and
+// schedule kinds of a config file's controllers section, see
+// boptest.ControllerConfig. A Schedule is a boptest.Scheduler of one output,
+// the schedules section of a config file runs several from a file.
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	boptest "github.com/jamesryancoleman/grpc-boptest"
+)
+
+func init() {
+	boptest.RegisterController("pid", func() boptest.Controller { return &PID{} })
+	boptest.RegisterController("hysteresis", func() boptest.Controller { return &Hysteresis{} })
+	boptest.RegisterController("schedule", func() boptest.Controller { return &Schedule{} })
+}
+
+// returns an error unless the measurement and output points are named
+func validatePoints(measurement, output string) error {
+	switch {
+	case measurement == "":
+		return errors.New("measurement is required")
+	case output == "":
+		return errors.New("output is required")
+	}
+	return nil
+}
+
+// reads point from the state of c as a number
+func read(c *boptest.TestCase, point string) (float64, error) {
+	v := c.State.Get(point)
+	if v == nil {
+		return 0, fmt.Errorf("%s is not in the state", point)
+	}
+	x, err := boptest.InputValue(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", point, err)
+	}
+	return x, nil
+}
+
+// reads the setpoint from point if it is set, returning fixed otherwise
+func setpoint(c *boptest.TestCase, fixed float64, point string) (float64, error) {
+	if point == "" {
+		return fixed, nil
+	}
+	return read(c, point)
+}
+
+// writes value to output, activating its override if it is a _u input
+func write(c *boptest.TestCase, output string, value float64) error {
+	if name, ok := strings.CutSuffix(output, "_"+boptest.SuffixOverride); ok {
+		if err := c.SetInput(name+"_"+boptest.SuffixActivate, 1); err != nil {
+			return err
+		}
+	}
+	return c.SetInput(output, value)
+}
+
+// limits v to [lo, hi], unless both are 0
+func limit(v, lo, hi float64) float64 {
+	if lo == 0 && hi == 0 {
+		return v
+	}
+	return max(lo, min(v, hi))
+}
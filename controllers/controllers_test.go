@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"testing"
+
+	boptest "github.com/jamesryancoleman/grpc-boptest"
+	"gopkg.in/yaml.v3"
+)
+
+// runs a test case simulated by an RC plant with ctrl for n advances of 15
+// minutes
+func run(t *testing.T, name string, n int, ctrl boptest.Controller) *boptest.TestCase {
+	t.Helper()
+	plant, err := boptest.NewRCPlant(boptest.DefaultRCConfig(boptest.HVACFor(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCase, err := boptest.NewTestCase(name, boptest.WithPlant(plant), boptest.WithStep(900),
+		boptest.WithController(ctrl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { testCase.Stop() })
+	if err := testCase.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for range n {
+		if err := testCase.Drain(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return testCase
+}
+
+func TestWriteActivates(t *testing.T) {
+	// always on below a setpoint no zone reaches
+	testCase := run(t, "bestest_hydronic_heat_pump", 1, &Hysteresis{Measurement: "reaTZon_y", Output: "oveTSet_u",
+		Setpoint: 400, On: 296.15, Off: 289.15})
+	state := testCase.State.GetAll()
+	if state["oveTSet_activate"] != 1.0 || state["oveTSet_u"] != 296.15 {
+		t.Errorf("state after one step is %v", state)
+	}
+
+	// a measurement not in the state fails the step without writing
+	testCase = run(t, "bestest_hydronic_heat_pump", 1, &PID{Measurement: "reaTZon", Output: "oveHeaPumY_u"})
+	if v := testCase.State.Get("oveHeaPumY_activate"); v != 0.0 {
+		t.Errorf("oveHeaPumY_activate is %v without a measurement", v)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	var tc boptest.TestCaseConfig
+	err := yaml.Unmarshal([]byte(`
+controllers:
+  - kind: pid
+    measurement: reaTZon_y
+    output: oveHeaPumY_u
+    setpoint: 294.15
+    kp: 0.5
+    max: 1
+  - kind: hysteresis
+    measurement: reaTZon_y
+    output: ovePum_u
+    setpoint: 294.15
+    deadband: 1
+  - kind: schedule
+    output: oveTSet_u
+    weekday:
+      - at: "07:00"
+        value: 294.15
+      - at: "19:00"
+        value: 289.15
+`), &tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ctrls []boptest.Controller
+	for _, cfg := range tc.Controllers {
+		ctrl, err := cfg.Controller()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctrls = append(ctrls, ctrl)
+	}
+	if pid, ok := ctrls[0].(*PID); !ok || pid.Kp != 0.5 || pid.Max != 1 || pid.Output != "oveHeaPumY_u" {
+		t.Errorf("pid decoded as %+v", ctrls[0])
+	}
+	if h, ok := ctrls[1].(*Hysteresis); !ok || h.Deadband != 1 || h.Output != "ovePum_u" {
+		t.Errorf("hysteresis decoded as %+v", ctrls[1])
+	}
+	if s, ok := ctrls[2].(*Schedule); !ok || len(s.Weekday) != 2 || *s.Weekday[1].Value != 289.15 {
+		t.Errorf("schedule decoded as %+v", ctrls[2])
+	}
+
+	for _, cfg := range []boptest.ControllerConfig{
+		{"kind": "pid", "measurement": "reaTZon_y"},
+		{"kind": "pid", "measurement": "reaTZon_y", "output": "oveHeaPumY_u", "min": 1},
+		{"kind": "hysteresis", "measurement": "reaTZon_y", "output": "ovePum_u", "deadband": -1},
+		{"kind": "hysteresis", "measurement": "reaTZon_y", "output": "ovePum_u", "band": 1},
+		{"kind": "schedule", "output": "oveTSet_u", "weekday": []any{map[string]any{"at": "7am"}}},
+	} {
+		if _, err := cfg.Controller(); err == nil {
+			t.Errorf("expected an error for %v", cfg)
+		}
+	}
+}
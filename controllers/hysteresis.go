@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	boptest "github.com/jamesryancoleman/grpc-boptest"
+)
+
+// Hysteresis switches Output on once Measurement falls half of Deadband below
+// the setpoint and off once it rises half of it above, e.g. a pump from the
+// zone temperature. Between the two the output keeps its state.
+type Hysteresis struct {
+	Measurement   string  `json:"measurement" yaml:"measurement"`
+	Output        string  `json:"output" yaml:"output"`
+	Setpoint      float64 `json:"setpoint" yaml:"setpoint"`             // unless read from SetpointPoint
+	SetpointPoint string  `json:"setpoint_point" yaml:"setpoint_point"` // e.g. reaTSetHea_y
+	Deadband      float64 `json:"deadband" yaml:"deadband"`
+
+	On  float64 `json:"on" yaml:"on"` // written when on, 1 and 0 if both are 0
+	Off float64 `json:"off" yaml:"off"`
+
+	// switch on above the setpoint and off below it, e.g. for cooling
+	Reverse bool `json:"reverse" yaml:"reverse"`
+
+	on bool
+}
+
+func (h *Hysteresis) String() string {
+	return fmt.Sprintf("hysteresis %s from %s", h.Output, h.Measurement)
+}
+
+// Validate returns an error if a point is missing or the deadband is
+// negative.
+func (h *Hysteresis) Validate() error {
+	if h.Deadband < 0 {
+		return errors.New("deadband must not be negative")
+	}
+	return validatePoints(h.Measurement, h.Output)
+}
+
+// Step writes the output for the measurement in the state.
+func (h *Hysteresis) Step(c *boptest.TestCase) error {
+	y, err := read(c, h.Measurement)
+	if err != nil {
+		return err
+	}
+	sp, err := setpoint(c, h.Setpoint, h.SetpointPoint)
+	if err != nil {
+		return err
+	}
+
+	e := sp - y
+	if h.Reverse {
+		e = -e
+	}
+	switch {
+	case e > h.Deadband/2:
+		h.on = true
+	case e < -h.Deadband/2:
+		h.on = false
+	}
+
+	on, off := h.On, h.Off
+	if on == 0 && off == 0 {
+		on = 1
+	}
+	if h.on {
+		return write(c, h.Output, on)
+	}
+	return write(c, h.Output, off)
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"testing"
+)
+
+func TestHysteresis(t *testing.T) {
+	h := &Hysteresis{Measurement: "reaTZon_y", Output: "oveHeaPumY_u", Setpoint: 296.15, Deadband: 1, On: 0.7}
+	testCase := run(t, "bestest_hydronic_heat_pump", 4*24, h)
+
+	var switches int
+	on := false
+	history := testCase.History.All()
+	for i, s := range history[:len(history)-1] {
+		// the output applied in the next advance follows the zone now
+		switch tZon := s.State["reaTZon_y"].(float64); {
+		case tZon < 295.65:
+			on = true
+		case tZon > 296.65:
+			on = false
+		}
+		want := 0.0
+		if on {
+			want = 0.7
+		}
+		got := history[i+1].Applied["oveHeaPumY_u"]
+		if got != want {
+			t.Fatalf("applied %v at %.0fs, want %v", got, history[i+1].Time, want)
+		}
+		if i > 0 && got != history[i].Applied["oveHeaPumY_u"] {
+			switches++
+		}
+	}
+	if switches < 3 {
+		t.Errorf("switched %d times in a day", switches)
+	}
+}
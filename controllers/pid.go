@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"fmt"
+
+	boptest "github.com/jamesryancoleman/grpc-boptest"
+)
+
+// PID drives Measurement to the setpoint by writing Output, e.g. a heat pump
+// signal from the zone temperature. The integral is held while the output is
+// limited, so that it does not wind up.
+type PID struct {
+	Measurement   string  `json:"measurement" yaml:"measurement"`       // e.g. reaTZon_y
+	Output        string  `json:"output" yaml:"output"`                 // e.g. oveHeaPumY_u
+	Setpoint      float64 `json:"setpoint" yaml:"setpoint"`             // unless read from SetpointPoint
+	SetpointPoint string  `json:"setpoint_point" yaml:"setpoint_point"` // e.g. reaTSetHea_y
+
+	Kp float64 `json:"kp" yaml:"kp"`
+	Ki float64 `json:"ki" yaml:"ki"` // per simulated second
+	Kd float64 `json:"kd" yaml:"kd"` // simulated seconds
+
+	Min float64 `json:"min" yaml:"min"` // output limits, none if both are 0
+	Max float64 `json:"max" yaml:"max"`
+
+	// the output rises as the measurement rises above the setpoint, e.g.
+	// for cooling
+	Reverse bool `json:"reverse" yaml:"reverse"`
+
+	integral float64
+	lastErr  float64
+	lastTime float64
+	started  bool
+}
+
+func (p *PID) String() string {
+	return fmt.Sprintf("pid %s from %s", p.Output, p.Measurement)
+}
+
+// Validate returns an error if a point is missing or the output limits are
+// reversed.
+func (p *PID) Validate() error {
+	if p.Min > p.Max {
+		return fmt.Errorf("min %g is above max %g", p.Min, p.Max)
+	}
+	return validatePoints(p.Measurement, p.Output)
+}
+
+// Step writes the output for the error at the simulated time of the state.
+func (p *PID) Step(c *boptest.TestCase) error {
+	y, err := read(c, p.Measurement)
+	if err != nil {
+		return err
+	}
+	sp, err := setpoint(c, p.Setpoint, p.SetpointPoint)
+	if err != nil {
+		return err
+	}
+	t, _ := read(c, "time")
+
+	e := sp - y
+	if p.Reverse {
+		e = -e
+	}
+	var dt, derivative float64
+	if p.started && t > p.lastTime {
+		dt = t - p.lastTime
+		derivative = (e - p.lastErr) / dt
+	}
+	u := p.Kp*e + p.Ki*(p.integral+e*dt) + p.Kd*derivative
+	if limited := limit(u, p.Min, p.Max); limited == u || (u > limited) != (e > 0) {
+		p.integral += e * dt
+	} else {
+		// integrating would drive the output further beyond its limit
+		u = p.Kp*e + p.Ki*p.integral + p.Kd*derivative
+	}
+	u = limit(u, p.Min, p.Max)
+	p.lastErr, p.lastTime, p.started = e, t, true
+	return write(c, p.Output, u)
+}
@@ -0,0 +1,18 @@
+package controllers
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPID(t *testing.T) {
+	pid := &PID{Measurement: "reaTZon_y", Output: "oveHeaPumY_u", Setpoint: 296.15, Kp: 0.5, Ki: 0.0005, Max: 1}
+	testCase := run(t, "bestest_hydronic_heat_pump", 4*24, pid)
+	state := testCase.State.GetAll()
+	if tZon := state["reaTZon_y"].(float64); math.Abs(tZon-296.15) > 0.2 {
+		t.Errorf("zone at %.2f K after a day, want 296.15 K", tZon)
+	}
+	if y := state["oveHeaPumY_u"].(float64); y <= 0 || y > 1 {
+		t.Errorf("heat pump signal %v outside its limits", y)
+	}
+}
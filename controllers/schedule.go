@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+
+	boptest "github.com/jamesryancoleman/grpc-boptest"
+)
+
+// Schedule writes a setpoint to Output by the simulated time of day, e.g. a
+// zone temperature setpoint with a night setback. It is a boptest.Scheduler
+// of one schedule, with its calendar of weekdays, weekends and holidays.
+type Schedule struct {
+	Output  string                  `json:"output" yaml:"output"`   // e.g. oveTSet_u
+	Default *float64                `json:"default" yaml:"default"` // before any entry, nil to release the output
+	Weekday []boptest.ScheduleEntry `json:"weekday" yaml:"weekday"`
+	Weekend []boptest.ScheduleEntry `json:"weekend" yaml:"weekend"`
+	Holiday []boptest.ScheduleEntry `json:"holiday" yaml:"holiday"` // the weekend profile if nil
+
+	Holidays []string `json:"holidays" yaml:"holidays"` // dates, e.g. 12-25 or 2025-11-27
+
+	scheduler *boptest.Scheduler
+}
+
+func (s *Schedule) String() string {
+	return "schedule " + s.Output
+}
+
+// the schedule file of the scheduler
+func (s *Schedule) file() boptest.ScheduleFile {
+	return boptest.ScheduleFile{
+		Holidays: s.Holidays,
+		Schedules: []boptest.Schedule{{
+			Name:    s.Output,
+			Input:   s.Output,
+			Default: s.Default,
+			Weekday: s.Weekday,
+			Weekend: s.Weekend,
+			Holiday: s.Holiday,
+		}},
+	}
+}
+
+// Validate returns an error if the output is missing or an entry or holiday
+// is not a time of day or date.
+func (s *Schedule) Validate() error {
+	if s.Output == "" {
+		return errors.New("output is required")
+	}
+	if err := s.file().Validate(); err != nil {
+		// schedules[0].x is x of the controller
+		return errors.New(strings.ReplaceAll(err.Error(), "schedules[0].", ""))
+	}
+	return nil
+}
+
+// Step writes the setpoint for the simulated time of the state.
+func (s *Schedule) Step(c *boptest.TestCase) error {
+	if s.scheduler == nil {
+		scheduler, err := boptest.NewScheduler(s.file())
+		if err != nil {
+			return err
+		}
+		s.scheduler = scheduler
+	}
+	return s.scheduler.Step(c)
+}
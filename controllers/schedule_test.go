@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"testing"
+
+	boptest "github.com/jamesryancoleman/grpc-boptest"
+)
+
+func TestSchedule(t *testing.T) {
+	day, night := 294.15, 289.15
+	profile := []boptest.ScheduleEntry{{At: "07:00", Value: &day}, {At: "19:00", Value: &night}}
+	s := &Schedule{Output: "oveTSet_u", Weekday: profile, Weekend: profile}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// set back since the evening before
+	testCase := run(t, "bestest_hydronic_heat_pump", 4*6, s)
+	if v := testCase.State.Get("oveTSet_u"); v != night {
+		t.Errorf("setpoint at 6:00 is %v, want %v", v, night)
+	}
+	testCase = run(t, "bestest_hydronic_heat_pump", 4*8, &Schedule{Output: "oveTSet_u", Weekday: profile, Weekend: profile})
+	if v := testCase.State.Get("oveTSet_u"); v != day {
+		t.Errorf("setpoint at 8:00 is %v, want %v", v, day)
+	}
+
+	for _, s := range []*Schedule{
+		{Weekday: profile},
+		{Output: "oveTSet_u", Weekday: []boptest.ScheduleEntry{{At: "7am", Value: &day}}},
+		{Output: "oveTSet_u", Holidays: []string{"12/25"}},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("expected an error for %+v", s)
+		}
+	}
+}
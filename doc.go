@@ -0,0 +1,30 @@
+// Package boptest is a driver for BOPTEST test cases served over the
+// DeviceControl gRPC service of devctrl. A point is addressed by the key
+// boptest://{test case}/{point}, e.g. boptest://air/zon_reaTRooAir_y, and a
+// key with an empty authority, e.g. boptest:///zon_reaTRooAir_y, goes to the
+// default test case.
+//
+// A Server fronts any number of named TestCases. A test case drives its
+// simulation through a Backend, the BOPTEST web service by default with an
+// HTTPBackend or an in-process Plant such as the RCPlant with a
+// PlantBackend, and advances it on a ticker with the inputs written since,
+// see WriteMode.
+//
+// Reads and writes pass through, if configured:
+//
+//   - a Policy, deciding which points a caller may read and write
+//   - SafetyRules, rejecting writes that break them
+//   - ActuatorFaults and SensorFaults, corrupting what is sent and read
+//   - NetworkConditions, emulating a flaky network to the controllers
+//   - heartbeats, releasing the inputs of a client that went quiet, see
+//     HeartbeatMetadata
+//
+// and what happens is kept by the History of a test case, an AuditLog, a
+// SeriesRecorder and the Metrics of a server. A Controller, such as a
+// Scheduler or one of the controllers package, runs inside the driver. An
+// Experiment runs a protocol of overrides against a test case and collects
+// its KPIs, and a Sweep runs one over test cases, scenarios and offsets.
+//
+// A Config, loaded from a yaml or json file with LoadConfig, describes a
+// server and its test cases; cmd/server/config.example.yaml has every option.
+package boptest
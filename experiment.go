@@ -0,0 +1,290 @@
+package boptest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Experiment is a protocol run against a test case: select it, set its
+// scenario, warm up, apply timed overrides while stepping the simulation as
+// fast as it goes, then collect KPIs and results and stop. The test case is
+// configured as in a config file, with name naming the experiment.
+type Experiment struct {
+	Host           string `json:"host" yaml:"host"` // of the boptest server, unless plant is rc
+	TestCaseConfig `yaml:",inline"`
+
+	Duration  float64    `json:"duration" yaml:"duration"`   // simulated seconds from the start
+	Overrides []Override `json:"overrides" yaml:"overrides"` // in the order of At
+	Results   []string   `json:"results" yaml:"results"`     // path.Match patterns of the points collected, all if empty
+	Runs      int        `json:"runs" yaml:"runs"`           // times the protocol is repeated, 1 if 0
+}
+
+// Override writes inputs once the simulation reaches At. They hold until
+// overwritten in latch write mode, the default for experiments, and for one
+// step in write once mode.
+type Override struct {
+	At  float64        `json:"at" yaml:"at"` // simulated seconds from the start
+	Set map[string]any `json:"set" yaml:"set"`
+}
+
+// LoadExperiment reads an experiment from a yaml or json file, overrides its
+// host with BOPTEST_HOST if set, and validates it.
+func LoadExperiment(path string) (*Experiment, error) {
+	var e Experiment
+	if err := decodeFile(path, &e); err != nil {
+		return nil, err
+	}
+
+	if host, ok := os.LookupEnv(EnvHost); ok {
+		e.Host = host
+	}
+	e.SetDefaults()
+	if err := e.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &e, nil
+}
+
+// the config the test case of the experiment is checked with, with defaults
+func (e *Experiment) config() *Config {
+	c := &Config{Host: e.Host, TestCases: []TestCaseConfig{e.TestCaseConfig}}
+	c.SetDefaults()
+	return c
+}
+
+// SetDefaults fills in the test case defaults, with latch write mode, and
+// one run.
+func (e *Experiment) SetDefaults() {
+	if e.Write == "" {
+		e.Write = WriteLatch
+	}
+	e.TestCaseConfig = e.config().TestCases[0]
+	if e.Runs == 0 {
+		e.Runs = 1
+	}
+}
+
+// Validate returns every problem with the experiment joined into one error.
+func (e *Experiment) Validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{field}, args...)...))
+	}
+
+	if err := e.config().Validate(); err != nil {
+		// test_cases[0].x is x in an experiment
+		for _, line := range strings.Split(err.Error(), "\n") {
+			errs = append(errs, errors.New(strings.TrimPrefix(line, "test_cases[0].")))
+		}
+	}
+	if e.Duration <= 0 {
+		fail("duration", "must be positive")
+	}
+	for i, o := range e.Overrides {
+		field := fmt.Sprintf("overrides[%d]", i)
+		if o.At < 0 || o.At >= e.Duration {
+			fail(field+".at", "must be within the duration, not %v", o.At)
+		}
+		if i > 0 && o.At < e.Overrides[i-1].At {
+			fail(field+".at", "is before the override above it")
+		}
+		if len(o.Set) == 0 {
+			fail(field+".set", "at least one input is required")
+		}
+	}
+	if err := (SeriesConfig{Path: "-", Format: SeriesCSV, Points: e.Results}).Validate(); err != nil {
+		fail("results", "%v", err)
+	}
+	if e.Runs < 0 {
+		fail("runs", "must not be negative")
+	}
+	return errors.Join(errs...)
+}
+
+// ExperimentRun summarizes a run of an experiment, as written to summary.json
+// in its results bundle.
+type ExperimentRun struct {
+	Experiment string `json:"experiment"`
+	Run        int    `json:"run"` // from 1
+	TestCase   string `json:"test_case"`
+	ID         string `json:"id"`
+	Dir        string `json:"dir"` // of the results bundle
+
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Start    float64   `json:"start"` // simulated seconds since the start of the year
+	Final    float64   `json:"final"`
+	Advances int       `json:"advances"`
+
+	KPI      map[string]float64 `json:"kpi,omitempty"`
+	KPIError string             `json:"kpi_error,omitempty"`
+	Error    string             `json:"error,omitempty"`    // why the run stopped early
+	Rejected []string           `json:"rejected,omitempty"` // overrides the test case refused, e.g. by a safety rule
+}
+
+// steps the test case only when the experiment advances it
+func withoutTicker() testCaseOption {
+	return func(c *TestCase) {
+		c.startNow = false
+	}
+}
+
+// Run runs the experiment Runs times, writing a results bundle for each to a
+// new directory in dir:
+//
+//	experiment.json  the experiment as run
+//	summary.json     an ExperimentRun, with the KPIs
+//	results.json     trajectories of the collected points, keyed by point with a "time" entry
+//	series.csv       the inputs and state of every advance, see SeriesRecorder
+//	audit.jsonl      every override, see AuditLog
+//
+// A run that fails is summarized with its error and the next one started. An
+// override the test case refuses does not fail the run, it is summarized in
+// Rejected.
+// Run returns the runs and their errors joined.
+func (e *Experiment) Run(ctx context.Context, dir string, log *slog.Logger) ([]ExperimentRun, error) {
+	var runs []ExperimentRun
+	var errs []error
+	for i := 1; i <= e.Runs && ctx.Err() == nil; i++ {
+		runDir := filepath.Join(dir, fmt.Sprintf("%s-%s-%d", e.Name, time.Now().Format("20060102T150405"), i))
+		run, err := e.run(ctx, runDir, i, orDefault(log))
+		runs = append(runs, run)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s run %d: %w", e.Name, i, err))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return runs, errors.Join(errs...)
+}
+
+func writeJSON(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// runs the experiment once, the ith time, writing its results bundle to dir
+func (e *Experiment) run(ctx context.Context, dir string, i int, log *slog.Logger) (ExperimentRun, error) {
+	run := ExperimentRun{Experiment: e.Name, Run: i, TestCase: e.TestCase, Dir: dir, Started: time.Now()}
+	if err := os.MkdirAll(run.Dir, 0755); err != nil {
+		return run, err
+	}
+	err := e.execute(ctx, &run, log)
+	run.Finished = time.Now()
+	if err != nil {
+		run.Error = err.Error()
+		log.Error("experiment run failed", "experiment", e.Name, "run", i, "error", err)
+	} else {
+		log.Info("experiment run finished", "experiment", e.Name, "run", i, "dir", run.Dir,
+			"advances", run.Advances, "took", run.Finished.Sub(run.Started))
+	}
+	return run, errors.Join(err, writeJSON(filepath.Join(run.Dir, "summary.json"), run))
+}
+
+// selects, runs and stops the test case of a run, filling in run
+func (e *Experiment) execute(ctx context.Context, run *ExperimentRun, log *slog.Logger) error {
+	if err := writeJSON(filepath.Join(run.Dir, "experiment.json"), e); err != nil {
+		return err
+	}
+	audit, err := NewAuditLog(filepath.Join(run.Dir, "audit.jsonl"))
+	if err != nil {
+		return err
+	}
+	defer audit.Close()
+	series, err := NewSeriesRecorder(SeriesConfig{Path: filepath.Join(run.Dir, "series.csv"), Format: SeriesCSV, Points: e.Results})
+	if err != nil {
+		return err
+	}
+	defer series.Close()
+
+	// the step is multiplied by the update frequency
+	tc := e.TestCaseConfig
+	tc.Freq = 1
+	opts, err := tc.Options(e.Host)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, withoutTicker(), WithContext(ctx), WithLogger(log), WithAuditLog(audit), WithSeriesRecorder(series))
+	testCase, err := NewTestCase(e.TestCase, opts...)
+	if testCase != nil {
+		// selected, even if it failed to begin
+		run.ID = testCase.ID
+		defer func() {
+			// stopped even if the run was cancelled
+			stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultShutdownTimeout*time.Second)
+			defer cancel()
+			if err := testCase.Shutdown(stopCtx); err != nil {
+				log.Error("unable to stop test case", "test_case", testCase.ID, "error", err)
+			}
+		}()
+	}
+	if err != nil {
+		return err
+	}
+	if err := testCase.Start(); err != nil {
+		return err
+	}
+
+	t, _ := testCase.State.Get("time").(float64)
+	run.Start, run.Final = t, t
+	results := make(map[string][]float64)
+	collect := func(state map[string]any) {
+		for point, v := range state {
+			if x, err := InputValue(v); err == nil && (point == "time" || series.selected(point)) {
+				results[point] = append(results[point], x)
+			}
+		}
+	}
+	collect(testCase.State.GetAll())
+
+	next := 0 // override
+	for t < run.Start+e.Duration {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for ; next < len(e.Overrides) && run.Start+e.Overrides[next].At <= t; next++ {
+			for _, input := range slices.Sorted(maps.Keys(e.Overrides[next].Set)) {
+				if err := testCase.SetInput(input, e.Overrides[next].Set[input]); err != nil {
+					log.Warn("override rejected", "input", input, "error", err)
+					run.Rejected = append(run.Rejected, fmt.Sprintf("%s at %v: %v", input, e.Overrides[next].At, err))
+				}
+			}
+		}
+		if err := testCase.advanceOnce(); err != nil {
+			return fmt.Errorf("advance %d: %w", run.Advances+1, err)
+		}
+		run.Advances++
+		now, _ := testCase.State.Get("time").(float64)
+		if now <= t {
+			return fmt.Errorf("simulation stopped at %v", t)
+		}
+		t, run.Final = now, now
+		collect(testCase.State.GetAll())
+	}
+
+	if kpi, err := testCase.KPI(); err != nil {
+		run.KPIError = err.Error()
+	} else {
+		run.KPI = kpi
+	}
+	// the backend's results are finer than the advances, if it keeps any
+	points := slices.Sorted(maps.Keys(results))
+	points = slices.DeleteFunc(points, func(p string) bool { return p == "time" })
+	if r, err := testCase.Results(points, run.Start, run.Final); err == nil {
+		results = r
+	}
+	return writeJSON(filepath.Join(run.Dir, "results.json"), results)
+}
@@ -0,0 +1,129 @@
+package boptest
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExperiment(t *testing.T) {
+	e := &Experiment{
+		TestCaseConfig: TestCaseConfig{Name: "setback", TestCase: "bestest_hydronic_heat_pump", Plant: PlantRC, Step: 900},
+		Duration:       4 * 3600,
+		Overrides: []Override{
+			{At: 0, Set: map[string]any{"oveTSet_activate": 1, "oveTSet_u": 294.15}},
+			{At: 3600, Set: map[string]any{"oveTSet_u": 289.15}},
+		},
+		Results: []string{"reaTZon_y", "oveTSet_*"},
+		Runs:    2,
+	}
+	e.SetDefaults()
+	if err := e.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	runs, err := e.Run(context.Background(), dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Dir == runs[1].Dir {
+		t.Fatalf("runs are %+v", runs)
+	}
+
+	run := runs[0]
+	if run.Advances != 16 || run.Final-run.Start != 4*3600 || run.Error != "" {
+		t.Errorf("run is %+v", run)
+	}
+	if !strings.Contains(run.KPIError, "not supported") {
+		t.Errorf("got kpi error %q from the rc plant", run.KPIError)
+	}
+	for _, name := range []string{"experiment.json", "summary.json", "results.json", "series.csv", "audit.jsonl"} {
+		if _, err := os.Stat(filepath.Join(run.Dir, name)); err != nil {
+			t.Errorf("bundle: %v", err)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(run.Dir, "results.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results map[string][]float64
+	if err := json.Unmarshal(b, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 || len(results["time"]) != 17 || len(results["reaTZon_y"]) != 17 {
+		t.Errorf("results have %d points, %d times", len(results), len(results["time"]))
+	}
+	// the setpoint override holds until overwritten an hour in
+	if u := results["oveTSet_u"]; u[1] != 294.15 || u[4] != 294.15 || u[5] != 289.15 || u[16] != 289.15 {
+		t.Errorf("oveTSet_u is %v", u)
+	}
+
+	b, err = os.ReadFile(filepath.Join(run.Dir, "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n != 3 {
+		t.Errorf("audited %d writes, want the 3 overrides:\n%s", n, b)
+	}
+}
+
+// an override the test case refuses is summarized, and the run goes on
+func TestExperimentRejected(t *testing.T) {
+	e := &Experiment{
+		TestCaseConfig: TestCaseConfig{Name: "typo", TestCase: "bestest_hydronic_heat_pump", Plant: PlantRC, Step: 900},
+		Duration:       2 * 3600,
+		Overrides: []Override{
+			{At: 0, Set: map[string]any{"oveTSet_activate": 1, "oveTSet_u": 294.15}},
+			{At: 3600, Set: map[string]any{"oveTSetHea_u": 289.15}},
+		},
+	}
+	e.SetDefaults()
+	runs, err := e.Run(context.Background(), t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := runs[0]
+	if run.Advances != 8 || len(run.Rejected) != 1 || !strings.HasPrefix(run.Rejected[0], "oveTSetHea_u at 3600: ") {
+		t.Errorf("run is %+v", run)
+	}
+
+	var buf strings.Builder
+	if err := WriteKPITable(&buf, []BatchRun{{Experiment: *e, ExperimentRun: run}}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(buf.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0][9] != "rejected" || rows[1][9] != run.Rejected[0] {
+		t.Errorf("kpi table is %v", rows)
+	}
+}
+
+func TestLoadExperiment(t *testing.T) {
+	e, err := LoadExperiment("cmd/experiment/experiment.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != "setback" || e.Write != WriteLatch || len(e.Overrides) != 4 || e.Runs != 3 {
+		t.Errorf("loaded %+v", e)
+	}
+
+	path := filepath.Join(t.TempDir(), "experiment.json")
+	os.WriteFile(path, []byte(`{"test_case": "bestest_air", "step": -1, "duration": 3600,
+		"overrides": [{"at": 1800, "set": {"fcu_oveFan_u": 1}}, {"at": 3600, "set": {}}], "results": ["["]}`), 0644)
+	_, err = LoadExperiment(path)
+	for _, want := range []string{"host:", "step:", "overrides[1].at", "overrides[1].set", "results:"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v does not mention %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "test_cases[0]") {
+		t.Errorf("error %v refers to the test case as in a config", err)
+	}
+}
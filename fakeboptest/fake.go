@@ -0,0 +1,486 @@
+// Package fakeboptest is an in-process fake of the BOPTEST web service, for
+// exercising the driver without a BOPTEST container. It implements select,
+// initialize, scenario, advance, step, inputs, measurements, status, stop, kpi
+// and results, with the plant simulated by a pluggable Model.
+package fakeboptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultStep = 3600 // seconds, per BOPTEST
+
+// start of the time periods of SetScenario, in seconds since start of year
+var timePeriods = map[string]float64{
+	"peak_heat_day":    16 * 24 * 3600,
+	"typical_heat_day": 108 * 24 * 3600,
+	"peak_cool_day":    210 * 24 * 3600,
+	"typical_cool_day": 242 * 24 * 3600,
+	"mix_day":          100 * 24 * 3600,
+}
+
+type option func(*Server)
+
+// serves points for the test case name, which can then be selected
+func WithPoints(name string, p Points) option {
+	return func(s *Server) {
+		s.points[name] = p
+	}
+}
+
+// creates the model of each selected test case, by default a ZoneModel for
+// bestest_air and a model that holds its state for anything else
+func WithModel(newModel func(testcase string) Model) option {
+	return func(s *Server) {
+		s.newModel = newModel
+	}
+}
+
+// Server is a fake BOPTEST web service listening on a local port.
+type Server struct {
+	*httptest.Server
+
+	points   map[string]Points
+	newModel func(testcase string) Model
+
+	testCases map[string]*testCase // keyed by testid
+	nextID    int
+	sync.Mutex
+}
+
+type testCase struct {
+	name    string
+	points  Points
+	model   Model
+	running bool
+
+	step  float64
+	state map[string]float64
+
+	// inputs of every advance, and every state for results
+	advances []map[string]float64
+	times    []float64
+	series   map[string][]float64
+
+	energy     float64 // kWh
+	discomfort float64 // Kh
+	wall       time.Duration
+}
+
+// New starts a fake BOPTEST server serving BestestAir as bestest_air.
+func New(opts ...option) *Server {
+	s := &Server{
+		points:    map[string]Points{"bestest_air": BestestAir},
+		testCases: make(map[string]*testCase),
+		newModel:  defaultModel,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /testcases/{testcase}/select", s.selectTestCase)
+	mux.HandleFunc("PUT /initialize/{testid}", s.initialize)
+	mux.HandleFunc("PUT /scenario/{testid}", s.setScenario)
+	mux.HandleFunc("POST /advance/{testid}", s.advance)
+	mux.HandleFunc("GET /step/{testid}", s.getStep)
+	mux.HandleFunc("PUT /step/{testid}", s.setStep)
+	mux.HandleFunc("GET /inputs/{testid}", s.inputs)
+	mux.HandleFunc("GET /measurements/{testid}", s.measurements)
+	mux.HandleFunc("GET /status/{testid}", s.status)
+	mux.HandleFunc("PUT /stop/{testid}", s.stop)
+	mux.HandleFunc("GET /kpi/{testid}", s.kpi)
+	mux.HandleFunc("PUT /results/{testid}", s.results)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func defaultModel(testcase string) Model {
+	if testcase == "bestest_air" {
+		return NewBestestAirModel()
+	}
+	return holdModel{}
+}
+
+// a model whose measurements never change
+type holdModel struct{}
+
+func (holdModel) Init(map[string]float64)                              {}
+func (holdModel) Step(map[string]float64, map[string]float64, float64) {}
+
+// Host returns the address of the server, for boptest.WithHost.
+func (s *Server) Host() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// Advances returns the inputs sent with every advance of a test case.
+func (s *Server) Advances(testid string) []map[string]float64 {
+	s.Lock()
+	defer s.Unlock()
+	if tc, ok := s.testCases[testid]; ok {
+		return slices.Clone(tc.advances)
+	}
+	return nil
+}
+
+// Running reports whether testid was selected and has not been stopped.
+func (s *Server) Running(testid string) bool {
+	s.Lock()
+	defer s.Unlock()
+	tc, ok := s.testCases[testid]
+	return ok && tc.running
+}
+
+func reply(w http.ResponseWriter, status int, message string, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":  status,
+		"message": message,
+		"payload": payload,
+	})
+}
+
+// returns the running test case of the request, replying with an error if
+// there is none
+func (s *Server) testCase(w http.ResponseWriter, r *http.Request) (*testCase, bool) {
+	id := r.PathValue("testid")
+	tc, ok := s.testCases[id]
+	if !ok || !tc.running {
+		reply(w, http.StatusNotFound, fmt.Sprintf("Invalid testid: %s", id), nil)
+		return nil, false
+	}
+	return tc, true
+}
+
+func (s *Server) selectTestCase(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	name := r.PathValue("testcase")
+	points, ok := s.points[name]
+	if !ok {
+		reply(w, http.StatusNotFound, fmt.Sprintf("Test case %s not found", name), nil)
+		return
+	}
+
+	s.nextID++
+	id := fmt.Sprintf("00000000-0000-0000-0000-%012d", s.nextID)
+	tc := &testCase{
+		name:    name,
+		points:  points,
+		model:   s.newModel(name),
+		running: true,
+		step:    DefaultStep,
+	}
+	tc.reset(0)
+	s.testCases[id] = tc
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"testid": id})
+}
+
+// sets the state to the initial state at time start
+func (tc *testCase) reset(start float64) {
+	tc.state = make(map[string]float64)
+	for name := range tc.points.Measurements {
+		tc.state[name] = 0
+	}
+	for name := range tc.points.Inputs {
+		tc.state[name] = 0
+	}
+	tc.model.Init(tc.state)
+	tc.state["time"] = start
+
+	tc.advances = nil
+	tc.times = nil
+	tc.series = make(map[string][]float64)
+	tc.energy, tc.discomfort, tc.wall = 0, 0, 0
+	tc.record()
+}
+
+func (tc *testCase) record() {
+	tc.times = append(tc.times, tc.state["time"])
+	for name, v := range tc.state {
+		if name != "time" {
+			tc.series[name] = append(tc.series[name], v)
+		}
+	}
+}
+
+func (tc *testCase) payload() map[string]any {
+	m := make(map[string]any, len(tc.state))
+	for k, v := range tc.state {
+		m[k] = v
+	}
+	return m
+}
+
+func (s *Server) initialize(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	tc, ok := s.testCase(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		StartTime    float64 `json:"start_time"`
+		WarmupPeriod float64 `json:"warmup_period"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		reply(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if req.StartTime < 0 || req.WarmupPeriod < 0 {
+		reply(w, http.StatusBadRequest, "start_time and warmup_period must not be negative", nil)
+		return
+	}
+	tc.reset(req.StartTime)
+	reply(w, http.StatusOK, "Test case initialized successfully.", tc.payload())
+}
+
+func (s *Server) setScenario(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	tc, ok := s.testCase(w, r)
+	if !ok {
+		return
+	}
+
+	var req map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		reply(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	payload := map[string]any{}
+	if period, ok := req["time_period"]; ok {
+		start, ok := timePeriods[period]
+		if !ok {
+			reply(w, http.StatusBadRequest, fmt.Sprintf("Invalid time_period: %s", period), nil)
+			return
+		}
+		tc.reset(start)
+		payload["time_period"] = tc.payload()
+	}
+	if price, ok := req["electricity_price"]; ok {
+		payload["electricity_price"] = price
+	}
+	reply(w, http.StatusOK, "Scenario set successfully.", payload)
+}
+
+// converts an input value, which may be a number, bool or numeric string
+func inputValue(v any) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	return 0, fmt.Errorf("unsupported value %v", v)
+}
+
+func (s *Server) advance(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	tc, ok := s.testCase(w, r)
+	if !ok {
+		return
+	}
+
+	var req map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		reply(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	inputs := make(map[string]float64, len(req))
+	for name, raw := range req {
+		if _, ok := tc.points.Inputs[name]; !ok {
+			reply(w, http.StatusBadRequest, fmt.Sprintf("Unexpected input variable: %s.", name), nil)
+			return
+		}
+		v, err := inputValue(raw)
+		if err != nil {
+			reply(w, http.StatusBadRequest, fmt.Sprintf("Invalid value for %s: %v", name, err), nil)
+			return
+		}
+		inputs[name] = v
+	}
+
+	started := time.Now()
+	// inputs that are not sent are released to the baseline control
+	for name := range tc.points.Inputs {
+		if strings.HasSuffix(name, "_activate") {
+			tc.state[name] = 0
+		}
+	}
+	maps.Copy(tc.state, inputs)
+	tc.model.Step(tc.state, inputs, tc.step)
+	tc.state["time"] += tc.step
+	tc.wall += time.Since(started)
+
+	// kpis
+	for name, p := range tc.points.Measurements {
+		switch {
+		case p.Unit != nil && *p.Unit == "W" && strings.Contains(name, "reaP"):
+			tc.energy += math.Abs(tc.state[name]) * tc.step / 3600 / 1000
+		case strings.Contains(name, "reaTRoo") || strings.Contains(name, "reaTZon"):
+			t := tc.state[name]
+			tc.discomfort += (math.Max(293.15-t, 0) + math.Max(t-297.15, 0)) * tc.step / 3600
+		}
+	}
+
+	tc.advances = append(tc.advances, inputs)
+	tc.record()
+	reply(w, http.StatusOK, "Advanced simulation successfully.", tc.payload())
+}
+
+func (s *Server) getStep(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	tc, ok := s.testCase(w, r)
+	if !ok {
+		return
+	}
+	reply(w, http.StatusOK, "Queried simulation step successfully.", tc.step)
+}
+
+func (s *Server) setStep(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	tc, ok := s.testCase(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Step float64 `json:"step"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Step <= 0 {
+		reply(w, http.StatusBadRequest, "step must be a positive number", nil)
+		return
+	}
+	tc.step = req.Step
+	reply(w, http.StatusOK, "Simulation step set successfully.", map[string]float64{"step": tc.step})
+}
+
+func (s *Server) inputs(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	tc, ok := s.testCase(w, r)
+	if !ok {
+		return
+	}
+	reply(w, http.StatusOK, "Queried the inputs successfully.", tc.points.Inputs)
+}
+
+func (s *Server) measurements(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	tc, ok := s.testCase(w, r)
+	if !ok {
+		return
+	}
+	reply(w, http.StatusOK, "Queried the measurements successfully.", tc.points.Measurements)
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if tc, ok := s.testCases[r.PathValue("testid")]; ok && tc.running {
+		w.Write([]byte(`"Running"`))
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(`"Stopped"`))
+}
+
+func (s *Server) stop(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	tc, ok := s.testCase(w, r)
+	if !ok {
+		return
+	}
+	tc.running = false
+	reply(w, http.StatusOK, "Stopped test case successfully.", nil)
+}
+
+func (s *Server) kpi(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	tc, ok := s.testCase(w, r)
+	if !ok {
+		return
+	}
+
+	var timeRatio float64
+	if simulated := tc.state["time"] - tc.times[0]; simulated > 0 {
+		timeRatio = tc.wall.Seconds() / simulated
+	}
+	reply(w, http.StatusOK, "Queried KPIs successfully.", map[string]float64{
+		"cost_tot": tc.energy * 0.2,
+		"emis_tot": tc.energy * 0.5,
+		"ener_tot": tc.energy,
+		"idis_tot": 0,
+		"pdih_tot": 0,
+		"pele_tot": 0,
+		"pgas_tot": 0,
+		"tdis_tot": tc.discomfort,
+		"time_rat": timeRatio,
+	})
+}
+
+func (s *Server) results(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	tc, ok := s.testCase(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		PointNames []string `json:"point_names"`
+		StartTime  float64  `json:"start_time"`
+		FinalTime  float64  `json:"final_time"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		reply(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	payload := map[string][]float64{"time": {}}
+	for _, name := range req.PointNames {
+		if _, ok := tc.series[name]; !ok {
+			reply(w, http.StatusBadRequest, fmt.Sprintf("Invalid point name: %s", name), nil)
+			return
+		}
+		payload[name] = []float64{}
+	}
+	for i, t := range tc.times {
+		if t < req.StartTime || t > req.FinalTime {
+			continue
+		}
+		payload["time"] = append(payload["time"], t)
+		for _, name := range req.PointNames {
+			payload[name] = append(payload[name], tc.series[name][i])
+		}
+	}
+	reply(w, http.StatusOK, "Queried results data successfully.", payload)
+}
@@ -0,0 +1,163 @@
+package fakeboptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// sends a request to the fake and decodes the reply into v
+func do(t *testing.T, s *Server, method, path string, body any, v any) int {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(method, s.URL+path, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+type response struct {
+	Status  int                `json:"status"`
+	Message string             `json:"message"`
+	Payload map[string]float64 `json:"payload"`
+}
+
+func selectBestestAir(t *testing.T, s *Server) string {
+	t.Helper()
+	var sel struct {
+		TestID string `json:"testid"`
+	}
+	if code := do(t, s, http.MethodPost, "/testcases/bestest_air/select", nil, &sel); code != http.StatusOK {
+		t.Fatalf("select returned %d", code)
+	}
+	return sel.TestID
+}
+
+func TestFakeAdvance(t *testing.T) {
+	s := New()
+	defer s.Close()
+	id := selectBestestAir(t, s)
+
+	var resp response
+	do(t, s, http.MethodPut, "/initialize/"+id, map[string]float64{"start_time": 86400, "warmup_period": 0}, &resp)
+	if resp.Payload["time"] != 86400 || resp.Payload["zon_reaTRooAir_y"] != 293.15 {
+		t.Fatalf("initial state is %v", resp.Payload)
+	}
+
+	// free floating, the zone cools towards the outside until the baseline
+	// heating setpoint holds it
+	do(t, s, http.MethodPost, "/advance/"+id, map[string]any{}, &resp)
+	if got := resp.Payload["zon_reaTRooAir_y"]; got != 294.15 {
+		t.Errorf("zone temperature is %v, want the baseline setpoint 294.15", got)
+	}
+
+	do(t, s, http.MethodPost, "/advance/"+id, map[string]any{
+		"con_oveTSetHea_activate": 1,
+		"con_oveTSetHea_u":        "296.15", // numeric strings are accepted
+	}, &resp)
+	if got := resp.Payload["zon_reaTRooAir_y"]; got != 296.15 {
+		t.Errorf("zone temperature is %v, want the overwritten setpoint 296.15", got)
+	}
+	if got := resp.Payload["time"]; got != 86400+2*DefaultStep {
+		t.Errorf("time is %v", got)
+	}
+
+	// the overwrite is released when it is not sent
+	do(t, s, http.MethodPost, "/advance/"+id, map[string]any{}, &resp)
+	if got := resp.Payload["con_oveTSetHea_activate"]; got != 0 {
+		t.Errorf("con_oveTSetHea_activate is %v, want 0", got)
+	}
+
+	if n := len(s.Advances(id)); n != 3 {
+		t.Errorf("%d advances recorded, want 3", n)
+	}
+
+	code := do(t, s, http.MethodPost, "/advance/"+id, map[string]any{"nope_u": 1}, &resp)
+	if code != http.StatusBadRequest {
+		t.Errorf("advance with an unknown input returned %d", code)
+	}
+}
+
+func TestFakeResultsAndKPI(t *testing.T) {
+	s := New()
+	defer s.Close()
+	id := selectBestestAir(t, s)
+
+	do(t, s, http.MethodPut, "/step/"+id, map[string]float64{"step": 900}, nil)
+	for range 4 {
+		do(t, s, http.MethodPost, "/advance/"+id, map[string]any{}, nil)
+	}
+
+	var results struct {
+		Payload map[string][]float64 `json:"payload"`
+	}
+	do(t, s, http.MethodPut, "/results/"+id, map[string]any{
+		"point_names": []string{"zon_reaTRooAir_y"},
+		"start_time":  900,
+		"final_time":  2700,
+	}, &results)
+	if got := results.Payload["time"]; len(got) != 3 || got[0] != 900 || got[2] != 2700 {
+		t.Errorf("result times are %v", got)
+	}
+	if got := len(results.Payload["zon_reaTRooAir_y"]); got != 3 {
+		t.Errorf("%d results, want 3", got)
+	}
+
+	var kpi response
+	do(t, s, http.MethodGet, "/kpi/"+id, nil, &kpi)
+	if kpi.Payload["ener_tot"] <= 0 {
+		t.Errorf("ener_tot is %v, want > 0", kpi.Payload["ener_tot"])
+	}
+	if _, ok := kpi.Payload["tdis_tot"]; !ok {
+		t.Errorf("missing tdis_tot in %v", kpi.Payload)
+	}
+}
+
+func TestFakeStop(t *testing.T) {
+	s := New()
+	defer s.Close()
+	id := selectBestestAir(t, s)
+
+	if code := do(t, s, http.MethodGet, "/status/"+id, nil, nil); code != http.StatusOK {
+		t.Errorf("status of a running test case returned %d", code)
+	}
+	do(t, s, http.MethodPut, "/stop/"+id, nil, nil)
+	if s.Running(id) {
+		t.Errorf("test case running after stop")
+	}
+	if code := do(t, s, http.MethodPost, "/advance/"+id, map[string]any{}, nil); code != http.StatusNotFound {
+		t.Errorf("advance of a stopped test case returned %d", code)
+	}
+	if code := do(t, s, http.MethodPost, "/testcases/nope/select", nil, nil); code != http.StatusNotFound {
+		t.Errorf("select of an unknown test case returned %d", code)
+	}
+}
+
+func TestLoadPoints(t *testing.T) {
+	p, err := LoadPoints("../msgs/inputs.json", "../msgs/measurements.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Inputs) == 0 || len(p.Measurements) == 0 {
+		t.Fatalf("loaded %d inputs and %d measurements", len(p.Inputs), len(p.Measurements))
+	}
+	if in, ok := p.Inputs["oveFan_activate"]; !ok || in.Unit != nil {
+		t.Errorf("oveFan_activate is %+v", in)
+	}
+}
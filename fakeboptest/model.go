@@ -0,0 +1,98 @@
+package fakeboptest
+
+import (
+	"math"
+	"strings"
+)
+
+// Model is the plant simulated by the fake. It is called with the fake's lock
+// held, so it does not need to be safe for concurrent use.
+type Model interface {
+	// Init sets the initial value of the measurements in state
+	Init(state map[string]float64)
+	// Step advances state by dt seconds with the inputs of the advance applied
+	Step(state map[string]float64, inputs map[string]float64, dt float64)
+}
+
+// ZoneModel is a toy first order thermal model of a single zone. The zone
+// relaxes towards the outside temperature with time constant Tau and an ideal
+// heating and cooling system holds it between its setpoints, which can be
+// overwritten through inputs.
+type ZoneModel struct {
+	Zone    string // zone temperature measurement
+	Outside string // outside temperature measurement, optional
+	Power   string // heating power measurement, optional
+
+	Heating string // heating setpoint input, its _activate input enables it
+	Cooling string // cooling setpoint input, its _activate input enables it
+
+	HeatingSetpoint float64 // K, baseline
+	CoolingSetpoint float64 // K, baseline
+	TOutside        float64 // K
+	TInitial        float64 // K
+	Tau             float64 // seconds
+	UA              float64 // W/K, sizes the power measurement
+}
+
+// NewBestestAirModel returns a ZoneModel for the points in BestestAir.
+func NewBestestAirModel() *ZoneModel {
+	return &ZoneModel{
+		Zone:            "zon_reaTRooAir_y",
+		Outside:         "zon_weaSta_reaWeaTDryBul_y",
+		Power:           "fcu_reaPHea_y",
+		Heating:         "con_oveTSetHea_u",
+		Cooling:         "con_oveTSetCoo_u",
+		HeatingSetpoint: 294.15,
+		CoolingSetpoint: 297.15,
+		TOutside:        273.15,
+		TInitial:        293.15,
+		Tau:             6 * 3600,
+		UA:              100,
+	}
+}
+
+func (m *ZoneModel) Init(state map[string]float64) {
+	state[m.Zone] = m.TInitial
+	if m.Outside != "" {
+		state[m.Outside] = m.TOutside
+	}
+}
+
+// returns the overwritten value of input if it is activated, else baseline
+func overwritten(inputs map[string]float64, input string, baseline float64) float64 {
+	if input == "" {
+		return baseline
+	}
+	if inputs[strings.TrimSuffix(input, "_u")+"_activate"] != 1 {
+		return baseline
+	}
+	if v, ok := inputs[input]; ok {
+		return v
+	}
+	return baseline
+}
+
+func (m *ZoneModel) Step(state map[string]float64, inputs map[string]float64, dt float64) {
+	heating := overwritten(inputs, m.Heating, m.HeatingSetpoint)
+	cooling := overwritten(inputs, m.Cooling, m.CoolingSetpoint)
+
+	// free floating response
+	t := state[m.Zone]
+	t += (m.TOutside - t) * (1 - math.Exp(-dt/m.Tau))
+
+	// the ideal system brings the zone back within its setpoints
+	var power float64
+	switch {
+	case t < heating:
+		power = m.UA * (heating - m.TOutside)
+		t = heating
+	case t > cooling:
+		power = -m.UA * (cooling - m.TOutside)
+		t = cooling
+	}
+
+	state[m.Zone] = t
+	if m.Power != "" {
+		state[m.Power] = math.Max(power, 0)
+	}
+}
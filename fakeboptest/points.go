@@ -0,0 +1,79 @@
+package fakeboptest
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Point mirrors the properties BOPTEST returns for inputs and measurements.
+// Missing units and limits are null, as they are in BOPTEST.
+type Point struct {
+	Unit        *string
+	Description string
+	Minimum     *float64
+	Maximum     *float64
+}
+
+// Points are the inputs and measurements of a test case.
+type Points struct {
+	Inputs       map[string]Point
+	Measurements map[string]Point
+}
+
+func unit(u string) *string    { return &u }
+func limit(v float64) *float64 { return &v }
+func input(u, desc string, min, max float64) Point {
+	return Point{Unit: unit(u), Description: desc, Minimum: limit(min), Maximum: limit(max)}
+}
+func activate(desc string) Point { return Point{Description: "Activation for " + desc} }
+func measurement(u, desc string) Point {
+	return Point{Unit: unit(u), Description: desc}
+}
+
+// BestestAir is a subset of the points of the bestest_air test case.
+var BestestAir = Points{
+	Inputs: map[string]Point{
+		"con_oveTSetCoo_activate": activate("Zone temperature setpoint for cooling"),
+		"con_oveTSetCoo_u":        input("K", "Zone temperature setpoint for cooling", 285.15, 313.15),
+		"con_oveTSetHea_activate": activate("Zone temperature setpoint for heating"),
+		"con_oveTSetHea_u":        input("K", "Zone temperature setpoint for heating", 278.15, 308.15),
+		"fcu_oveFan_activate":     activate("Fan control signal as air mass flow rate normalized to the design fan air mass flow rate"),
+		"fcu_oveFan_u":            input("1", "Fan control signal as air mass flow rate normalized to the design fan air mass flow rate", 0, 1),
+		"fcu_oveTSup_activate":    activate("Supply air temperature setpoint"),
+		"fcu_oveTSup_u":           input("K", "Supply air temperature setpoint", 285.15, 313.15),
+	},
+	Measurements: map[string]Point{
+		"con_reaTSetCoo_y":           measurement("K", "Zone air temperature setpoint for cooling"),
+		"con_reaTSetHea_y":           measurement("K", "Zone air temperature setpoint for heating"),
+		"fcu_reaFloSup_y":            measurement("kg/s", "Supply air mass flow rate"),
+		"fcu_reaPCoo_y":              measurement("W", "Cooling electrical power consumption"),
+		"fcu_reaPFan_y":              measurement("W", "Supply fan electrical power consumption"),
+		"fcu_reaPHea_y":              measurement("W", "Heating thermal power consumption"),
+		"zon_reaCO2RooAir_y":         measurement("ppm", "Zone air CO2 concentration"),
+		"zon_reaTRooAir_y":           measurement("K", "Zone air temperature"),
+		"zon_weaSta_reaWeaTDryBul_y": measurement("K", "Outside drybulb temperature measurement"),
+	},
+}
+
+// LoadPoints reads the responses of BOPTEST's inputs and measurements
+// requests, e.g. msgs/inputs.json and msgs/measurements.json.
+func LoadPoints(inputsFile, measurementsFile string) (Points, error) {
+	var p Points
+	for file, m := range map[string]*map[string]Point{
+		inputsFile:       &p.Inputs,
+		measurementsFile: &p.Measurements,
+	} {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return p, err
+		}
+		var resp struct {
+			Payload map[string]Point `json:"payload"`
+		}
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return p, err
+		}
+		*m = resp.Payload
+	}
+	return p, nil
+}
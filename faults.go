@@ -0,0 +1,429 @@
+package boptest
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// FaultKind is the way a fault corrupts a value.
+type FaultKind string
+
+// sensor faults
+const (
+	FaultBias    FaultKind = "bias"    // adds Value
+	FaultDrift   FaultKind = "drift"   // adds Value per simulated hour since Start
+	FaultNoise   FaultKind = "noise"   // adds gaussian noise with standard deviation Value
+	FaultStuck   FaultKind = "stuck"   // holds the value the point had at Start
+	FaultDropout FaultKind = "dropout" // fails reads with probability Value, or always if 0
+	FaultDelay   FaultKind = "delay"   // reports the value the point had Delay seconds ago
+)
+
+// actuator faults, which also include FaultStuck and FaultDelay
+const (
+	FaultSaturate FaultKind = "saturate" // limits the command to at most Value
+	FaultIgnore   FaultKind = "ignore"   // drops the command, leaving the baseline control
+	FaultInvert   FaultKind = "invert"   // sends Value minus the command, Value 0 means 1
+)
+
+// SensorFault corrupts the value of a point read through Server.Get while
+// the simulated time is in [Start, End). The simulation is not affected.
+type SensorFault struct {
+	Name  string    `json:"name" yaml:"name"` // defaults to point:kind
+	Point string    `json:"point" yaml:"point"`
+	Kind  FaultKind `json:"kind" yaml:"kind"`
+	Value float64   `json:"value" yaml:"value"` // magnitude, see FaultKind
+	Delay float64   `json:"delay" yaml:"delay"` // seconds, for FaultDelay
+	Start float64   `json:"start" yaml:"start"` // seconds since start of year
+	End   float64   `json:"end" yaml:"end"`     // seconds since start of year, 0 for never
+
+	Disabled bool `json:"disabled" yaml:"disabled"` // toggled with SensorFaults.Enable
+}
+
+func (f SensorFault) validate() error {
+	var errs []error
+	if f.Point == "" {
+		errs = append(errs, fmt.Errorf("point is required"))
+	}
+	switch f.Kind {
+	case FaultBias, FaultDrift, FaultStuck:
+	case FaultNoise:
+		if f.Value < 0 {
+			errs = append(errs, fmt.Errorf("value of noise must not be negative"))
+		}
+	case FaultDropout:
+		if f.Value < 0 || f.Value > 1 {
+			errs = append(errs, fmt.Errorf("value of dropout must be a probability"))
+		}
+	case FaultDelay:
+		if f.Delay <= 0 {
+			errs = append(errs, fmt.Errorf("delay must be positive"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown sensor fault kind %q", f.Kind))
+	}
+	if f.End != 0 && f.End <= f.Start {
+		errs = append(errs, fmt.Errorf("end must be after start"))
+	}
+	return errors.Join(errs...)
+}
+
+// active at simulated time t
+func (f SensorFault) active(t float64) bool {
+	return !f.Disabled && t >= f.Start && (f.End == 0 || t < f.End)
+}
+
+// faults that replace the value are applied before those that modify it
+var sensorFaultOrder = []FaultKind{FaultStuck, FaultDelay, FaultBias, FaultDrift, FaultNoise, FaultDropout}
+
+// SensorFaults is a concurrency safe set of sensor faults, keyed by name. A
+// nil SensorFaults passes every value through.
+type SensorFaults struct {
+	faults []SensorFault
+	stuck  map[string]any // value held by each stuck fault
+	rng    *rand.Rand     // noise and dropouts
+	sync.Mutex
+}
+
+// NewSensorFaults returns a set of faults whose noise and dropouts are drawn
+// from a source seeded with seed, so that runs are reproducible.
+func NewSensorFaults(seed int64, faults ...SensorFault) (*SensorFaults, error) {
+	s := &SensorFaults{
+		stuck: make(map[string]any),
+		rng:   rand.New(rand.NewSource(seed)),
+	}
+	for _, f := range faults {
+		if err := s.Add(f); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Add adds a fault, replacing any with the same name.
+func (s *SensorFaults) Add(f SensorFault) error {
+	if err := f.validate(); err != nil {
+		return fmt.Errorf("sensor fault %q: %w", f.Name, err)
+	}
+	if f.Name == "" {
+		f.Name = f.Point + ":" + string(f.Kind)
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.faults = slices.DeleteFunc(s.faults, func(g SensorFault) bool { return g.Name == f.Name })
+	s.faults = append(s.faults, f)
+	delete(s.stuck, f.Name)
+	return nil
+}
+
+// Remove removes the named fault and reports whether it existed.
+func (s *SensorFaults) Remove(name string) bool {
+	s.Lock()
+	defer s.Unlock()
+	n := len(s.faults)
+	s.faults = slices.DeleteFunc(s.faults, func(f SensorFault) bool { return f.Name == name })
+	delete(s.stuck, name)
+	return len(s.faults) < n
+}
+
+// Enable turns the named fault on or off without removing it.
+func (s *SensorFaults) Enable(name string, on bool) error {
+	s.Lock()
+	defer s.Unlock()
+	for i := range s.faults {
+		if s.faults[i].Name == name {
+			s.faults[i].Disabled = !on
+			delete(s.stuck, name)
+			return nil
+		}
+	}
+	return fmt.Errorf("no sensor fault %q", name)
+}
+
+// Faults returns every fault, in the order they were added.
+func (s *SensorFaults) Faults() []SensorFault {
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	return slices.Clone(s.faults)
+}
+
+// Apply returns value, the reading of point at simulated time t, as corrupted
+// by the active faults on point. history provides past values for stuck and
+// delayed faults. A dropout returns an error.
+func (s *SensorFaults) Apply(point string, value any, t float64, history *History) (any, error) {
+	if s == nil {
+		return value, nil
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	for _, kind := range sensorFaultOrder {
+		for _, f := range s.faults {
+			if f.Point != point || f.Kind != kind || !f.active(t) {
+				continue
+			}
+
+			switch f.Kind {
+			case FaultStuck:
+				held, ok := s.stuck[f.Name]
+				if !ok {
+					held = value
+					if snapshot, found := history.At(f.Start); found && snapshot.State[point] != nil {
+						held = snapshot.State[point]
+					}
+					s.stuck[f.Name] = held
+				}
+				value = held
+			case FaultDelay:
+				snapshot, found := history.At(t - f.Delay)
+				if !found || snapshot.State[point] == nil {
+					return nil, fmt.Errorf("sensor fault %q: no value %gs before %g", f.Name, f.Delay, t)
+				}
+				value = snapshot.State[point]
+			case FaultDropout:
+				if f.Value == 0 || s.rng.Float64() < f.Value {
+					return nil, fmt.Errorf("sensor fault %q: dropout", f.Name)
+				}
+			default:
+				v, ok := value.(float64)
+				if !ok {
+					continue // only numbers can be biased, drift or be noisy
+				}
+				switch f.Kind {
+				case FaultBias:
+					v += f.Value
+				case FaultDrift:
+					v += f.Value * (t - f.Start) / 3600
+				case FaultNoise:
+					v += s.rng.NormFloat64() * f.Value
+				}
+				value = v
+			}
+		}
+	}
+	return value, nil
+}
+
+// Read returns the value of point as clients see it, through the sensor
+// faults active at the current simulated time.
+func (c *TestCase) Read(point string) (any, error) {
+	v := c.State.Get(point)
+	if v == nil {
+		return nil, fmt.Errorf("point %q not found in %q", point, c.Name)
+	}
+	t, _ := c.State.Get("time").(float64)
+	return c.SensorFaults.Apply(point, v, t, c.History)
+}
+
+// corrupt reads of the test case's points with faults
+func WithSensorFaults(faults *SensorFaults) testCaseOption {
+	return func(c *TestCase) {
+		c.SensorFaults = faults
+	}
+}
+
+// ActuatorFault corrupts the command written to an input before it is sent
+// with an advance, while the simulated time is in [Start, End). A fault on an
+// overwrite input, e.g. oveTSet_u, also governs its activation input.
+//
+// FaultStuck holds the input at Value whether or not a command is written and
+// FaultDelay sends the command written Steps advances earlier.
+type ActuatorFault struct {
+	Name  string    `json:"name" yaml:"name"` // defaults to input:kind
+	Input string    `json:"input" yaml:"input"`
+	Kind  FaultKind `json:"kind" yaml:"kind"`
+	Value float64   `json:"value" yaml:"value"` // see FaultKind
+	Steps int       `json:"steps" yaml:"steps"` // advances, for FaultDelay
+	Start float64   `json:"start" yaml:"start"` // seconds since start of year
+	End   float64   `json:"end" yaml:"end"`     // seconds since start of year, 0 for never
+
+	Disabled bool `json:"disabled" yaml:"disabled"` // toggled with ActuatorFaults.Enable
+}
+
+func (f ActuatorFault) validate() error {
+	var errs []error
+	if f.Input == "" {
+		errs = append(errs, fmt.Errorf("input is required"))
+	}
+	switch f.Kind {
+	case FaultStuck, FaultSaturate, FaultIgnore, FaultInvert:
+	case FaultDelay:
+		if f.Steps <= 0 {
+			errs = append(errs, fmt.Errorf("steps must be positive"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown actuator fault kind %q", f.Kind))
+	}
+	if f.End != 0 && f.End <= f.Start {
+		errs = append(errs, fmt.Errorf("end must be after start"))
+	}
+	return errors.Join(errs...)
+}
+
+// active at simulated time t
+func (f ActuatorFault) active(t float64) bool {
+	return !f.Disabled && t >= f.Start && (f.End == 0 || t < f.End)
+}
+
+// delays and dropped commands are applied before those that change the value
+var actuatorFaultOrder = []FaultKind{FaultDelay, FaultIgnore, FaultStuck, FaultInvert, FaultSaturate}
+
+// ActuatorFaults is a concurrency safe set of actuator faults, keyed by name. A
+// nil ActuatorFaults passes every command through.
+type ActuatorFaults struct {
+	faults  []ActuatorFault
+	delayed map[string][]map[string]any // commands queued by each delay fault
+	sync.Mutex
+}
+
+func NewActuatorFaults(faults ...ActuatorFault) (*ActuatorFaults, error) {
+	a := &ActuatorFaults{delayed: make(map[string][]map[string]any)}
+	for _, f := range faults {
+		if err := a.Add(f); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Add adds a fault, replacing any with the same name.
+func (a *ActuatorFaults) Add(f ActuatorFault) error {
+	if err := f.validate(); err != nil {
+		return fmt.Errorf("actuator fault %q: %w", f.Name, err)
+	}
+	if f.Name == "" {
+		f.Name = f.Input + ":" + string(f.Kind)
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.faults = slices.DeleteFunc(a.faults, func(g ActuatorFault) bool { return g.Name == f.Name })
+	a.faults = append(a.faults, f)
+	delete(a.delayed, f.Name)
+	return nil
+}
+
+// Remove removes the named fault and reports whether it existed.
+func (a *ActuatorFaults) Remove(name string) bool {
+	a.Lock()
+	defer a.Unlock()
+	n := len(a.faults)
+	a.faults = slices.DeleteFunc(a.faults, func(f ActuatorFault) bool { return f.Name == name })
+	delete(a.delayed, name)
+	return len(a.faults) < n
+}
+
+// Enable turns the named fault on or off without removing it.
+func (a *ActuatorFaults) Enable(name string, on bool) error {
+	a.Lock()
+	defer a.Unlock()
+	for i := range a.faults {
+		if a.faults[i].Name == name {
+			a.faults[i].Disabled = !on
+			delete(a.delayed, name)
+			return nil
+		}
+	}
+	return fmt.Errorf("no actuator fault %q", name)
+}
+
+// Faults returns every fault, in the order they were added.
+func (a *ActuatorFaults) Faults() []ActuatorFault {
+	if a == nil {
+		return nil
+	}
+	a.Lock()
+	defer a.Unlock()
+	return slices.Clone(a.faults)
+}
+
+// Apply returns the inputs to send with an advance from simulated time t, as
+// corrupted by the active faults, with those a fault cannot corrupt, e.g. a
+// value that is not a number, dropped, and why each was. inputs is not
+// modified. It is called once per advance, which delay faults count as a step.
+func (a *ActuatorFaults) Apply(inputs map[string]any, t float64) (map[string]any, map[string]error) {
+	if a == nil {
+		return inputs, nil
+	}
+	a.Lock()
+	defer a.Unlock()
+
+	dropped := make(map[string]error)
+	applied := maps.Clone(inputs)
+	if applied == nil {
+		applied = map[string]any{}
+	}
+	for _, kind := range actuatorFaultOrder {
+		for _, f := range a.faults {
+			if f.Kind != kind || !f.active(t) {
+				continue
+			}
+			names := []string{f.Input}
+			if strings.HasSuffix(f.Input, "_"+SuffixOverride) {
+				names = append(names, activation(f.Input))
+			}
+
+			switch f.Kind {
+			case FaultDelay:
+				command := make(map[string]any)
+				for _, name := range names {
+					if v, ok := applied[name]; ok {
+						command[name] = v
+					}
+					delete(applied, name)
+				}
+				queue := append(a.delayed[f.Name], command)
+				if len(queue) > f.Steps {
+					maps.Copy(applied, queue[0])
+					queue = queue[1:]
+				}
+				a.delayed[f.Name] = queue
+			case FaultIgnore:
+				for _, name := range names {
+					delete(applied, name)
+				}
+			case FaultStuck:
+				applied[f.Input] = f.Value
+				if len(names) > 1 {
+					applied[names[1]] = 1
+				}
+			default:
+				raw, ok := applied[f.Input]
+				if !ok {
+					continue
+				}
+				v, err := InputValue(raw)
+				if err != nil {
+					dropped[f.Input] = fmt.Errorf("actuator fault %q: %w", f.Name, err)
+					delete(applied, f.Input)
+					continue
+				}
+				switch f.Kind {
+				case FaultInvert:
+					span := f.Value
+					if span == 0 {
+						span = 1
+					}
+					v = span - v
+				case FaultSaturate:
+					v = min(v, f.Value)
+				}
+				applied[f.Input] = v
+			}
+		}
+	}
+	return applied, dropped
+}
+
+// corrupt the commands written to the test case's inputs with faults
+func WithActuatorFaults(faults *ActuatorFaults) testCaseOption {
+	return func(c *TestCase) {
+		c.ActuatorFaults = faults
+	}
+}
@@ -0,0 +1,297 @@
+package boptest
+
+import (
+	"context"
+	"maps"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jamesryancoleman/bos/common"
+)
+
+// a test case whose zone temperature rises by 1 K every hour from 290 K
+func faultyTestCase(t *testing.T, hours int, faults ...SensorFault) *TestCase {
+	t.Helper()
+	sf, err := NewSensorFaults(1, faults...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCase := offlineTestCase("air", nil)
+	testCase.History = NewHistory(100)
+	testCase.SensorFaults = sf
+	for h := 0; h <= hours; h++ {
+		state := map[string]any{"time": float64(h * 3600), "zon_reaTRooAir_y": 290.0 + float64(h)}
+		testCase.State.SetAll(state)
+		testCase.History.Add(state)
+	}
+	return testCase
+}
+
+func read(t *testing.T, testCase *TestCase, point string) float64 {
+	t.Helper()
+	v, err := testCase.Read(point)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.(float64)
+}
+
+func TestSensorFaults(t *testing.T) {
+	const zone = "zon_reaTRooAir_y"
+	for _, test := range []struct {
+		fault SensorFault
+		want  float64
+	}{
+		{SensorFault{Point: zone, Kind: FaultBias, Value: 1.5}, 295.5},
+		{SensorFault{Point: zone, Kind: FaultDrift, Value: 0.5, Start: 3600}, 295.5},
+		{SensorFault{Point: zone, Kind: FaultStuck, Start: 2 * 3600}, 292},
+		{SensorFault{Point: zone, Kind: FaultDelay, Delay: 3 * 3600}, 291},
+		{SensorFault{Point: zone, Kind: FaultBias, Value: 1, Start: 5 * 3600}, 294}, // not started
+		{SensorFault{Point: zone, Kind: FaultBias, Value: 1, End: 3600}, 294},       // over
+		{SensorFault{Point: zone, Kind: FaultBias, Value: 1, Disabled: true}, 294},
+		{SensorFault{Point: "time", Kind: FaultBias, Value: 1}, 294}, // another point
+	} {
+		testCase := faultyTestCase(t, 4, test.fault)
+		if got := read(t, testCase, zone); got != test.want {
+			t.Errorf("%+v: read %v, want %v", test.fault, got, test.want)
+		}
+	}
+
+	// faults on the same point compose, replacing before modifying
+	testCase := faultyTestCase(t, 4,
+		SensorFault{Point: zone, Kind: FaultBias, Value: 1},
+		SensorFault{Point: zone, Kind: FaultStuck, Start: 3600},
+	)
+	if got := read(t, testCase, zone); got != 292 {
+		t.Errorf("stuck and biased read %v, want 292", got)
+	}
+}
+
+func TestSensorFaultNoise(t *testing.T) {
+	const zone = "zon_reaTRooAir_y"
+	testCase := faultyTestCase(t, 4, SensorFault{Point: zone, Kind: FaultNoise, Value: 0.1})
+	var sum, sumSq float64
+	const n = 2000
+	for range n {
+		d := read(t, testCase, zone) - 294
+		sum += d
+		sumSq += d * d
+	}
+	mean := sum / n
+	std := math.Sqrt(sumSq/n - mean*mean)
+	if math.Abs(mean) > 0.01 || math.Abs(std-0.1) > 0.01 {
+		t.Errorf("noise has mean %.4f and std %.4f, want 0 and 0.1", mean, std)
+	}
+
+	// the same seed gives the same noise
+	a := faultyTestCase(t, 0, SensorFault{Point: zone, Kind: FaultNoise, Value: 1})
+	b := faultyTestCase(t, 0, SensorFault{Point: zone, Kind: FaultNoise, Value: 1})
+	if read(t, a, zone) != read(t, b, zone) {
+		t.Errorf("noise is not reproducible")
+	}
+}
+
+func TestSensorFaultsServer(t *testing.T) {
+	testCase := faultyTestCase(t, 4, SensorFault{Name: "lost", Point: "zon_reaTRooAir_y", Kind: FaultDropout})
+	s := NewServer("127.0.0.1:0", testCase)
+	req := &common.GetRequest{Keys: []string{"boptest:///zon_reaTRooAir_y", "boptest:///time"}}
+
+	resp, err := s.Get(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := resp.GetPairs()[0]; p.Error == nil {
+		t.Errorf("dropout read %q, want an error", p.GetValue())
+	}
+	if p := resp.GetPairs()[1]; p.Error != nil || p.GetValue() != "14400" {
+		t.Errorf("time read %v", p)
+	}
+
+	// toggled at runtime
+	if err := testCase.SensorFaults.Enable("lost", false); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = s.Get(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := resp.GetPairs()[0]; p.Error != nil || p.GetValue() != "294" {
+		t.Errorf("read %v after disabling the dropout", p)
+	}
+	if err := testCase.SensorFaults.Enable("found", true); err == nil {
+		t.Errorf("expected an error enabling an unknown fault")
+	}
+	if !testCase.SensorFaults.Remove("lost") || len(testCase.SensorFaults.Faults()) != 0 {
+		t.Errorf("fault not removed")
+	}
+
+	if _, err := NewSensorFaults(0, SensorFault{Point: "x", Kind: FaultDelay}); err == nil {
+		t.Errorf("expected an error for a delay fault without a delay")
+	}
+}
+
+func TestActuatorFaults(t *testing.T) {
+	for _, test := range []struct {
+		fault ActuatorFault
+		want  map[string]any
+	}{
+		{ActuatorFault{Input: "fan_u", Kind: FaultStuck, Value: 0.2}, map[string]any{"fan_u": 0.2, "fan_activate": 1, "tSup_u": "300"}},
+		{ActuatorFault{Input: "fan_u", Kind: FaultSaturate, Value: 0.6}, map[string]any{"fan_u": 0.6, "fan_activate": "1", "tSup_u": "300"}},
+		{ActuatorFault{Input: "fan_u", Kind: FaultIgnore}, map[string]any{"tSup_u": "300"}},
+		{ActuatorFault{Input: "fan_u", Kind: FaultInvert}, map[string]any{"fan_u": 0.25, "fan_activate": "1", "tSup_u": "300"}},
+		{ActuatorFault{Input: "tSup_u", Kind: FaultInvert, Value: 600}, map[string]any{"fan_u": "0.75", "fan_activate": "1", "tSup_u": 300.0}},
+		{ActuatorFault{Input: "fan_u", Kind: FaultIgnore, Start: 7200}, nil}, // not started
+		{ActuatorFault{Input: "fan_u", Kind: FaultIgnore, Disabled: true}, nil},
+	} {
+		a, err := NewActuatorFaults(test.fault)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs := map[string]any{"fan_u": "0.75", "fan_activate": "1", "tSup_u": "300"}
+		got, dropped := a.Apply(inputs, 3600)
+		if len(dropped) > 0 {
+			t.Fatal(dropped)
+		}
+		if test.want == nil {
+			test.want = inputs
+		}
+		if !maps.Equal(got, test.want) {
+			t.Errorf("%+v: applied %v, want %v", test.fault, got, test.want)
+		}
+		if inputs["fan_u"] != "0.75" {
+			t.Errorf("%+v: modified the commanded inputs", test.fault)
+		}
+	}
+
+	// a stuck actuator ignores the absence of a command
+	var none *ActuatorFaults
+	if got, _ := none.Apply(map[string]any{"fan_u": 1}, 0); got["fan_u"] != 1 {
+		t.Errorf("nil faults applied %v", got)
+	}
+	a, _ := NewActuatorFaults(ActuatorFault{Input: "fan_u", Kind: FaultStuck, Value: 0})
+	if got, _ := a.Apply(nil, 0); got["fan_u"] != 0.0 || got["fan_activate"] != 1 {
+		t.Errorf("stuck without a command applied %v", got)
+	}
+	if _, dropped := a.Apply(map[string]any{"fan_u": "on"}, 0); len(dropped) > 0 {
+		t.Errorf("stuck needs no number: %v", dropped)
+	}
+	a, _ = NewActuatorFaults(ActuatorFault{Input: "fan_u", Kind: FaultSaturate, Value: 1})
+	got, dropped := a.Apply(map[string]any{"fan_u": "on", "fan_activate": 1}, 0)
+	if _, ok := got["fan_u"]; ok || dropped["fan_u"] == nil || got["fan_activate"] != 1 {
+		t.Errorf("saturating a non numeric command applied %v, dropped %v", got, dropped)
+	}
+	if _, err := NewActuatorFaults(ActuatorFault{Input: "fan_u", Kind: FaultNoise}); err == nil {
+		t.Errorf("expected an error for an actuator fault of a sensor kind")
+	}
+}
+
+func TestActuatorFaultDelay(t *testing.T) {
+	a, err := NewActuatorFaults(ActuatorFault{Name: "slow", Input: "fan_u", Kind: FaultDelay, Steps: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []any{nil, nil, 0.1, 0.2, nil} {
+		inputs := map[string]any{"fan_u": 0.1 * float64(i+1), "fan_activate": 1}
+		if i == 2 {
+			delete(inputs, "fan_u") // nothing commanded at step 2 is sent at step 4
+			delete(inputs, "fan_activate")
+		}
+		got, dropped := a.Apply(inputs, float64(i*3600))
+		if len(dropped) > 0 {
+			t.Fatal(dropped)
+		}
+		if got["fan_u"] != want {
+			t.Errorf("step %d applied %v, want fan_u %v", i, got, want)
+		}
+		if _, ok := got["fan_activate"]; ok != (want != nil) {
+			t.Errorf("step %d applied %v, activation not delayed with its value", i, got)
+		}
+	}
+
+	// disabling the fault discards the commands in flight
+	if err := a.Enable("slow", false); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := a.Apply(map[string]any{"fan_u": 1.0}, 0); got["fan_u"] != 1.0 {
+		t.Errorf("applied %v after disabling the delay", got)
+	}
+	if !a.Remove("slow") || len(a.Faults()) != 0 {
+		t.Errorf("fault not removed")
+	}
+}
+
+func TestActuatorFaultsTestCase(t *testing.T) {
+	b := &recordingBackend{PlantBackend: NewPlantBackend(func(testcase string) (Plant, error) {
+		return NewRCPlant(DefaultRCConfig(HVACFor(testcase)))
+	})}
+	faults, err := NewActuatorFaults(ActuatorFault{Input: "oveTSet_u", Kind: FaultSaturate, Value: 293.15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCase, err := NewTestCase("bestest_hydronic_heat_pump", WithBackend(b), WithActuatorFaults(faults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	if err := testCase.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCase.SetInput("oveTSet_activate", "1")
+	testCase.SetInput("oveTSet_u", "297.15")
+	if err := testCase.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.advances) != 1 || b.advances[0]["oveTSet_u"] != 293.15 {
+		t.Errorf("advances are %v", b.advances)
+	}
+	if v := testCase.State.Get("reaTSetHea_y"); v != 293.15 {
+		t.Errorf("setpoint measured %v, want the saturated 293.15", v)
+	}
+
+	// both commands are recorded with the state they led to
+	all := testCase.History.All()
+	last := all[len(all)-1]
+	if last.Commanded["oveTSet_u"] != "297.15" || last.Applied["oveTSet_u"] != 293.15 {
+		t.Errorf("recorded commanded %v and applied %v", last.Commanded, last.Applied)
+	}
+	if all[0].Commanded != nil {
+		t.Errorf("initial state recorded commands %v", all[0].Commanded)
+	}
+}
+
+func TestActuatorFaultBadWrite(t *testing.T) {
+	faults, err := NewActuatorFaults(ActuatorFault{Input: "oveTSet_u", Kind: FaultSaturate, Value: 293.15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCase, err := NewTestCase("bestest_hydronic_heat_pump", WithPlant(newRCPlant(t, HVACHeatPump)),
+		WithActuatorFaults(faults), WithWriteMode(WriteLatch), WithStep(900), WithUpdateFrequency(1), WithStartNow())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	if err := testCase.Start(); err != nil {
+		t.Fatal(err)
+	}
+	start, _ := testCase.State.Get("time").(float64)
+
+	// a value that gets past the checks on write stays latched
+	if err := testCase.SetInput("oveTSet_activate", 1); err != nil {
+		t.Fatal(err)
+	}
+	testCase.writeBuffer.Set("oveTSet_u", "warm")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for now, _ := testCase.State.Get("time").(float64); now < start+2*900; now, _ = testCase.State.Get("time").(float64) {
+		if time.Now().After(deadline) {
+			t.Fatalf("simulation stopped at %v after a bad write, started at %v", now, start)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	all := testCase.History.All()
+	if applied := all[len(all)-1].Applied; applied["oveTSet_activate"] != 1 || applied["oveTSet_u"] != nil {
+		t.Errorf("applied %v, want the bad value dropped", applied)
+	}
+}
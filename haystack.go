@@ -0,0 +1,634 @@
+package boptest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HaystackVersion     = "4.0"
+	ContentType_Hayson  = "application/vnd.haystack+json"
+	haystackTagURI      = "boptestUri"
+	haystackProductName = "grpc-boptest"
+)
+
+// Haystack values encoded as Hayson (Haystack JSON)
+type (
+	Marker struct{}
+	Ref    string
+	Number struct {
+		Val  float64
+		Unit string
+	}
+	DateTime time.Time
+)
+
+func (Marker) MarshalJSON() ([]byte, error) {
+	return []byte(`{"_kind":"marker"}`), nil
+}
+
+func (r Ref) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"_kind": "ref", "val": string(r)})
+}
+
+func (n Number) MarshalJSON() ([]byte, error) {
+	m := map[string]any{"_kind": "number", "val": n.Val}
+	if n.Unit != "" {
+		m["unit"] = n.Unit
+	}
+	return json.Marshal(m)
+}
+
+func (d DateTime) MarshalJSON() ([]byte, error) {
+	t := time.Time(d)
+	return json.Marshal(map[string]string{
+		"_kind": "dateTime",
+		"val":   t.Format(time.RFC3339),
+		"tz":    haystackTZ(t.Location()),
+	})
+}
+
+// haystack time zones are the city portion of the IANA name
+func haystackTZ(loc *time.Location) string {
+	name := loc.String()
+	if name == "Local" {
+		name = localZoneName()
+	}
+	if name == "" {
+		return "UTC"
+	}
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// returns the IANA name of the local time zone, from TZ as the time package
+// reads it or else the zoneinfo file /etc/localtime links to
+func localZoneName() string {
+	tz, ok := os.LookupEnv("TZ")
+	if !ok {
+		tz, _ = os.Readlink("/etc/localtime")
+	}
+	tz = strings.TrimPrefix(tz, ":")
+	if i := strings.LastIndex(tz, "zoneinfo/"); i >= 0 {
+		tz = tz[i+len("zoneinfo/"):]
+	}
+	if tz != "" {
+		if _, err := time.LoadLocation(tz); err == nil {
+			return tz
+		}
+	}
+	return etcZoneName(time.Local)
+}
+
+// returns the Etc zone of the offset of loc, e.g. Etc/GMT-1 for UTC+1, or
+// UTC if it has none, i.e. for UTC or an offset of a fraction of an hour
+func etcZoneName(loc *time.Location) string {
+	_, offset := time.Now().In(loc).Zone()
+	if offset == 0 || offset%3600 != 0 {
+		return "UTC"
+	}
+	return fmt.Sprintf("Etc/GMT%+d", -offset/3600)
+}
+
+// BOPTEST units to Haystack units, units not listed are used as is
+var haystackUnits = map[string]string{
+	"1":    "",
+	"degC": "°C",
+	"m3/s": "m³/s",
+	"W/m2": "W/m²",
+}
+
+func haystackUnit(unit string) string {
+	if u, ok := haystackUnits[unit]; ok {
+		return u
+	}
+	return unit
+}
+
+// BOPTEST component prefixes to Haystack equipment markers
+var haystackComponents = map[string]string{
+	"zon":    "zone",
+	"fcu":    "fcu",
+	"ahu":    "ahu",
+	"hp":     "heatPump",
+	"heaPum": "heatPump",
+	"boi":    "boiler",
+	"chi":    "chiller",
+	"pum":    "pump",
+	"vav":    "vav",
+	"weaSta": "weatherStation",
+}
+
+// HaystackID is the id of a point in Haystack, e.g. "bestest_air.zon_reaTRooAir_y"
+func HaystackID(testCase, name string) Ref {
+	return Ref(testCase + "." + name)
+}
+
+// HaystackTags infers the Haystack tags of a BOPTEST point from its name and
+// properties. Inputs, i.e. _u and _activate points, are tagged writable.
+func HaystackTags(testCase, name string, p PointProperties) map[string]any {
+	n := ParsePointName(name)
+	tags := map[string]any{
+		"id":           HaystackID(testCase, name),
+		"point":        Marker{},
+		"his":          Marker{},
+		"navName":      name,
+		"dis":          name,
+		haystackTagURI: PointURI(testCase, name),
+		"kind":         "Number",
+	}
+	if p.Description != "" {
+		tags["dis"] = p.Description
+	}
+	if u := haystackUnit(p.Unit); u != "" {
+		tags["unit"] = u
+	}
+	if m, ok := haystackComponents[n.Component]; ok {
+		tags[m] = Marker{}
+	}
+
+	// what kind of point it is
+	switch {
+	case n.IsActivate():
+		tags["cmd"] = Marker{}
+		tags["enable"] = Marker{}
+		tags["kind"] = "Bool"
+	case n.IsSetpoint() || n.IsOverride() && n.Quantity(p.Unit) == QuantityTemperature:
+		tags["sp"] = Marker{}
+	case n.IsOverride():
+		tags["cmd"] = Marker{}
+	default:
+		tags["sensor"] = Marker{}
+	}
+	if !n.IsMeasurement() {
+		tags["writable"] = Marker{}
+		if p.Maximum > p.Minimum {
+			tags["minVal"] = Number{p.Minimum, haystackUnit(p.Unit)}
+			tags["maxVal"] = Number{p.Maximum, haystackUnit(p.Unit)}
+		}
+	}
+
+	// what it measures
+	fluid := false
+	switch n.Quantity(p.Unit) {
+	case QuantityTemperature:
+		tags["temp"] = Marker{}
+		fluid = true
+	case QuantityPower:
+		tags["power"] = Marker{}
+		tags["elec"] = Marker{}
+	case QuantityThermalPower:
+		tags["power"] = Marker{}
+		tags["thermal"] = Marker{}
+	case QuantityFlow:
+		tags["flow"] = Marker{}
+		fluid = true
+	case QuantityCO2:
+		tags["co2"] = Marker{}
+		fluid = true
+	case QuantityHumidity:
+		tags["humidity"] = Marker{}
+		fluid = true
+	case QuantityPressure:
+		tags["pressure"] = Marker{}
+	case QuantityIrradiance:
+		tags["irradiance"] = Marker{}
+	case QuantitySpeed:
+		tags["speed"] = Marker{}
+	case QuantityDirection:
+		tags["direction"] = Marker{}
+	}
+	if fluid {
+		if strings.Contains(strings.ToLower(p.Description), "water") {
+			tags["water"] = Marker{}
+		} else {
+			tags["air"] = Marker{}
+		}
+	}
+
+	// where it measures
+	switch {
+	case n.IsOutside():
+		tags["outside"] = Marker{}
+	case n.has("Sup"):
+		tags["discharge"] = Marker{}
+	case n.has("Ret"):
+		tags["return"] = Marker{}
+	case n.IsZone():
+		tags["zone"] = Marker{}
+	}
+	if n.has("Hea") {
+		tags["heating"] = Marker{}
+	}
+	if n.has("Coo") {
+		tags["cooling"] = Marker{}
+	}
+	return tags
+}
+
+// a Haystack grid encoded as Hayson
+type haystackGrid struct {
+	Kind string           `json:"_kind"`
+	Meta map[string]any   `json:"meta"`
+	Cols []map[string]any `json:"cols"`
+	Rows []map[string]any `json:"rows"`
+}
+
+func newHaystackGrid(meta map[string]any, rows ...map[string]any) haystackGrid {
+	g := haystackGrid{
+		Kind: "grid",
+		Meta: map[string]any{"ver": "3.0"},
+		Cols: []map[string]any{},
+		Rows: rows,
+	}
+	maps.Copy(g.Meta, meta)
+	if g.Rows == nil {
+		g.Rows = []map[string]any{}
+	}
+
+	// columns are the union of the tags of every row
+	cols := make(map[string]bool)
+	for _, r := range rows {
+		for k := range r {
+			cols[k] = true
+		}
+	}
+	names := slices.Sorted(maps.Keys(cols))
+	// id first by convention
+	if cols["id"] {
+		names = slices.DeleteFunc(names, func(s string) bool { return s == "id" })
+		names = append([]string{"id"}, names...)
+	}
+	for _, name := range names {
+		g.Cols = append(g.Cols, map[string]any{"name": name})
+	}
+	return g
+}
+
+func haystackErrorGrid(err error) haystackGrid {
+	return newHaystackGrid(map[string]any{"err": Marker{}, "dis": err.Error()})
+}
+
+// HaystackServer is a read only Haystack over HTTP server exposing the about,
+// read and hisRead ops for the points of a test case, encoded as Hayson. read
+// takes ids or a filter of tag, not tag and tag==value terms joined by and;
+// hisRead is answered from the History of the test case.
+type HaystackServer struct {
+	Addr     string
+	TestCase *TestCase
+	Logger   *slog.Logger // defaults to the default logger
+
+	points map[Ref]map[string]any // tags of every point keyed by id
+	booted time.Time
+	server *http.Server
+}
+
+// NewHaystackServer queries the points of testCase and returns a server that
+// listens on listenAddr once started. The ids and uris of the points are
+// under the name testCase is registered with on a Server, if it is.
+func NewHaystackServer(listenAddr string, testCase *TestCase) (*HaystackServer, error) {
+	measurements, err := testCase.Measurements()
+	if err != nil {
+		return nil, err
+	}
+	inputs, err := testCase.Inputs()
+	if err != nil {
+		return nil, err
+	}
+	return newHaystackServer(listenAddr, testCase, measurements, inputs), nil
+}
+
+func newHaystackServer(listenAddr string, testCase *TestCase, measurements, inputs map[string]PointProperties) *HaystackServer {
+	h := &HaystackServer{
+		Addr:     listenAddr,
+		TestCase: testCase,
+		points:   make(map[Ref]map[string]any, len(measurements)+len(inputs)),
+		booted:   time.Now(),
+	}
+	for _, m := range []map[string]PointProperties{measurements, inputs} {
+		for name, p := range m {
+			tags := HaystackTags(testCase.registeredName(), name, p)
+			h.points[tags["id"].(Ref)] = tags
+		}
+	}
+	return h
+}
+
+func (h *HaystackServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/about", h.about)
+	mux.HandleFunc("/read", h.read)
+	mux.HandleFunc("/hisRead", h.hisRead)
+	return mux
+}
+
+// Start listens on Addr and serves requests on a go routine.
+func (h *HaystackServer) Start() error {
+	lis, err := net.Listen("tcp", h.Addr)
+	if err != nil {
+		return err
+	}
+	h.server = &http.Server{Handler: h.Handler()}
+
+	go func() {
+		if err := h.server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			orDefault(h.Logger).Error("haystack server failed", "error", err)
+		}
+	}()
+
+	orDefault(h.Logger).Info("haystack server started", "listen_addr", h.Addr)
+	return nil
+}
+
+func (h *HaystackServer) Shutdown(ctx context.Context) error {
+	if h.server == nil {
+		return nil
+	}
+	return h.server.Shutdown(ctx)
+}
+
+func (h *HaystackServer) writeGrid(w http.ResponseWriter, g haystackGrid) {
+	w.Header().Set("Content-Type", ContentType_Hayson)
+	err := json.NewEncoder(w).Encode(g)
+	if err != nil {
+		orDefault(h.Logger).Error("unable to write haystack grid", "error", err)
+	}
+}
+
+// returns the parameters of a request from its query string or, for POST, the
+// first row of a Hayson request grid
+func haystackParams(r *http.Request) (map[string]string, []string, error) {
+	params := make(map[string]string)
+	var ids []string
+	for k, v := range r.URL.Query() {
+		if k == "id" {
+			ids = append(ids, v...)
+			continue
+		}
+		params[k] = v[0]
+	}
+	if r.Method != http.MethodPost {
+		return params, ids, nil
+	}
+
+	var grid struct {
+		Rows []map[string]json.RawMessage `json:"rows"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&grid); err != nil {
+		return nil, nil, fmt.Errorf("invalid request grid: %w", err)
+	}
+	for i, row := range grid.Rows {
+		for k, raw := range row {
+			// values are either plain json strings or hayson objects with a val
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				var v struct {
+					Val any `json:"val"`
+				}
+				if err := json.Unmarshal(raw, &v); err != nil {
+					return nil, nil, fmt.Errorf("invalid value for %s: %w", k, err)
+				}
+				s = fmt.Sprint(v.Val)
+			}
+			switch {
+			case k == "id":
+				ids = append(ids, s)
+			case i == 0:
+				params[k] = s
+			}
+		}
+	}
+	return params, ids, nil
+}
+
+func (h *HaystackServer) about(w http.ResponseWriter, r *http.Request) {
+	host, _ := os.Hostname()
+	h.writeGrid(w, newHaystackGrid(nil, map[string]any{
+		"haystackVersion": HaystackVersion,
+		"serverName":      host,
+		"serverTime":      DateTime(time.Now()),
+		"serverBootTime":  DateTime(h.booted),
+		"productName":     haystackProductName,
+		"tz":              haystackTZ(time.Local),
+	}))
+}
+
+// row of a point with its current value
+func (h *HaystackServer) curRow(tags map[string]any) map[string]any {
+	row := maps.Clone(tags)
+	name := tags["navName"].(string)
+	unit, _ := tags["unit"].(string)
+	if v, ok := h.TestCase.State.Get(name).(float64); ok {
+		row["curVal"] = Number{v, unit}
+		row["curStatus"] = "ok"
+	}
+	return row
+}
+
+func (h *HaystackServer) read(w http.ResponseWriter, r *http.Request) {
+	params, ids, err := haystackParams(r)
+	if err != nil {
+		h.writeGrid(w, haystackErrorGrid(err))
+		return
+	}
+
+	var rows []map[string]any
+	if len(ids) > 0 {
+		for _, id := range ids {
+			tags, ok := h.points[Ref(strings.TrimPrefix(id, "@"))]
+			if !ok {
+				// unknown ids are returned as empty rows
+				rows = append(rows, map[string]any{})
+				continue
+			}
+			rows = append(rows, h.curRow(tags))
+		}
+		h.writeGrid(w, newHaystackGrid(nil, rows...))
+		return
+	}
+
+	filter, err := parseHaystackFilter(params["filter"])
+	if err != nil {
+		h.writeGrid(w, haystackErrorGrid(err))
+		return
+	}
+	limit := -1
+	if l, ok := params["limit"]; ok {
+		if limit, err = strconv.Atoi(l); err != nil {
+			h.writeGrid(w, haystackErrorGrid(fmt.Errorf("invalid limit %q", l)))
+			return
+		}
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(h.points)) {
+		if limit >= 0 && len(rows) >= limit {
+			break
+		}
+		tags := h.points[id]
+		if filter.matches(tags) {
+			rows = append(rows, h.curRow(tags))
+		}
+	}
+	h.writeGrid(w, newHaystackGrid(nil, rows...))
+}
+
+func (h *HaystackServer) hisRead(w http.ResponseWriter, r *http.Request) {
+	params, ids, err := haystackParams(r)
+	if err != nil {
+		h.writeGrid(w, haystackErrorGrid(err))
+		return
+	}
+	if len(ids) != 1 {
+		h.writeGrid(w, haystackErrorGrid(fmt.Errorf("hisRead requires exactly one id")))
+		return
+	}
+	id := Ref(strings.TrimPrefix(ids[0], "@"))
+	tags, ok := h.points[id]
+	if !ok {
+		h.writeGrid(w, haystackErrorGrid(fmt.Errorf("unknown id @%s", id)))
+		return
+	}
+
+	now, err := h.TestCase.State.Time()
+	if err != nil {
+		h.writeGrid(w, haystackErrorGrid(err))
+		return
+	}
+	start, end, err := parseHaystackRange(params["range"], now)
+	if err != nil {
+		h.writeGrid(w, haystackErrorGrid(err))
+		return
+	}
+
+	name := tags["navName"].(string)
+	unit, _ := tags["unit"].(string)
+	var rows []map[string]any
+	for _, s := range h.TestCase.History.Range(SimSeconds(start), SimSeconds(end)) {
+		v, ok := s.State[name].(float64)
+		if !ok {
+			continue
+		}
+		rows = append(rows, map[string]any{
+			"ts":  DateTime(SimTime(s.Time)),
+			"val": Number{v, unit},
+		})
+	}
+
+	h.writeGrid(w, newHaystackGrid(map[string]any{
+		"id":       id,
+		"hisStart": DateTime(start),
+		"hisEnd":   DateTime(end),
+	}, rows...))
+}
+
+// parseHaystackRange parses the range of a hisRead: "today", "yesterday", a
+// date, or two comma separated dates or RFC 3339 date times. Dates are relative
+// to the simulation time now.
+func parseHaystackRange(s string, now time.Time) (time.Time, time.Time, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	parse := func(s string) (time.Time, bool, error) {
+		if t, err := time.ParseInLocation(time.DateOnly, s, now.Location()); err == nil {
+			return t, true, nil
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid range %q", s)
+		}
+		return t, false, nil
+	}
+
+	switch s = strings.TrimSpace(s); s {
+	case "", "today":
+		return midnight, midnight.AddDate(0, 0, 1), nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), midnight, nil
+	}
+
+	first, second, found := strings.Cut(s, ",")
+	start, isDate, err := parse(strings.TrimSpace(first))
+	if err != nil {
+		return start, start, err
+	}
+	if !found {
+		if isDate {
+			return start, start.AddDate(0, 0, 1), nil
+		}
+		return start, now, nil
+	}
+	end, isDate, err := parse(strings.TrimSpace(second))
+	if err != nil {
+		return start, end, err
+	}
+	if isDate {
+		// dates are inclusive
+		end = end.AddDate(0, 0, 1)
+	}
+	return start, end, nil
+}
+
+// a term of a filter, e.g. `temp`, `not writable` or `unit=="K"`
+type haystackTerm struct {
+	tag    string
+	negate bool
+	value  *string
+}
+
+// haystackFilter is the subset of the Haystack filter language made of tags,
+// "not tag" and "tag==value" terms joined by "and".
+type haystackFilter []haystackTerm
+
+func parseHaystackFilter(s string) (haystackFilter, error) {
+	var f haystackFilter
+	if strings.TrimSpace(s) == "" {
+		return f, nil
+	}
+	for _, term := range strings.Split(s, " and ") {
+		term = strings.TrimSpace(term)
+		var t haystackTerm
+		if rest, ok := strings.CutPrefix(term, "not "); ok {
+			t.negate = true
+			term = strings.TrimSpace(rest)
+		}
+		if tag, value, ok := strings.Cut(term, "=="); ok {
+			v := strings.Trim(strings.TrimSpace(value), `"`)
+			t.value = &v
+			term = strings.TrimSpace(tag)
+		}
+		if term == "" || strings.ContainsAny(term, " ()<>!=") {
+			return nil, fmt.Errorf("unsupported filter %q", s)
+		}
+		t.tag = term
+		f = append(f, t)
+	}
+	return f, nil
+}
+
+func (f haystackFilter) matches(tags map[string]any) bool {
+	for _, t := range f {
+		v, ok := tags[t.tag]
+		if ok && t.value != nil {
+			switch v := v.(type) {
+			case Ref:
+				ok = "@"+string(v) == *t.value || string(v) == *t.value
+			case Number:
+				ok = strconv.FormatFloat(v.Val, 'f', -1, 64) == *t.value
+			default:
+				ok = fmt.Sprint(v) == *t.value
+			}
+		}
+		if ok == t.negate {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,213 @@
+package boptest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesryancoleman/bos/common"
+)
+
+func TestHaystackTags(t *testing.T) {
+	var cases = []struct {
+		name    string
+		props   PointProperties
+		markers []string
+	}{
+		{"zon_reaTRooAir_y", PointProperties{Unit: "K", Description: "Zone air temperature"},
+			[]string{"point", "sensor", "temp", "air", "zone"}},
+		{"con_oveTSetHea_u", PointProperties{Unit: "K", Minimum: 278.15, Maximum: 308.15},
+			[]string{"point", "sp", "temp", "heating", "writable"}},
+		{"fcu_oveFan_activate", PointProperties{},
+			[]string{"point", "cmd", "enable", "fcu", "writable"}},
+		{"oveHeaPumY_u", PointProperties{Unit: "1", Maximum: 1},
+			[]string{"point", "cmd", "writable"}},
+		{"reaPHeaPum_y", PointProperties{Unit: "W"},
+			[]string{"point", "sensor", "power", "elec"}},
+		{"ahu_reaFloSupAir_y", PointProperties{Unit: "kg/s"},
+			[]string{"point", "sensor", "flow", "air", "discharge", "ahu"}},
+	}
+	for _, c := range cases {
+		tags := HaystackTags("bestest_air", c.name, c.props)
+		for _, m := range c.markers {
+			if _, ok := tags[m].(Marker); !ok {
+				t.Errorf("%s: missing marker %s in %v", c.name, m, tags)
+			}
+		}
+		if tags["id"] != HaystackID("bestest_air", c.name) {
+			t.Errorf("%s: got id %v", c.name, tags["id"])
+		}
+		if tags[haystackTagURI] != PointURI("bestest_air", c.name) {
+			t.Errorf("%s: got uri %v", c.name, tags[haystackTagURI])
+		}
+	}
+}
+
+func TestHaystackFilter(t *testing.T) {
+	tags := HaystackTags("bestest_air", "zon_reaTRooAir_y", PointProperties{Unit: "K"})
+	var cases = map[string]bool{
+		"":                                  true,
+		"point and temp":                    true,
+		"point and not writable":            true,
+		"sp":                                false,
+		`unit=="K" and sensor`:              true,
+		"id==@bestest_air.zon_reaTRooAir_y": true,
+		"id==@bestest_air.fcu_oveFan_u":     false,
+	}
+	for s, want := range cases {
+		f, err := parseHaystackFilter(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.matches(tags); got != want {
+			t.Errorf("%q: got %v, want %v", s, got, want)
+		}
+	}
+
+	if _, err := parseHaystackFilter("temp or sp"); err == nil {
+		t.Errorf("expected an error for an unsupported filter")
+	}
+}
+
+func TestHaystackRange(t *testing.T) {
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.Local)
+	var cases = map[string][2]time.Time{
+		"today":     {time.Date(2025, 2, 1, 0, 0, 0, 0, time.Local), time.Date(2025, 2, 2, 0, 0, 0, 0, time.Local)},
+		"yesterday": {time.Date(2025, 1, 31, 0, 0, 0, 0, time.Local), time.Date(2025, 2, 1, 0, 0, 0, 0, time.Local)},
+		"2025-01-15,2025-01-16": {
+			time.Date(2025, 1, 15, 0, 0, 0, 0, time.Local), time.Date(2025, 1, 17, 0, 0, 0, 0, time.Local)},
+	}
+	for s, want := range cases {
+		start, end, err := parseHaystackRange(s, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !start.Equal(want[0]) || !end.Equal(want[1]) {
+			t.Errorf("%q: got %v to %v", s, start, end)
+		}
+	}
+	if _, _, err := parseHaystackRange("last week", now); err == nil {
+		t.Errorf("expected an error for an invalid range")
+	}
+}
+
+func TestHaystackServer(t *testing.T) {
+	testCase := &TestCase{Name: "bestest_hydronic_heat_pump", History: NewHistory(10)}
+	for i := range 4 {
+		state := map[string]any{"time": float64(i * 3600), "reaTZon_y": 293.15 + float64(i)}
+		testCase.State.SetAll(state)
+		testCase.History.Add(state)
+	}
+
+	h := newHaystackServer("", testCase,
+		loadPoints(t, "msgs/measurements.json"),
+		loadPoints(t, "msgs/inputs.json"))
+	ts := httptest.NewServer(h.Handler())
+	defer ts.Close()
+
+	get := func(path string) haystackGrid {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var g haystackGrid
+		if err := json.NewDecoder(resp.Body).Decode(&g); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := g.Meta["err"]; ok {
+			t.Fatalf("%s: %v", path, g.Meta["dis"])
+		}
+		return g
+	}
+
+	g := get("/about")
+	if len(g.Rows) != 1 || g.Rows[0]["productName"] != haystackProductName {
+		t.Errorf("unexpected about grid %+v", g)
+	}
+
+	g = get("/read?filter=" + strings.ReplaceAll("zone and temp and sensor", " ", "%20"))
+	if len(g.Rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(g.Rows))
+	}
+	if g.Rows[0]["navName"] != "reaTZon_y" || g.Rows[0]["curVal"] == nil {
+		t.Errorf("unexpected row %+v", g.Rows[0])
+	}
+
+	g = get("/read?id=@bestest_hydronic_heat_pump.oveTSet_u")
+	if len(g.Rows) != 1 || g.Rows[0]["writable"] == nil {
+		t.Errorf("unexpected read by id %+v", g.Rows)
+	}
+
+	start := SimTime(0).Format(time.DateOnly)
+	g = get(fmt.Sprintf("/hisRead?id=@bestest_hydronic_heat_pump.reaTZon_y&range=%s", start))
+	if len(g.Rows) != 4 {
+		t.Errorf("got %d history rows, want 4", len(g.Rows))
+	}
+}
+
+// the uri of a point is a key of the server the test case is registered with,
+// under its name there rather than the boptest one
+func TestHaystackServerRegistered(t *testing.T) {
+	testCase := offlineTestCase("bestest_hydronic_heat_pump", map[string]any{"time": 0.0, "reaTZon_y": 293.15})
+	s := NewServer("0.0.0.0:50070", nil)
+	if err := s.AddTestCase("heat_pump", testCase); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newHaystackServer("", testCase,
+		loadPoints(t, "msgs/measurements.json"),
+		loadPoints(t, "msgs/inputs.json"))
+	tags, ok := h.points[HaystackID("heat_pump", "reaTZon_y")]
+	if !ok {
+		t.Fatalf("no point with id %s", HaystackID("heat_pump", "reaTZon_y"))
+	}
+	uri, _ := tags[haystackTagURI].(string)
+	if uri != PointURI("heat_pump", "reaTZon_y") {
+		t.Errorf("got uri %q", uri)
+	}
+
+	r, err := s.Get(context.Background(), &common.GetRequest{
+		Header: &common.Header{Src: "test.local", Dst: s.Addr},
+		Keys:   []string{uri},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := r.GetPairs()[0]; p.GetValue() != "293.15" || p.ErrorMsg != nil {
+		t.Errorf("got %v for %s", p, uri)
+	}
+}
+
+func TestHaystackTZ(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	if tz := haystackTZ(newYork); tz != "New_York" {
+		t.Errorf("America/New_York is %s", tz)
+	}
+	if tz := haystackTZ(time.UTC); tz != "UTC" {
+		t.Errorf("UTC is %s", tz)
+	}
+
+	// the local zone is named by TZ, not UTC
+	for _, tz := range []string{"Europe/Berlin", ":Europe/Berlin", "/usr/share/zoneinfo/Europe/Berlin"} {
+		t.Setenv("TZ", tz)
+		if name := haystackTZ(time.Local); name != "Berlin" {
+			t.Errorf("TZ=%s is %s", tz, name)
+		}
+	}
+
+	// and by its offset without an IANA name
+	for offset, want := range map[int]string{0: "UTC", 3600: "Etc/GMT-1", -5 * 3600: "Etc/GMT+5", 19800: "UTC"} {
+		if name := etcZoneName(time.FixedZone("", offset)); name != want {
+			t.Errorf("offset %d is %s", offset, name)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package boptest
+
+import (
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/jamesryancoleman/bos/common"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	DefaultHealthInterval = 5 * time.Second // between health checks of the test cases
+
+	minStaleness = 10 * time.Second // before a test case that does not advance is unhealthy
+)
+
+// Health returns nil if the test case is live: its run loop has not exited,
+// its simulation is reachable and running, and, if it advances on its own,
+// its simulated time has progressed within the last three updates.
+func (c *TestCase) Health() error {
+	select {
+	case <-c.done:
+		return fmt.Errorf("run loop of %q has exited", c.ID)
+	default:
+	}
+
+	ok, err := c.backend.Status(c.ID)
+	if err != nil {
+		return fmt.Errorf("simulation of %q unreachable: %w", c.ID, err)
+	}
+	if !ok {
+		return fmt.Errorf("simulation of %q not running", c.ID)
+	}
+
+	if c.startNow {
+		stale := max(3*time.Duration(c.updateFreq)*time.Second, minStaleness)
+		last := time.Unix(0, c.progressed.Load())
+		if since := time.Since(last); since > stale {
+			return fmt.Errorf("simulated time of %q has not progressed for %v", c.ID, since.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// check the health of every test case, reported under the name it is
+// registered with, every interval
+func WithHealthInterval(d time.Duration) serverOption {
+	return func(s *Server) {
+		s.healthInterval = d
+	}
+}
+
+// register the server reflection service, e.g. for grpcurl
+func WithReflection() serverOption {
+	return func(s *Server) {
+		s.reflection = true
+	}
+}
+
+// checkHealth sets the status of every test case, by its name, and of the
+// DeviceControl service and the server as a whole, which are serving only if
+// every test case is.
+func (s *Server) checkHealth(hs *health.Server) {
+	s.RLock()
+	testCases := maps.Clone(s.testCases)
+	s.RUnlock()
+
+	overall := healthpb.HealthCheckResponse_SERVING
+	for name, testCase := range testCases {
+		status := healthpb.HealthCheckResponse_SERVING
+		if err := testCase.Health(); err != nil {
+			s.logger().Warn("test case unhealthy", "name", name, "error", err)
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+			overall = status
+		}
+		hs.SetServingStatus(name, status)
+	}
+	hs.SetServingStatus("", overall)
+	hs.SetServingStatus(common.DeviceControl_ServiceDesc.ServiceName, overall)
+}
+
+// checks health every interval until stop is closed
+func (s *Server) watchHealth(hs *health.Server, interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.checkHealth(hs)
+		case <-stop:
+			return
+		}
+	}
+}
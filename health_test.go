@@ -0,0 +1,113 @@
+package boptest
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesryancoleman/bos/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+)
+
+func TestHealth(t *testing.T) {
+	b := NewPlantBackend(func(testcase string) (Plant, error) {
+		return NewRCPlant(DefaultRCConfig(HVACFor(testcase)))
+	})
+	s := NewServer("127.0.0.1:0", nil, WithHealthInterval(20*time.Millisecond), WithReflection())
+	testCase, err := s.CreateTestCase("hp", "bestest_hydronic_heat_pump", WithBackend(b), WithStartNow())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	defer s.Shutdown(ctx)
+
+	conn, err := grpc.NewClient(s.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	health := healthpb.NewHealthClient(conn)
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		t.Helper()
+		resp, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.GetStatus()
+	}
+
+	for _, service := range []string{"", "hp", common.DeviceControl_ServiceDesc.ServiceName} {
+		if status := check(service); status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("%q is %v, want serving", service, status)
+		}
+	}
+
+	// the simulation stops behind the driver's back
+	if err := b.Stop(testCase.ID); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for check("") == healthpb.HealthCheckResponse_SERVING && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, service := range []string{"", "hp"} {
+		if status := check(service); status != healthpb.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("%q is %v after stopping the simulation, want not serving", service, status)
+		}
+	}
+
+	// reflection lists the services
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var services []string
+	for _, svc := range resp.GetListServicesResponse().GetService() {
+		services = append(services, svc.GetName())
+	}
+	for _, want := range []string{common.DeviceControl_ServiceDesc.ServiceName, healthpb.Health_ServiceDesc.ServiceName} {
+		if !slices.Contains(services, want) {
+			t.Errorf("reflection lists %v, missing %s", services, want)
+		}
+	}
+}
+
+func TestTestCaseHealthStale(t *testing.T) {
+	testCase, err := NewTestCase("bestest_air", WithPlant(newRCPlant(t, HVACFanCoil)),
+		WithStartNow(), WithUpdateFrequency(3600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	if err := testCase.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := testCase.Health(); err != nil {
+		t.Fatalf("just started: %v", err)
+	}
+
+	// the ticker has not fired within three updates
+	testCase.updateFreq = 1
+	testCase.progressed.Store(time.Now().Add(-time.Minute).UnixNano())
+	if err := testCase.Health(); err == nil || !strings.Contains(err.Error(), "not progressed") {
+		t.Errorf("got %v, want an error as time has not progressed", err)
+	}
+}
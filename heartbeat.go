@@ -0,0 +1,171 @@
+package boptest
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// HeartbeatMetadata is the metadata key a client declares its heartbeat
+// interval with on a Set, as a duration such as "30s". Once declared, every
+// Set from the client is a heartbeat, one without pairs being just that. If
+// none arrives within the interval the inputs the client wrote last are
+// released to the baseline control. "0" stops watching the client.
+const HeartbeatMetadata = "boptest-heartbeat"
+
+// the Set heartbeats of the clients that declared an interval
+type heartbeats struct {
+	clients map[string]*heartbeat
+	sync.Mutex
+}
+
+type heartbeat struct {
+	interval time.Duration
+	last     time.Time
+	timer    *time.Timer
+	owned    map[*TestCase]map[string]bool // inputs the client wrote last
+}
+
+// the client a caller writes as, told apart by its identity and source
+func (c Caller) client() string {
+	if c.Src == "" {
+		return c.String()
+	}
+	return c.String() + "@" + c.Src
+}
+
+// returns the heartbeat interval declared in the metadata of ctx, if any
+func heartbeatInterval(ctx context.Context) (time.Duration, bool, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(HeartbeatMetadata)
+	if len(values) == 0 {
+		return 0, false, nil
+	}
+	d, err := time.ParseDuration(values[len(values)-1])
+	if err != nil || d < 0 {
+		return 0, false, status.Errorf(codes.InvalidArgument, "%s: %q is not a duration", HeartbeatMetadata, values[len(values)-1])
+	}
+	return d, true, nil
+}
+
+// beat records a Set from client, with the interval it declared, if it did,
+// and the inputs it wrote, now owned by the client
+func (s *Server) beat(client string, interval time.Duration, declared bool, written map[*TestCase][]string) {
+	h := &s.heartbeats
+	h.Lock()
+	defer h.Unlock()
+
+	// inputs written change owner, even to clients not watched
+	for _, hb := range h.clients {
+		for testCase, inputs := range written {
+			for _, input := range inputs {
+				delete(hb.owned[testCase], input)
+			}
+		}
+	}
+
+	hb := h.clients[client]
+	if declared && interval == 0 {
+		if hb != nil {
+			hb.timer.Stop()
+			delete(h.clients, client)
+			s.logger().Info("heartbeat stopped", "client", client)
+		}
+		return
+	}
+	if declared && hb == nil {
+		if h.clients == nil {
+			h.clients = make(map[string]*heartbeat)
+		}
+		hb = &heartbeat{
+			owned: make(map[*TestCase]map[string]bool),
+			timer: time.AfterFunc(interval, func() { s.expire(client) }),
+		}
+		h.clients[client] = hb
+		s.logger().Info("heartbeat declared", "client", client, "interval", interval)
+	}
+	if hb == nil {
+		return
+	}
+	if declared {
+		hb.interval = interval
+	}
+	hb.last = time.Now()
+	hb.timer.Reset(hb.interval)
+	for testCase, inputs := range written {
+		if hb.owned[testCase] == nil {
+			hb.owned[testCase] = make(map[string]bool)
+		}
+		for _, input := range inputs {
+			hb.owned[testCase][input] = true
+		}
+	}
+}
+
+// releases the inputs client owns if its heartbeat is overdue
+func (s *Server) expire(client string) {
+	h := &s.heartbeats
+	h.Lock()
+	hb := h.clients[client]
+	if hb == nil || time.Since(hb.last) < hb.interval {
+		h.Unlock()
+		return
+	}
+	delete(h.clients, client)
+	h.Unlock()
+
+	for testCase, owned := range hb.owned {
+		if len(owned) == 0 {
+			continue
+		}
+		inputs := slices.Sorted(maps.Keys(owned))
+		s.logger().Warn("heartbeat missed, releasing overrides", "client", client,
+			"interval", hb.interval, "test_case", testCase.ID, "inputs", inputs)
+		testCase.release(inputs, AuditRecord{Caller: "heartbeat", Src: client})
+	}
+}
+
+// stops watching every client, releasing nothing
+func (s *Server) stopHeartbeats() {
+	h := &s.heartbeats
+	h.Lock()
+	defer h.Unlock()
+	for _, hb := range h.clients {
+		hb.timer.Stop()
+	}
+	clear(h.clients)
+}
+
+// release returns inputs to the baseline control: their overrides are
+// deactivated, setting each _activate input to 0, and latched _u values
+// dropped. The writes bypass the safety rules and are audited with rec.
+func (c *TestCase) release(inputs []string, rec AuditRecord) {
+	rec.Wall = time.Now()
+	rec.Time, _ = c.State.Get("time").(float64)
+	rec.TestCase, rec.ID = c.Name, c.ID
+
+	c.writeMu.Lock()
+	for _, input := range inputs {
+		c.writeBuffer.Delete(input)
+		if !strings.HasSuffix(input, "_"+SuffixOverride) && !strings.HasSuffix(input, "_"+SuffixActivate) {
+			continue
+		}
+		act := activation(input)
+		c.writeBuffer.Set(act, 0)
+		if c.audit.Load() != nil {
+			rec.Point, rec.Previous, rec.Value = act, c.State.Get(act), 0
+			c.writes = append(c.writes, rec)
+		}
+	}
+	c.writeMu.Unlock()
+
+	c.pending.Store(true)
+	c.metrics.Load().buffered(c.writeBuffer.Len())
+}
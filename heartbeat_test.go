@@ -0,0 +1,86 @@
+package boptest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jamesryancoleman/bos/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func withHeartbeat(ctx context.Context, interval string) context.Context {
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(HeartbeatMetadata, interval))
+}
+
+func TestHeartbeat(t *testing.T) {
+	testCase, err := NewTestCase("bestest_hydronic_heat_pump", WithPlant(newRCPlant(t, HVACHeatPump)),
+		WithWriteMode(WriteLatch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	s := NewServer("127.0.0.1:0", testCase)
+	defer s.stopHeartbeats()
+
+	set := func(ctx context.Context, src string, pairs ...*common.SetPair) error {
+		t.Helper()
+		_, err := s.Set(ctx, &common.SetRequest{Header: &common.Header{Src: src}, Pairs: pairs})
+		return err
+	}
+	supervisor := withHeartbeat(context.Background(), "100ms")
+	err = set(supervisor, "supervisor",
+		&common.SetPair{Key: "boptest:///oveTSet_activate", Value: "1"},
+		&common.SetPair{Key: "boptest:///oveTSet_u", Value: "294.15"},
+		&common.SetPair{Key: "boptest:///ovePum_activate", Value: "1"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another client takes over the pump, which is not released with the rest
+	if err := set(context.Background(), "operator", &common.SetPair{Key: "boptest:///ovePum_activate", Value: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// heartbeats without pairs keep the overrides in place
+	for range 4 {
+		time.Sleep(50 * time.Millisecond)
+		if err := set(context.Background(), "supervisor"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := testCase.writeBuffer.GetAll()["oveTSet_activate"]; v != "1" {
+		t.Fatalf("override released while the heartbeat continued, oveTSet_activate is %v", v)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for testCase.writeBuffer.GetAll()["oveTSet_activate"] != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("override not released after the heartbeat stopped: %v", testCase.writeBuffer.GetAll())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	inputs := testCase.writeBuffer.GetAll()
+	if _, ok := inputs["oveTSet_u"]; ok || inputs["ovePum_activate"] != "1" {
+		t.Errorf("latched inputs after the release are %v", inputs)
+	}
+
+	err = set(withHeartbeat(context.Background(), "soon"), "supervisor")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got %v, want an invalid argument error", err)
+	}
+
+	// a client that stops its heartbeat keeps its overrides
+	if err := set(supervisor, "supervisor", &common.SetPair{Key: "boptest:///oveTSet_activate", Value: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := set(withHeartbeat(context.Background(), "0"), "supervisor"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if v := testCase.writeBuffer.GetAll()["oveTSet_activate"]; v != "1" {
+		t.Errorf("override released after the heartbeat was stopped, oveTSet_activate is %v", v)
+	}
+}
@@ -0,0 +1,110 @@
+package boptest
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// Snapshot is the state of the simulation after initialize or an advance.
+type Snapshot struct {
+	Time  float64   // simulated seconds since the start of the year
+	Wall  time.Time // when the state was received
+	State map[string]any
+
+	// inputs of the advance that led to the state, as written by clients and
+	// as sent after actuator faults, nil after initialize
+	Commanded map[string]any
+	Applied   map[string]any
+}
+
+// History is a concurrency safe ring buffer of the most recent states of a
+// simulation. A nil History holds nothing.
+type History struct {
+	snapshots []Snapshot
+	next      int // index the next snapshot is written to
+	full      bool
+	sync.RWMutex
+}
+
+func NewHistory(length int) *History {
+	if length <= 0 {
+		return nil
+	}
+	return &History{snapshots: make([]Snapshot, length)}
+}
+
+// records a copy of state, using its "time" entry as the simulated time
+func (h *History) Add(state map[string]any) {
+	h.AddInputs(state, nil, nil)
+}
+
+// records a copy of state along with the inputs commanded and applied in the
+// advance that led to it
+func (h *History) AddInputs(state, commanded, applied map[string]any) {
+	if h == nil {
+		return
+	}
+	seconds, _ := state["time"].(float64)
+
+	h.Lock()
+	defer h.Unlock()
+	h.snapshots[h.next] = Snapshot{
+		Time:      seconds,
+		Wall:      time.Now(),
+		State:     maps.Clone(state),
+		Commanded: maps.Clone(commanded),
+		Applied:   maps.Clone(applied),
+	}
+	h.next = (h.next + 1) % len(h.snapshots)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+func (h *History) Len() int {
+	if h == nil {
+		return 0
+	}
+	h.RLock()
+	defer h.RUnlock()
+	if h.full {
+		return len(h.snapshots)
+	}
+	return h.next
+}
+
+// returns every snapshot, oldest first
+func (h *History) All() []Snapshot {
+	if h == nil {
+		return nil
+	}
+	h.RLock()
+	defer h.RUnlock()
+	if !h.full {
+		return append([]Snapshot{}, h.snapshots[:h.next]...)
+	}
+	return append(append([]Snapshot{}, h.snapshots[h.next:]...), h.snapshots[:h.next]...)
+}
+
+// returns the snapshots with start <= Time < end, oldest first
+func (h *History) Range(start, end float64) []Snapshot {
+	var results []Snapshot
+	for _, s := range h.All() {
+		if s.Time >= start && s.Time < end {
+			results = append(results, s)
+		}
+	}
+	return results
+}
+
+// returns the most recent snapshot at or before t and false if there is none
+func (h *History) At(t float64) (Snapshot, bool) {
+	all := h.All()
+	for i := len(all) - 1; i >= 0; i-- {
+		if all[i].Time <= t {
+			return all[i], true
+		}
+	}
+	return Snapshot{}, false
+}
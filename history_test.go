@@ -0,0 +1,39 @@
+package boptest
+
+import "testing"
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(3)
+	for i := range 5 {
+		h.Add(map[string]any{"time": float64(i * 60), "zon_reaTRooAir_y": 293.15 + float64(i)})
+	}
+	if h.Len() != 3 {
+		t.Fatalf("got %d snapshots, want 3", h.Len())
+	}
+
+	all := h.All()
+	for i, s := range all {
+		if want := float64((i + 2) * 60); s.Time != want {
+			t.Errorf("snapshot %d: got time %v, want %v", i, s.Time, want)
+		}
+	}
+
+	if r := h.Range(120, 240); len(r) != 2 {
+		t.Errorf("got %d snapshots in range, want 2", len(r))
+	}
+
+	s, ok := h.At(200)
+	if !ok || s.Time != 180 {
+		t.Errorf("got %v %v, want the snapshot at 180", s.Time, ok)
+	}
+	if _, ok := h.At(0); ok {
+		t.Errorf("found a snapshot older than the history")
+	}
+
+	// a nil history holds nothing
+	var none *History
+	none.Add(map[string]any{"time": 0.0})
+	if none.Len() != 0 || none.All() != nil {
+		t.Errorf("nil history is not empty")
+	}
+}
@@ -0,0 +1,191 @@
+package boptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+const DefaultLogFiles = 5 // rotated log files kept
+
+// returns l, or the default logger if it is nil, so that the loggers of
+// clients, test cases and servers can be left unset
+func orDefault(l *slog.Logger) *slog.Logger {
+	if l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// NewLogger returns a logger configured by l: text or json to stderr and, if
+// a file is given, json to the file, rotated beyond l.MaxBytes. Close the
+// returned closer to close the file.
+func NewLogger(l LoggingConfig) (*slog.Logger, io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return nil, nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if l.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	if l.File == "" {
+		return slog.New(handler), (*rotatingFile)(nil), nil
+	}
+
+	maxFiles := l.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = DefaultLogFiles
+	}
+	file, err := openRotatingFile(l.File, l.MaxBytes, maxFiles, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	handler = teeHandler{handler, slog.NewJSONHandler(file, opts)}
+	return slog.New(handler), file, nil
+}
+
+// teeHandler passes every record to each of its handlers that is enabled
+// for it.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// rotatingFile appends to the file at path. Before a write would grow it
+// beyond maxBytes it is moved to path.1, path.1 to path.2 and so on, keeping
+// maxFiles of them, and a new file is started with header. A nil
+// rotatingFile closes without error.
+type rotatingFile struct {
+	path     string
+	maxBytes int64 // 0 for never
+	maxFiles int
+	header   []byte // written at the start of every file
+
+	file *os.File
+	size int64 // of file
+	sync.Mutex
+}
+
+// opens, or creates, the file at path for appending
+func openRotatingFile(path string, maxBytes int64, maxFiles int, header []byte) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxBytes: maxBytes, maxFiles: maxFiles, header: header}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	if f.size == 0 && len(f.header) > 0 {
+		return f.write(f.header)
+	}
+	return nil
+}
+
+func (f *rotatingFile) write(b []byte) error {
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxFiles))
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Write appends b, rotating the file first if needed, so that b is never
+// split across files.
+func (f *rotatingFile) Write(b []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.file == nil {
+		return 0, fmt.Errorf("%s is closed", f.path)
+	}
+	if f.maxBytes > 0 && f.size > int64(len(f.header)) && f.size+int64(len(b)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	if err := f.write(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close syncs and closes the file. Later writes fail.
+func (f *rotatingFile) Close() error {
+	if f == nil {
+		return nil
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Sync()
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	f.file = nil
+	return err
+}
@@ -0,0 +1,114 @@
+package boptest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jamesryancoleman/bos/common"
+)
+
+func TestNoLogFileByDefault(t *testing.T) {
+	// importing the package must not write to the working directory
+	if _, err := os.Stat("boptest_log.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want no log file", err)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "boptest.log")
+	logger, closer, err := NewLogger(LoggingConfig{Level: "warn", Format: "json", File: path, MaxBytes: 300, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("below the level")
+	for range 5 {
+		logger.Warn("rotated", "padding", strings.Repeat("x", 100))
+	}
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > 300 {
+			t.Errorf("%s is %d bytes, beyond the limit", p, len(b))
+		}
+		lines = append(lines, strings.Split(strings.TrimSpace(string(b)), "\n")...)
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Errorf("kept more than 2 rotated files")
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, `"msg":"rotated"`) {
+			t.Errorf("unexpected line %s", line)
+		}
+	}
+
+	if _, _, err := NewLogger(LoggingConfig{Level: "loud"}); err == nil {
+		t.Errorf("expected an error for an unknown level")
+	}
+	logger, closer, err = NewLogger(LoggingConfig{Level: "info"})
+	if err != nil || logger == nil {
+		t.Fatalf("got %v, %v without a file", logger, err)
+	}
+	if err := closer.Close(); err != nil {
+		t.Errorf("closing without a file: %v", err)
+	}
+}
+
+// a buffer safe to log to from the run loop
+type syncBuffer struct {
+	buf bytes.Buffer
+	sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestInjectedLoggers(t *testing.T) {
+	var caseLog, serverLog syncBuffer
+	testCase, err := NewTestCase("bestest_air", WithPlant(newRCPlant(t, HVACFanCoil)),
+		WithLogger(slog.New(slog.NewTextHandler(&caseLog, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	s := NewServer("127.0.0.1:0", testCase, WithServerLogger(slog.New(slog.NewTextHandler(&serverLog, nil))))
+
+	_, err = s.Set(context.Background(), &common.SetRequest{Pairs: []*common.SetPair{
+		{Key: "boptest:///oveTSetHea_u", Value: "293.15"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(caseLog.String(), "created test case") || !strings.Contains(caseLog.String(), "setting input") {
+		t.Errorf("test case logged:\n%s", caseLog.String())
+	}
+	if !strings.Contains(serverLog.String(), "set request received") {
+		t.Errorf("server logged:\n%s", serverLog.String())
+	}
+	if strings.Contains(serverLog.String(), "setting input") {
+		t.Errorf("test case logged to the server's logger")
+	}
+}
@@ -0,0 +1,212 @@
+package boptest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Metrics collects Prometheus metrics of a Server and its test cases and
+// serves them on /metrics.
+type Metrics struct {
+	Addr   string       // listened on by Start
+	Points []string     // points of every test case exported as gauges, e.g. zon_reaTRooAir_y
+	Logger *slog.Logger // defaults to the default logger
+
+	registry        *prometheus.Registry
+	advanceSeconds  *prometheus.HistogramVec
+	advanceFailures *prometheus.CounterVec
+	simRatio        *prometheus.GaugeVec
+	writeBuffer     *prometheus.GaugeVec
+	rpcs            *prometheus.CounterVec
+	rpcSeconds      *prometheus.HistogramVec
+	points          *prometheus.GaugeVec
+
+	server *http.Server
+}
+
+// NewMetrics returns metrics served on listenAddr once started, exporting the
+// named points of every test case.
+func NewMetrics(listenAddr string, points ...string) *Metrics {
+	m := &Metrics{
+		Addr:     listenAddr,
+		Points:   points,
+		registry: prometheus.NewRegistry(),
+		advanceSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "boptest_advance_duration_seconds",
+			Help:    "Time taken to advance the simulation.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"test_case"}),
+		advanceFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "boptest_advance_failures_total",
+			Help: "Advances of the simulation that failed.",
+		}, []string{"test_case"}),
+		simRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "boptest_sim_time_ratio",
+			Help: "Simulated seconds per wall clock second over the last advance.",
+		}, []string{"test_case"}),
+		writeBuffer: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "boptest_write_buffer_depth",
+			Help: "Inputs waiting in the write buffer for the next advance.",
+		}, []string{"test_case"}),
+		rpcs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "boptest_rpcs_total",
+			Help: "gRPC requests handled, by method and status code.",
+		}, []string{"method", "code"}),
+		rpcSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "boptest_rpc_duration_seconds",
+			Help:    "Time taken to handle gRPC requests, by method and status code.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"method", "code"}),
+		points: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "boptest_point_value",
+			Help: "Value of a simulation point after the last advance.",
+		}, []string{"test_case", "point"}),
+	}
+	m.registry.MustRegister(
+		m.advanceSeconds, m.advanceFailures, m.simRatio, m.writeBuffer,
+		m.rpcs, m.rpcSeconds, m.points,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// Start listens on Addr and serves /metrics on a go routine.
+func (m *Metrics) Start() error {
+	lis, err := net.Listen("tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	m.Addr = lis.Addr().String() // resolves port 0
+	m.server = &http.Server{Handler: m.Handler()}
+
+	go func() {
+		if err := m.server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			orDefault(m.Logger).Error("metrics server failed", "error", err)
+		}
+	}()
+
+	orDefault(m.Logger).Info("metrics server started", "listen_addr", m.Addr)
+	return nil
+}
+
+func (m *Metrics) Shutdown(ctx context.Context) error {
+	if m.server == nil {
+		return nil
+	}
+	return m.server.Shutdown(ctx)
+}
+
+// Interceptor counts and times every RPC by method and status code.
+func (m *Metrics) Interceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		method, code := path.Base(info.FullMethod), status.Code(err).String()
+		m.rpcs.WithLabelValues(method, code).Inc()
+		m.rpcSeconds.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+		return resp, err
+	}
+}
+
+// returns the metrics of the test case registered under name
+func (m *Metrics) testCase(name string) *caseMetrics {
+	return &caseMetrics{Metrics: m, name: name}
+}
+
+// removes the series of the test case registered under name
+func (m *Metrics) forget(name string) {
+	labels := prometheus.Labels{"test_case": name}
+	m.advanceSeconds.DeletePartialMatch(labels)
+	m.advanceFailures.DeletePartialMatch(labels)
+	m.simRatio.DeletePartialMatch(labels)
+	m.writeBuffer.DeletePartialMatch(labels)
+	m.points.DeletePartialMatch(labels)
+}
+
+// caseMetrics records the metrics of one test case. A nil caseMetrics records
+// nothing.
+type caseMetrics struct {
+	*Metrics
+	name string
+
+	last    time.Time // wall clock time of the last state
+	lastSim float64   // simulated time of the last state
+	sync.Mutex
+}
+
+// records an advance that took d and returned state
+func (c *caseMetrics) advanced(d time.Duration, state map[string]any) {
+	if c == nil {
+		return
+	}
+	c.advanceSeconds.WithLabelValues(c.name).Observe(d.Seconds())
+
+	now := time.Now()
+	t, _ := state["time"].(float64)
+	c.Lock()
+	if !c.last.IsZero() {
+		if wall := now.Sub(c.last).Seconds(); wall > 0 {
+			c.simRatio.WithLabelValues(c.name).Set((t - c.lastSim) / wall)
+		}
+	}
+	c.last, c.lastSim = now, t
+	c.Unlock()
+
+	for _, point := range c.Points {
+		if v, err := InputValue(state[point]); err == nil {
+			c.points.WithLabelValues(c.name, point).Set(v)
+		}
+	}
+}
+
+// records the state the simulation was initialized to
+func (c *caseMetrics) initialized(state map[string]any) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	c.last = time.Now()
+	c.lastSim, _ = state["time"].(float64)
+	c.Unlock()
+}
+
+func (c *caseMetrics) failed() {
+	if c == nil {
+		return
+	}
+	c.advanceFailures.WithLabelValues(c.name).Inc()
+}
+
+// records the number of inputs in the write buffer
+func (c *caseMetrics) buffered(n int) {
+	if c == nil {
+		return
+	}
+	c.writeBuffer.WithLabelValues(c.name).Set(float64(n))
+}
+
+// export the metrics of the server and its test cases
+func WithMetrics(m *Metrics) serverOption {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
@@ -0,0 +1,102 @@
+package boptest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jamesryancoleman/bos/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	resp, err := http.Get("http://" + m.Addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics("127.0.0.1:0", "reaTZon_y")
+	s := NewServer("127.0.0.1:0", nil, WithMetrics(m))
+	b := NewPlantBackend(func(testcase string) (Plant, error) {
+		return NewRCPlant(DefaultRCConfig(HVACFor(testcase)))
+	})
+	testCase, err := s.CreateTestCase("hp", "bestest_hydronic_heat_pump", WithBackend(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	defer s.Shutdown(ctx)
+
+	conn, err := grpc.NewClient(s.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := common.NewDeviceControlClient(conn)
+
+	_, err = c.Set(ctx, &common.SetRequest{Pairs: []*common.SetPair{
+		{Key: "boptest://hp/oveTSet_activate", Value: "1"},
+		{Key: "boptest://hp/oveTSet_u", Value: "295.15"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := scrape(t, m); !strings.Contains(body, `boptest_write_buffer_depth{test_case="hp"} 2`) {
+		t.Errorf("write buffer depth not 2 in:\n%s", body)
+	}
+
+	if err := testCase.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, &common.GetRequest{Keys: []string{"boptest://hp/reaTZon_y"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		`boptest_rpcs_total{code="OK",method="Get"} 1`,
+		`boptest_rpcs_total{code="OK",method="Set"} 1`,
+		`boptest_rpc_duration_seconds_count{code="OK",method="Get"} 1`,
+		`boptest_advance_duration_seconds_count{test_case="hp"} 1`,
+		`boptest_write_buffer_depth{test_case="hp"} 0`,
+		`boptest_sim_time_ratio{test_case="hp"}`,
+		`boptest_point_value{point="reaTZon_y",test_case="hp"} 294.`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s in:\n%s", want, body)
+		}
+	}
+
+	// an advance of a test case the backend has stopped fails
+	if err := b.Stop(testCase.ID); err != nil {
+		t.Fatal(err)
+	}
+	testCase.SetInput("oveTSet_u", "296.15")
+	if err := testCase.Drain(); err == nil {
+		t.Fatal("expected an error advancing a stopped test case")
+	}
+	if body := scrape(t, m); !strings.Contains(body, `boptest_advance_failures_total{test_case="hp"} 1`) {
+		t.Errorf("advance failure not counted in:\n%s", body)
+	}
+
+	s.StopTestCase("hp")
+	if body := scrape(t, m); strings.Contains(body, `test_case="hp"`) {
+		t.Errorf("series of a removed test case remain in:\n%s", body)
+	}
+}
@@ -0,0 +1,190 @@
+package boptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/jamesryancoleman/bos/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LatencyDistribution is the shape of the delay added to each RPC.
+type LatencyDistribution string
+
+const (
+	LatencyConstant    LatencyDistribution = "constant"    // Mean
+	LatencyUniform     LatencyDistribution = "uniform"     // Mean plus or minus Jitter
+	LatencyNormal      LatencyDistribution = "normal"      // Mean with standard deviation Jitter
+	LatencyExponential LatencyDistribution = "exponential" // exponential with mean Mean
+)
+
+// Latency is the distribution of delays added to each RPC, never negative.
+type Latency struct {
+	Distribution LatencyDistribution // constant if empty
+	Mean         time.Duration
+	Jitter       time.Duration
+}
+
+// NetworkConditions describe a network between controllers and the server,
+// e.g. a flaky BACnet/IP router.
+type NetworkConditions struct {
+	Latency   Latency
+	ErrorRate float64 // probability an RPC fails with UNAVAILABLE
+
+	// keys, as path.Match patterns, that do not respond: an RPC with a
+	// matching key waits for its timeout, or its deadline if sooner, then
+	// fails with DEADLINE_EXCEEDED, e.g. "boptest://air/*": 5s
+	Timeouts map[string]time.Duration
+
+	Seed int64 // of latencies and errors, so runs are reproducible
+}
+
+func (n NetworkConditions) Validate() error {
+	var errs []error
+	switch n.Latency.Distribution {
+	case "", LatencyConstant, LatencyUniform, LatencyNormal, LatencyExponential:
+	default:
+		errs = append(errs, fmt.Errorf("unknown latency distribution %q", n.Latency.Distribution))
+	}
+	if n.Latency.Mean < 0 || n.Latency.Jitter < 0 {
+		errs = append(errs, fmt.Errorf("latency must not be negative"))
+	}
+	if n.ErrorRate < 0 || n.ErrorRate > 1 {
+		errs = append(errs, fmt.Errorf("error rate must be a probability"))
+	}
+	for pattern, d := range n.Timeouts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("timeout key %q: %w", pattern, err))
+		}
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("timeout of %q must not be negative", pattern))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NetworkEmulator imposes NetworkConditions on the RPCs of a Server through
+// its interceptors.
+type NetworkEmulator struct {
+	conditions NetworkConditions
+	rng        *rand.Rand
+	sync.Mutex // guards rng
+
+	log *slog.Logger // the default logger if nil
+}
+
+func NewNetworkEmulator(n NetworkConditions) (*NetworkEmulator, error) {
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+	return &NetworkEmulator{conditions: n, rng: rand.New(rand.NewSource(n.Seed))}, nil
+}
+
+// Interceptors returns the chain that times out keys, delays and then fails
+// RPCs, in that order.
+func (e *NetworkEmulator) Interceptors() []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{e.timeout, e.delay, e.fail}
+}
+
+// draws a latency from the distribution
+func (e *NetworkEmulator) latency() time.Duration {
+	l := e.conditions.Latency
+	e.Lock()
+	defer e.Unlock()
+	var d float64
+	switch l.Distribution {
+	case LatencyUniform:
+		d = float64(l.Mean) + (2*e.rng.Float64()-1)*float64(l.Jitter)
+	case LatencyNormal:
+		d = float64(l.Mean) + e.rng.NormFloat64()*float64(l.Jitter)
+	case LatencyExponential:
+		d = e.rng.ExpFloat64() * float64(l.Mean)
+	default:
+		d = float64(l.Mean)
+	}
+	return time.Duration(max(d, 0))
+}
+
+// true with probability p
+func (e *NetworkEmulator) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	e.Lock()
+	defer e.Unlock()
+	return e.rng.Float64() < p
+}
+
+// blocks for d or until ctx is done
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (e *NetworkEmulator) delay(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := wait(ctx, e.latency()); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (e *NetworkEmulator) fail(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if e.chance(e.conditions.ErrorRate) {
+		orDefault(e.log).Debug("emulated unavailable", "method", info.FullMethod)
+		return nil, status.Error(codes.Unavailable, "emulated network failure")
+	}
+	return handler(ctx, req)
+}
+
+func (e *NetworkEmulator) timeout(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var longest time.Duration
+	var matched bool
+	for _, key := range requestKeys(req) {
+		for pattern, d := range e.conditions.Timeouts {
+			if ok, _ := path.Match(pattern, key); ok {
+				matched = true
+				longest = max(longest, d)
+			}
+		}
+	}
+	if !matched {
+		return handler(ctx, req)
+	}
+	orDefault(e.log).Debug("emulated timeout", "method", info.FullMethod, "after", longest)
+	if err := wait(ctx, longest); err != nil {
+		return nil, err
+	}
+	return nil, status.Error(codes.DeadlineExceeded, "emulated timeout")
+}
+
+// returns the uris a Get or Set request refers to
+func requestKeys(req any) []string {
+	switch req := req.(type) {
+	case *common.GetRequest:
+		return req.GetKeys()
+	case *common.SetRequest:
+		keys := make([]string, len(req.GetPairs()))
+		for i, p := range req.GetPairs() {
+			keys[i] = p.GetKey()
+		}
+		return keys
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package boptest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jamesryancoleman/bos/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// calls the emulator's interceptor chain around a handler that succeeds
+func intercept(ctx context.Context, e *NetworkEmulator, req any) error {
+	info := &grpc.UnaryServerInfo{FullMethod: "/DeviceControl/Get"}
+	handler := func(ctx context.Context, req any) (any, error) { return req, nil }
+	interceptors := e.Interceptors()
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		next, interceptor := handler, interceptors[i]
+		handler = func(ctx context.Context, req any) (any, error) {
+			return interceptor(ctx, req, info, next)
+		}
+	}
+	_, err := handler(ctx, req)
+	return err
+}
+
+func TestNetworkEmulator(t *testing.T) {
+	ctx := context.Background()
+	get := &common.GetRequest{Keys: []string{"boptest://air/zon_reaTRooAir_y"}}
+
+	e, err := NewNetworkEmulator(NetworkConditions{Latency: Latency{Mean: 20 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := intercept(ctx, e, get); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("rpc took %v, want at least 20ms", d)
+	}
+
+	e, _ = NewNetworkEmulator(NetworkConditions{ErrorRate: 1})
+	if err := intercept(ctx, e, get); status.Code(err) != codes.Unavailable {
+		t.Errorf("got %v, want unavailable", err)
+	}
+
+	// a key that does not respond times out, or hits the deadline first
+	e, _ = NewNetworkEmulator(NetworkConditions{Timeouts: map[string]time.Duration{
+		"boptest://air/*": 10 * time.Millisecond,
+		"boptest://hp/*":  time.Hour,
+	}})
+	if err := intercept(ctx, e, get); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+	set := &common.SetRequest{Pairs: []*common.SetPair{{Key: "boptest://hp/oveTSet_u", Value: "1"}}}
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := intercept(short, e, set); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+	other := &common.GetRequest{Keys: []string{"boptest://fcu/time"}}
+	if err := intercept(ctx, e, other); err != nil {
+		t.Errorf("unmatched key failed: %v", err)
+	}
+
+	for _, n := range []NetworkConditions{
+		{Latency: Latency{Distribution: "pareto"}},
+		{Latency: Latency{Mean: -time.Second}},
+		{ErrorRate: 2},
+		{Timeouts: map[string]time.Duration{"[": time.Second}},
+	} {
+		if _, err := NewNetworkEmulator(n); err == nil {
+			t.Errorf("expected an error for %+v", n)
+		}
+	}
+}
+
+func TestNetworkLatency(t *testing.T) {
+	const n = 2000
+	for _, test := range []struct {
+		latency Latency
+		lo, hi  time.Duration // bounds of every draw
+	}{
+		{Latency{Mean: 50 * time.Millisecond}, 50 * time.Millisecond, 50 * time.Millisecond},
+		{Latency{Distribution: LatencyUniform, Mean: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}, 40 * time.Millisecond, 60 * time.Millisecond},
+		{Latency{Distribution: LatencyNormal, Mean: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}, 0, time.Second},
+		{Latency{Distribution: LatencyExponential, Mean: 50 * time.Millisecond}, 0, time.Hour},
+	} {
+		e, err := NewNetworkEmulator(NetworkConditions{Latency: test.latency, Seed: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sum time.Duration
+		for range n {
+			d := e.latency()
+			if d < test.lo || d > test.hi {
+				t.Fatalf("%+v: drew %v", test.latency, d)
+			}
+			sum += d
+		}
+		if mean := sum / n; mean < 45*time.Millisecond || mean > 55*time.Millisecond {
+			t.Errorf("%+v: mean latency %v, want about 50ms", test.latency, mean)
+		}
+	}
+}
+
+func TestServerNetworkConditions(t *testing.T) {
+	s := NewServer("127.0.0.1:0", nil, WithNetworkConditions(NetworkConditions{ErrorRate: 1}))
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	conn, err := grpc.NewClient(s.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := common.NewDeviceControlClient(conn)
+
+	_, err = c.Get(context.Background(), &common.GetRequest{Keys: []string{"boptest:///time"}})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("got %v, want unavailable", err)
+	}
+
+	s = NewServer("127.0.0.1:0", nil, WithNetworkConditions(NetworkConditions{ErrorRate: -1}))
+	if err := s.Start(); err == nil {
+		s.Shutdown(context.Background())
+		t.Errorf("expected an error starting with invalid network conditions")
+	}
+}
@@ -0,0 +1,63 @@
+package boptest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Plant is a building simulated in-process, in place of a BOPTEST server.
+// States have the same shape as BOPTEST's: every measurement and input keyed
+// by point name plus "time" in seconds since the start of the year.
+type Plant interface {
+	// Initialize resets the plant to start, simulating warmup seconds before it.
+	Initialize(start, warmup int) (map[string]any, error)
+	// Advance applies inputs for one step and returns the new state. Inputs
+	// that are not sent are released to the baseline control.
+	Advance(inputs map[string]any) (map[string]any, error)
+	Inputs() map[string]PointProperties
+	Measurements() map[string]PointProperties
+	Step() int
+	SetStep(seconds int) error
+}
+
+// numbers test cases run on a Plant, which have no BOPTEST test id
+var plantIDs atomic.Int64
+
+// simulate the test case with p instead of selecting it on the boptest server
+func WithPlant(p Plant) testCaseOption {
+	return func(c *TestCase) {
+		c.backend = NewPlantBackend(func(string) (Plant, error) { return p, nil })
+	}
+}
+
+// simulate the test case with b instead of the boptest server
+func WithBackend(b Backend) testCaseOption {
+	return func(c *TestCase) {
+		c.backend = b
+	}
+}
+
+// InputValue converts a value written to an input, e.g. the string of a gRPC
+// SetPair, to the number BOPTEST expects.
+func InputValue(v any) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	return 0, fmt.Errorf("unsupported value %v of type %T", v, v)
+}
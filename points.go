@@ -0,0 +1,148 @@
+package boptest
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// BOPTEST signal suffixes
+const (
+	SuffixMeasurement = "y"        // read only measurement
+	SuffixOverride    = "u"        // overwrite value
+	SuffixActivate    = "activate" // enables the overwrite value
+)
+
+// quantities inferred from point names and units
+const (
+	QuantityTemperature  = "temperature"
+	QuantityPower        = "power"
+	QuantityThermalPower = "thermal_power"
+	QuantityFlow         = "flow"
+	QuantityCO2          = "co2"
+	QuantityHumidity     = "humidity"
+	QuantityPressure     = "pressure"
+	QuantityIrradiance   = "irradiance"
+	QuantitySpeed        = "speed"
+	QuantityDirection    = "direction"
+	QuantitySignal       = "signal"
+)
+
+// PointName is a BOPTEST point name split into its naming conventions, e.g.
+// "zon_reaTRooAir_y" is component "zon", signal "reaTRooAir", suffix "y".
+type PointName struct {
+	Name      string
+	Component string // may be empty, e.g. "reaTZon_y"
+	Signal    string // includes the rea/ove prefix
+	Suffix    string
+}
+
+// returns the boptest:// uri of a point
+func PointURI(testCase, point string) string {
+	return fmt.Sprintf("boptest://%s/%s", testCase, point)
+}
+
+func ParsePointName(name string) PointName {
+	p := PointName{Name: name}
+	rest := name
+	for _, s := range []string{SuffixActivate, SuffixOverride, SuffixMeasurement} {
+		if strings.HasSuffix(rest, "_"+s) {
+			p.Suffix = s
+			rest = strings.TrimSuffix(rest, "_"+s)
+			break
+		}
+	}
+	// the signal is the last segment, anything before it is the component
+	if i := strings.LastIndex(rest, "_"); i >= 0 {
+		p.Component = rest[:i]
+		rest = rest[i+1:]
+	}
+	p.Signal = rest
+	return p
+}
+
+func (p PointName) IsMeasurement() bool { return p.Suffix == SuffixMeasurement }
+func (p PointName) IsOverride() bool    { return p.Suffix == SuffixOverride }
+func (p PointName) IsActivate() bool    { return p.Suffix == SuffixActivate }
+
+// the signal without its rea/ove and weather prefixes, e.g. "TRooAir"
+func (p PointName) body() string {
+	b := p.Signal
+	for _, prefix := range []string{"rea", "ove"} {
+		if strings.HasPrefix(b, prefix) {
+			b = b[len(prefix):]
+			break
+		}
+	}
+	return strings.TrimPrefix(b, "Wea")
+}
+
+// true if the signal contains the camel case word w, e.g. "Hea" in "TSetHea"
+func (p PointName) has(w string) bool {
+	b := p.body()
+	for i := strings.Index(b, w); i >= 0; {
+		end := i + len(w)
+		if end == len(b) || !unicode.IsLower(rune(b[end])) {
+			return true
+		}
+		next := strings.Index(b[end:], w)
+		if next < 0 {
+			break
+		}
+		i = end + next
+	}
+	return false
+}
+
+// a leading upper case letter followed by another upper case letter, e.g.
+// the "T" of "TRooAir"
+func (p PointName) leading(c byte) bool {
+	b := p.body()
+	return len(b) > 1 && b[0] == c && unicode.IsUpper(rune(b[1]))
+}
+
+func (p PointName) IsSetpoint() bool {
+	return p.has("Set")
+}
+
+// true for points that describe the zone rather than a piece of equipment
+func (p PointName) IsZone() bool {
+	return strings.HasPrefix(p.Component, "zon") || p.has("Roo") || p.has("Zon")
+}
+
+// true for points of the weather station or the outside air
+func (p PointName) IsOutside() bool {
+	return strings.HasPrefix(p.Component, "wea") || p.has("Out") || p.has("DryBul")
+}
+
+// Quantity infers what a point measures or commands from its name and unit.
+// An empty string is returned if nothing could be inferred.
+func (p PointName) Quantity(unit string) string {
+	switch {
+	case p.IsActivate():
+		return QuantitySignal
+	case strings.HasPrefix(p.body(), "CO2"):
+		return QuantityCO2
+	case unit == "K" || unit == "degC" || p.leading('T'):
+		return QuantityTemperature
+	case p.has("Hum"):
+		return QuantityHumidity
+	case unit == "W/m2":
+		return QuantityIrradiance
+	case p.leading('Q'):
+		return QuantityThermalPower
+	case unit == "W" || p.leading('P') && unit != "Pa":
+		return QuantityPower
+	case p.has("Flo") || unit == "m3/s" || unit == "kg/s":
+		return QuantityFlow
+	case unit == "Pa":
+		return QuantityPressure
+	case p.has("Spe") || unit == "m/s":
+		return QuantitySpeed
+	case p.has("Dir"):
+		return QuantityDirection
+	case unit == "1" || p.has("Y"):
+		return QuantitySignal
+	}
+	return ""
+}
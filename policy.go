@@ -0,0 +1,261 @@
+package boptest
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jamesryancoleman/bos/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Policy decides which points a caller may read and write, and with which
+// values. A request is checked pair by pair against the rules whose subjects
+// match the caller; a pair no rule allows is rejected. A nil Policy allows
+// everything.
+type Policy struct {
+	// bearer tokens by the name they authenticate as, sent in the
+	// authorization metadata as "Bearer {token}"
+	Tokens map[string]string `json:"tokens" yaml:"tokens"`
+	Rules  []PolicyRule      `json:"rules" yaml:"rules"`
+}
+
+// PolicyRule allows the callers matching Subjects, and Src if set, to read
+// and write points. Points are written {test case}/{point}, with the default
+// test case resolved, and matched as path.Match patterns, e.g. air/*_u or */*.
+type PolicyRule struct {
+	Name string `json:"name" yaml:"name"`
+
+	// patterns of who the caller is, any of which must match: cn:{common
+	// name}, uri:{uri}, dns:{name} from its client certificate, token:{name}
+	// from its bearer token, or * for anyone
+	Subjects []string `json:"subjects" yaml:"subjects"`
+	// patterns of the caller's Header.Src, any of which must match if set
+	Src []string `json:"src" yaml:"src"`
+
+	Read   []string     `json:"read" yaml:"read"`
+	Write  []string     `json:"write" yaml:"write"`
+	Ranges []ValueRange `json:"ranges" yaml:"ranges"` // of the values written, by point
+}
+
+// ValueRange bounds the values written to the matching points. A nil bound is
+// open.
+type ValueRange struct {
+	Points string   `json:"points" yaml:"points"`
+	Min    *float64 `json:"min" yaml:"min"`
+	Max    *float64 `json:"max" yaml:"max"`
+}
+
+// LoadPolicy reads a YAML or JSON policy file, chosen by its extension, and
+// validates it.
+func LoadPolicy(path string) (*Policy, error) {
+	var p Policy
+	if err := decodeFile(path, &p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// Validate reports every invalid pattern and range at once.
+func (p *Policy) Validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	for name, token := range p.Tokens {
+		if token == "" {
+			fail("tokens."+name, "is empty")
+		}
+	}
+	for i, r := range p.Rules {
+		field := fmt.Sprintf("rules[%d]", i)
+		if len(r.Subjects) == 0 {
+			fail(field+".subjects", "at least one subject is required")
+		}
+		for key, patterns := range map[string][]string{"subjects": r.Subjects, "src": r.Src, "read": r.Read, "write": r.Write} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					fail(field+"."+key, "%q: %v", pattern, err)
+				}
+			}
+		}
+		for j, vr := range r.Ranges {
+			if _, err := path.Match(vr.Points, ""); err != nil || vr.Points == "" {
+				fail(fmt.Sprintf("%s.ranges[%d].points", field, j), "%q is not a pattern", vr.Points)
+			}
+			if vr.Min != nil && vr.Max != nil && *vr.Min > *vr.Max {
+				fail(fmt.Sprintf("%s.ranges[%d]", field, j), "min is greater than max")
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Caller is who made a request, as far as the policy is concerned.
+type Caller struct {
+	Identity *Identity // from a verified client certificate
+	Token    string    // name of the bearer token presented
+	Src      string    // Header.Src, as asserted by the caller
+}
+
+// the subjects the caller can be matched as
+func (c Caller) subjects() []string {
+	var subjects []string
+	if c.Identity != nil {
+		subjects = append(subjects, "cn:"+c.Identity.CommonName)
+		for _, u := range c.Identity.URIs {
+			subjects = append(subjects, "uri:"+u)
+		}
+		for _, d := range c.Identity.DNSNames {
+			subjects = append(subjects, "dns:"+d)
+		}
+	}
+	if c.Token != "" {
+		subjects = append(subjects, "token:"+c.Token)
+	}
+	return subjects
+}
+
+func (c Caller) String() string {
+	if s := c.subjects(); len(s) > 0 {
+		return strings.Join(s, ",")
+	}
+	return "anonymous"
+}
+
+// returns the caller of an RPC, or an Unauthenticated error if it presented a
+// token the policy does not know
+func (p *Policy) caller(ctx context.Context, header *common.Header) (Caller, error) {
+	c := Caller{Src: header.GetSrc()}
+	if id, ok := PeerIdentity(ctx); ok {
+		c.Identity = &id
+	}
+	if p == nil {
+		return c, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			continue
+		}
+		for name, want := range p.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+				c.Token = name
+			}
+		}
+		if c.Token == "" {
+			return c, status.Error(codes.Unauthenticated, "unknown bearer token")
+		}
+	}
+	return c, nil
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// true if the rule applies to the caller
+func (r PolicyRule) matches(c Caller) bool {
+	if len(r.Src) > 0 && !matchAny(r.Src, c.Src) {
+		return false
+	}
+	for _, pattern := range r.Subjects {
+		if pattern == "*" {
+			return true
+		}
+		for _, s := range c.subjects() {
+			if ok, _ := path.Match(pattern, s); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CanRead returns an error unless the caller may read point, written
+// {test case}/{point}.
+func (p *Policy) CanRead(c Caller, point string) error {
+	if p == nil {
+		return nil
+	}
+	for _, r := range p.Rules {
+		if r.matches(c) && matchAny(r.Read, point) {
+			return nil
+		}
+	}
+	return fmt.Errorf("permission denied: %s may not read %s", c, point)
+}
+
+// CanWrite returns an error unless the caller may write value to point,
+// written {test case}/{point}, within the ranges of a rule that allows it.
+func (p *Policy) CanWrite(c Caller, point string, value string) error {
+	if p == nil {
+		return nil
+	}
+	var rangeErr error
+	for _, r := range p.Rules {
+		if !r.matches(c) || !matchAny(r.Write, point) {
+			continue
+		}
+		err := r.inRange(point, value)
+		if err == nil {
+			return nil
+		}
+		rangeErr = err
+	}
+	if rangeErr != nil {
+		return rangeErr
+	}
+	return fmt.Errorf("permission denied: %s may not write %s", c, point)
+}
+
+// returns an error if value is outside a range of the rule for point
+func (r PolicyRule) inRange(point, value string) error {
+	for _, vr := range r.Ranges {
+		if ok, _ := path.Match(vr.Points, point); !ok {
+			continue
+		}
+		v, err := InputValue(value)
+		if err != nil {
+			return fmt.Errorf("permission denied: %s is range limited and %q is not a number", point, value)
+		}
+		if (vr.Min != nil && v < *vr.Min) || (vr.Max != nil && v > *vr.Max) {
+			return fmt.Errorf("permission denied: %v is out of the range allowed for %s", v, point)
+		}
+	}
+	return nil
+}
+
+// authorize reads and writes with the policy
+func WithPolicy(p *Policy) serverOption {
+	return func(s *Server) {
+		s.policy = p
+	}
+}
+
+// returns the point of a routable uri as {test case}/{point}, resolving the
+// default test case
+func (s *Server) policyPoint(uri string) string {
+	matches := schemaRe.FindStringSubmatch(uri)
+	name := matches[schemaRe.SubexpIndex("testCase")]
+	if name == "" {
+		s.RLock()
+		name = s.defaultCase
+		s.RUnlock()
+	}
+	return name + "/" + matches[schemaRe.SubexpIndex("point")]
+}
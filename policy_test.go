@@ -0,0 +1,189 @@
+package boptest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jamesryancoleman/bos/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const testPolicy = `
+tokens:
+  dashboard: s3cret
+rules:
+  - name: bos
+    subjects: ["uri:spiffe://building/*"]
+    read: ["*/*"]
+    write: ["air/oveTSet*_u", "air/*_activate"]
+    ranges:
+      - points: "air/oveTSet*_u"
+        min: 288.15
+        max: 303.15
+  - name: dashboard
+    subjects: ["token:dashboard"]
+    src: ["dashboard.*"]
+    read: ["*/zon_*"]
+`
+
+func writePolicy(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// returns a context as if the client presented a verified certificate
+func withClientCert(t *testing.T, cn string, uris ...string) context.Context {
+	ca := newTestCA(t)
+	template := x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	for _, u := range uris {
+		parsed, _ := url.Parse(u)
+		template.URIs = append(template.URIs, parsed)
+	}
+	cert := newTestCert(t, cn, ca, template)
+	info := credentials.TLSInfo{State: tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{cert.cert, ca.cert}},
+	}}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: info})
+}
+
+func withToken(ctx context.Context, token string) context.Context {
+	return metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestPolicy(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, "policy.yaml", testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	air := offlineTestCase("bestest_air", map[string]any{"time": 0.0, "zon_reaTRooAir_y": 294.0})
+	s := NewServer("127.0.0.1:0", nil, WithPolicy(policy))
+	if err := s.AddTestCase("air", air); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(ctx context.Context, src string, keys ...string) []*common.GetPair {
+		t.Helper()
+		r, err := s.Get(ctx, &common.GetRequest{Header: &common.Header{Src: src}, Keys: keys})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.GetPairs()
+	}
+	set := func(ctx context.Context, pairs ...*common.SetPair) []*common.SetPair {
+		t.Helper()
+		r, err := s.Set(ctx, &common.SetRequest{Pairs: pairs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.GetPairs()
+	}
+
+	// the building os reads anything and writes setpoints within range
+	bos := withClientCert(t, "bos", "spiffe://building/bos")
+	if p := get(bos, "", "boptest:///zon_reaTRooAir_y", "boptest://air/time"); p[0].ErrorMsg != nil || p[1].ErrorMsg != nil {
+		t.Errorf("reads by the building os: %v", p)
+	}
+	pairs := set(bos,
+		&common.SetPair{Key: "boptest:///oveTSetHea_u", Value: "293.15"},
+		&common.SetPair{Key: "boptest://air/oveTSetCoo_u", Value: "310"},
+		&common.SetPair{Key: "boptest://air/oveTSetHea_u", Value: "warm"},
+		&common.SetPair{Key: "boptest://air/fcu_oveFan_u", Value: "1"},
+	)
+	if pairs[0].ErrorMsg != nil {
+		t.Errorf("write within range denied: %s", pairs[0].GetErrorMsg())
+	}
+	if !strings.Contains(pairs[1].GetErrorMsg(), "out of the range") {
+		t.Errorf("write out of range: %v", pairs[1])
+	}
+	if !strings.Contains(pairs[2].GetErrorMsg(), "not a number") {
+		t.Errorf("non-numeric write to a range limited point: %v", pairs[2])
+	}
+	if !strings.Contains(pairs[3].GetErrorMsg(), "may not write") {
+		t.Errorf("write to a point not allowed: %v", pairs[3])
+	}
+	if buffered := air.writeBuffer.GetAll(); len(buffered) != 1 || buffered["oveTSetHea_u"] != "293.15" {
+		t.Errorf("write buffer holds %v, want only the allowed write", buffered)
+	}
+
+	// the dashboard reads zone points, but only from where it says it is
+	dashboard := withToken(context.Background(), "s3cret")
+	p := get(dashboard, "dashboard.local", "boptest:///zon_reaTRooAir_y", "boptest:///time")
+	if p[0].ErrorMsg != nil || !strings.Contains(p[1].GetErrorMsg(), "may not read") {
+		t.Errorf("reads by the dashboard: %v", p)
+	}
+	if p := get(dashboard, "laptop.local", "boptest:///zon_reaTRooAir_y"); p[0].ErrorMsg == nil {
+		t.Errorf("read from another source allowed")
+	}
+	if p := set(dashboard, &common.SetPair{Key: "boptest:///oveTSetHea_u", Value: "293.15"}); p[0].ErrorMsg == nil {
+		t.Errorf("write by the dashboard allowed")
+	}
+
+	// anonymous callers match no rule and unknown tokens are rejected outright
+	if p := get(context.Background(), "", "boptest:///zon_reaTRooAir_y"); p[0].ErrorMsg == nil {
+		t.Errorf("anonymous read allowed")
+	}
+	_, err = s.Get(withToken(context.Background(), "guess"), &common.GetRequest{Keys: []string{"boptest:///time"}})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("got %v for an unknown token, want unauthenticated", err)
+	}
+}
+
+func TestNilPolicy(t *testing.T) {
+	var p *Policy
+	c, err := p.caller(withToken(context.Background(), "anything"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CanRead(c, "air/time"); err != nil {
+		t.Error(err)
+	}
+	if err := p.CanWrite(c, "air/oveTSet_u", "1000"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	_, err := LoadPolicy(writePolicy(t, "policy.yaml", `
+tokens:
+  empty: ""
+rules:
+  - read: ["[*"]
+    ranges:
+      - points: "*"
+        min: 2
+        max: 1
+`))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"tokens.empty", "rules[0].subjects", "rules[0].read", "rules[0].ranges[0]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	if _, err := LoadPolicy(writePolicy(t, "policy.yaml", "rules:\n  - subject: [\"*\"]\n")); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+	if _, err := LoadPolicy(writePolicy(t, "policy.toml", "")); err == nil {
+		t.Errorf("expected an error for an unsupported format")
+	}
+	p, err := LoadPolicy(writePolicy(t, "policy.json", `{"rules": [{"subjects": ["*"], "read": ["*/*"]}]}`))
+	if err != nil || len(p.Rules) != 1 {
+		t.Errorf("got %v, %v for a json policy", p, err)
+	}
+}
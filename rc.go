@@ -0,0 +1,397 @@
+package boptest
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"strings"
+	"sync"
+)
+
+// HVACType is the system conditioning the zone of an RCPlant.
+type HVACType string
+
+const (
+	// a heat pump modulating to hold the zone at oveTSet_u, with the points
+	// of bestest_hydronic_heat_pump
+	HVACHeatPump HVACType = "heat_pump"
+	// a fan coil holding the zone between con_oveTSetHea_u and
+	// con_oveTSetCoo_u, with the points of bestest_air
+	HVACFanCoil HVACType = "fan_coil"
+)
+
+const (
+	rcSubstep = 60.0   // seconds, of the explicit integration
+	cpAir     = 1005.0 // J/(kg.K)
+	tSupply   = 308.15 // K, heat pump condenser supply water temperature
+)
+
+// RCConfig describes a reduced-order zone: a 3R2C network of the zone air
+// and the thermal mass of the building, both coupled to the outside, and the
+// system that conditions it.
+type RCConfig struct {
+	HVAC HVACType `json:"hvac" yaml:"hvac"`
+
+	CAir  float64 `json:"c_air" yaml:"c_air"`   // J/K, zone air and furnishings
+	CMass float64 `json:"c_mass" yaml:"c_mass"` // J/K, walls and floor
+	RWin  float64 `json:"r_win" yaml:"r_win"`   // K/W, air to outside, e.g. windows and infiltration
+	RInt  float64 `json:"r_int" yaml:"r_int"`   // K/W, air to mass
+	RExt  float64 `json:"r_ext" yaml:"r_ext"`   // K/W, mass to outside
+
+	Gains             float64 `json:"gains" yaml:"gains"`                             // W, internal
+	TOutsideMean      float64 `json:"t_outside_mean" yaml:"t_outside_mean"`           // K
+	TOutsideAmplitude float64 `json:"t_outside_amplitude" yaml:"t_outside_amplitude"` // K, of the daily swing, peaking at 15:00
+	TInitial          float64 `json:"t_initial" yaml:"t_initial"`                     // K
+
+	HeatingSetpoint float64 `json:"heating_setpoint" yaml:"heating_setpoint"` // K, of the baseline control
+	CoolingSetpoint float64 `json:"cooling_setpoint" yaml:"cooling_setpoint"` // K, of the baseline control, fan coil only
+	Band            float64 `json:"band" yaml:"band"`                         // K, proportional band of the baseline control
+
+	Capacity   float64 `json:"capacity" yaml:"capacity"`       // W, thermal, at full load
+	Efficiency float64 `json:"efficiency" yaml:"efficiency"`   // heat pump COP as a fraction of Carnot
+	CoolingCOP float64 `json:"cooling_cop" yaml:"cooling_cop"` // fan coil cooling coil COP
+	FanPower   float64 `json:"fan_power" yaml:"fan_power"`     // W, at full speed
+	PumpPower  float64 `json:"pump_power" yaml:"pump_power"`   // W, heat pump emission circuit pump
+	AirFlow    float64 `json:"air_flow" yaml:"air_flow"`       // kg/s, fan coil at full speed
+}
+
+// DefaultRCConfig returns a single zone of about 50 m2 in a cold climate,
+// conditioned by hvac.
+func DefaultRCConfig(hvac HVACType) RCConfig {
+	return RCConfig{
+		HVAC:              hvac,
+		CAir:              1e6,
+		CMass:             2e7,
+		RWin:              0.02,
+		RInt:              0.005,
+		RExt:              0.01,
+		Gains:             200,
+		TOutsideMean:      278.15,
+		TOutsideAmplitude: 5,
+		TInitial:          294.15,
+		HeatingSetpoint:   294.15,
+		CoolingSetpoint:   297.15,
+		Band:              1,
+		Capacity:          5000,
+		Efficiency:        0.45,
+		CoolingCOP:        3,
+		FanPower:          100,
+		PumpPower:         50,
+		AirFlow:           0.5,
+	}
+}
+
+// HVACFor returns the system of the BOPTEST test case the RCPlant stands in
+// for, a heat pump for the heat pump test cases and a fan coil otherwise.
+func HVACFor(testcase string) HVACType {
+	if strings.Contains(testcase, "heat_pump") {
+		return HVACHeatPump
+	}
+	return HVACFanCoil
+}
+
+// Validate reports the first problem with the config.
+func (rc RCConfig) Validate() error {
+	if rc.HVAC != HVACHeatPump && rc.HVAC != HVACFanCoil {
+		return fmt.Errorf("hvac must be %q or %q, not %q", HVACHeatPump, HVACFanCoil, rc.HVAC)
+	}
+	for name, v := range map[string]float64{
+		"c_air": rc.CAir, "c_mass": rc.CMass,
+		"r_win": rc.RWin, "r_int": rc.RInt, "r_ext": rc.RExt,
+		"band": rc.Band,
+	} {
+		if v <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	if rc.HVAC == HVACHeatPump && rc.Efficiency <= 0 {
+		return fmt.Errorf("efficiency must be positive")
+	}
+	if rc.HVAC == HVACFanCoil && rc.CoolingCOP <= 0 {
+		return fmt.Errorf("cooling_cop must be positive")
+	}
+	return nil
+}
+
+func heatPumpPoints() (inputs, measurements map[string]PointProperties) {
+	inputs = map[string]PointProperties{
+		"oveTSet_u":    {Unit: "K", Description: "Zone operative temperature setpoint", Minimum: 278.15, Maximum: 308.15},
+		"oveHeaPumY_u": {Unit: "1", Description: "Heat pump modulating signal for compressor speed between 0 (not working) and 1 (working at maximum capacity)", Minimum: 0, Maximum: 1},
+		"oveFan_u":     {Unit: "1", Description: "Integer signal to control the heat pump evaporator fan either on or off", Minimum: 0, Maximum: 1},
+		"ovePum_u":     {Unit: "1", Description: "Integer signal to control the emission circuit pump either on or off", Minimum: 0, Maximum: 1},
+	}
+	measurements = map[string]PointProperties{
+		"reaTZon_y":              {Unit: "K", Description: "Zone operative temperature"},
+		"reaTSetHea_y":           {Unit: "K", Description: "Zone operative temperature setpoint for heating"},
+		"reaTSetCoo_y":           {Unit: "K", Description: "Zone operative temperature setpoint for cooling"},
+		"reaPHeaPum_y":           {Unit: "W", Description: "Heat pump electrical power"},
+		"reaPFan_y":              {Unit: "W", Description: "Electrical power of the heat pump evaporator fan"},
+		"reaPPumEmi_y":           {Unit: "W", Description: "Emission circuit pump electrical power"},
+		"reaQHeaPumCon_y":        {Unit: "W", Description: "Heat pump thermal power exchanged in the condenser"},
+		"reaCOP_y":               {Unit: "1", Description: "Heat pump COP"},
+		"weaSta_reaWeaTDryBul_y": {Unit: "K", Description: "Outside drybulb temperature measurement"},
+	}
+	return inputs, measurements
+}
+
+func fanCoilPoints() (inputs, measurements map[string]PointProperties) {
+	inputs = map[string]PointProperties{
+		"con_oveTSetHea_u": {Unit: "K", Description: "Zone temperature setpoint for heating", Minimum: 278.15, Maximum: 308.15},
+		"con_oveTSetCoo_u": {Unit: "K", Description: "Zone temperature setpoint for cooling", Minimum: 285.15, Maximum: 313.15},
+		"fcu_oveFan_u":     {Unit: "1", Description: "Fan control signal as air mass flow rate normalized to the design fan air mass flow rate", Minimum: 0, Maximum: 1},
+		"fcu_oveTSup_u":    {Unit: "K", Description: "Supply air temperature setpoint", Minimum: 285.15, Maximum: 313.15},
+	}
+	measurements = map[string]PointProperties{
+		"zon_reaTRooAir_y":           {Unit: "K", Description: "Zone air temperature"},
+		"con_reaTSetHea_y":           {Unit: "K", Description: "Zone air temperature setpoint for heating"},
+		"con_reaTSetCoo_y":           {Unit: "K", Description: "Zone air temperature setpoint for cooling"},
+		"fcu_reaPHea_y":              {Unit: "W", Description: "Heating thermal power consumption"},
+		"fcu_reaPCoo_y":              {Unit: "W", Description: "Cooling electrical power consumption"},
+		"fcu_reaPFan_y":              {Unit: "W", Description: "Supply fan electrical power consumption"},
+		"fcu_reaFloSup_y":            {Unit: "kg/s", Description: "Supply air mass flow rate"},
+		"zon_weaSta_reaWeaTDryBul_y": {Unit: "K", Description: "Outside drybulb temperature measurement"},
+	}
+	return inputs, measurements
+}
+
+// RCPlant is a Plant simulating an RCConfig with a proportional baseline
+// control that can be overwritten through BOPTEST-style _u and _activate
+// inputs.
+type RCPlant struct {
+	cfg          RCConfig
+	inputs       map[string]PointProperties // including _activate inputs
+	measurements map[string]PointProperties
+
+	time  float64 // seconds since start of year
+	tAir  float64 // K
+	tMass float64 // K
+	step  int
+	out   map[string]float64 // measurements and inputs of the last step
+
+	sync.Mutex
+}
+
+// NewRCPlant returns a plant at rest at TInitial, advancing DefaultStep
+// seconds per step.
+func NewRCPlant(cfg RCConfig) (*RCPlant, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	var inputs, measurements map[string]PointProperties
+	if cfg.HVAC == HVACHeatPump {
+		inputs, measurements = heatPumpPoints()
+	} else {
+		inputs, measurements = fanCoilPoints()
+	}
+	for name, p := range maps.Clone(inputs) {
+		inputs[activation(name)] = PointProperties{Description: "Activation for " + p.Description}
+	}
+
+	p := &RCPlant{
+		cfg:          cfg,
+		inputs:       inputs,
+		measurements: measurements,
+		step:         DefaultStep,
+	}
+	p.reset(0)
+	return p, nil
+}
+
+func (p *RCPlant) reset(t float64) {
+	p.time = t
+	p.tAir = p.cfg.TInitial
+	p.tMass = p.cfg.TInitial
+	p.out = make(map[string]float64, len(p.inputs)+len(p.measurements))
+	for name := range p.inputs {
+		p.out[name] = 0
+	}
+	p.simulate(nil, 0)
+}
+
+// the outside temperature at t
+func (p *RCPlant) tOutside(t float64) float64 {
+	phase := 2 * math.Pi * (t/86400 - 15.0/24)
+	return p.cfg.TOutsideMean + p.cfg.TOutsideAmplitude*math.Cos(phase)
+}
+
+// the _activate input enabling the _u input name
+func activation(name string) string {
+	return strings.TrimSuffix(name, "_"+SuffixOverride) + "_" + SuffixActivate
+}
+
+// returns the overwritten value of the _u input name if it is activated, and
+// baseline otherwise
+func overwrite(inputs map[string]float64, name string, baseline float64) float64 {
+	if inputs[activation(name)] != 1 {
+		return baseline
+	}
+	if v, ok := inputs[name]; ok {
+		return v
+	}
+	return baseline
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+// heat pump: returns the heat delivered to the zone and sets its measurements
+func (p *RCPlant) heatPump(inputs map[string]float64, tOut float64) float64 {
+	setpoint := overwrite(inputs, "oveTSet_u", p.cfg.HeatingSetpoint)
+	y := overwrite(inputs, "oveHeaPumY_u", clamp((setpoint-p.tAir)/p.cfg.Band, 0, 1))
+	y = clamp(y, 0, 1)
+	on := 0.0
+	if y > 0 {
+		on = 1
+	}
+	fan := clamp(overwrite(inputs, "oveFan_u", on), 0, 1)
+	pump := clamp(overwrite(inputs, "ovePum_u", on), 0, 1)
+	if fan == 0 || pump == 0 {
+		y = 0 // no source or no emission, the compressor cannot run
+	}
+
+	q := y * p.cfg.Capacity
+	cop := clamp(p.cfg.Efficiency*tSupply/math.Max(tSupply-tOut, 1), 1, 10)
+	var power float64
+	if q > 0 {
+		power = q / cop
+	}
+
+	p.out["reaTSetHea_y"] = setpoint
+	p.out["reaTSetCoo_y"] = p.cfg.CoolingSetpoint
+	p.out["reaPHeaPum_y"] = power
+	p.out["reaPFan_y"] = fan * p.cfg.FanPower
+	p.out["reaPPumEmi_y"] = pump * p.cfg.PumpPower
+	p.out["reaQHeaPumCon_y"] = q
+	p.out["reaCOP_y"] = cop
+	p.out["weaSta_reaWeaTDryBul_y"] = tOut
+	return q
+}
+
+// fan coil: returns the heat delivered to the zone and sets its measurements
+func (p *RCPlant) fanCoil(inputs map[string]float64, tOut float64) float64 {
+	heating := overwrite(inputs, "con_oveTSetHea_u", p.cfg.HeatingSetpoint)
+	cooling := overwrite(inputs, "con_oveTSetCoo_u", p.cfg.CoolingSetpoint)
+	yHea := clamp((heating-p.tAir)/p.cfg.Band, 0, 1)
+	yCoo := clamp((p.tAir-cooling)/p.cfg.Band, 0, 1)
+	fan := clamp(overwrite(inputs, "fcu_oveFan_u", math.Max(yHea, yCoo)), 0, 1)
+
+	var q float64
+	if tSup := overwrite(inputs, "fcu_oveTSup_u", math.NaN()); !math.IsNaN(tSup) {
+		q = fan * p.cfg.AirFlow * cpAir * (tSup - p.tAir)
+	} else {
+		q = (yHea - yCoo) * p.cfg.Capacity
+	}
+	// the coil cannot deliver more than the air that passes it
+	limit := fan * p.cfg.Capacity
+	q = clamp(q, -limit, limit)
+
+	p.out["con_reaTSetHea_y"] = heating
+	p.out["con_reaTSetCoo_y"] = cooling
+	p.out["fcu_reaPHea_y"] = math.Max(q, 0)
+	p.out["fcu_reaPCoo_y"] = math.Max(-q, 0) / p.cfg.CoolingCOP
+	p.out["fcu_reaPFan_y"] = p.cfg.FanPower * fan * fan * fan
+	p.out["fcu_reaFloSup_y"] = fan * p.cfg.AirFlow
+	p.out["zon_weaSta_reaWeaTDryBul_y"] = tOut
+	return q
+}
+
+// integrates the network for seconds with inputs applied
+func (p *RCPlant) simulate(inputs map[string]float64, seconds float64) {
+	for remaining := seconds; ; remaining -= rcSubstep {
+		dt := math.Min(remaining, rcSubstep)
+		tOut := p.tOutside(p.time)
+
+		var q float64
+		if p.cfg.HVAC == HVACHeatPump {
+			q = p.heatPump(inputs, tOut)
+		} else {
+			q = p.fanCoil(inputs, tOut)
+		}
+		if dt <= 0 {
+			break
+		}
+
+		toAir := (tOut-p.tAir)/p.cfg.RWin + (p.tMass-p.tAir)/p.cfg.RInt + q + p.cfg.Gains
+		toMass := (p.tAir-p.tMass)/p.cfg.RInt + (tOut-p.tMass)/p.cfg.RExt
+		p.tAir += dt * toAir / p.cfg.CAir
+		p.tMass += dt * toMass / p.cfg.CMass
+		p.time += dt
+	}
+
+	if p.cfg.HVAC == HVACHeatPump {
+		p.out["reaTZon_y"] = p.tAir
+	} else {
+		p.out["zon_reaTRooAir_y"] = p.tAir
+	}
+}
+
+func (p *RCPlant) state() map[string]any {
+	state := make(map[string]any, len(p.out)+1)
+	for k, v := range p.out {
+		state[k] = v
+	}
+	state["time"] = p.time
+	return state
+}
+
+func (p *RCPlant) Initialize(start, warmup int) (map[string]any, error) {
+	if start < 0 || warmup < 0 {
+		return nil, fmt.Errorf("start time and warm up must not be negative")
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.reset(float64(start - warmup))
+	p.simulate(nil, float64(warmup))
+	p.time = float64(start) // exact, whatever the substeps
+	return p.state(), nil
+}
+
+func (p *RCPlant) Advance(inputs map[string]any) (map[string]any, error) {
+	values := make(map[string]float64, len(inputs))
+	for name, v := range inputs {
+		if _, ok := p.inputs[name]; !ok {
+			return nil, fmt.Errorf("unexpected input variable: %s", name)
+		}
+		f, err := InputValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+		values[name] = f
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	for name := range p.inputs {
+		p.out[name] = values[name] // inputs that are not sent are released
+	}
+	start := p.time
+	p.simulate(values, float64(p.step))
+	p.time = start + float64(p.step)
+	return p.state(), nil
+}
+
+func (p *RCPlant) Inputs() map[string]PointProperties {
+	return maps.Clone(p.inputs)
+}
+
+func (p *RCPlant) Measurements() map[string]PointProperties {
+	return maps.Clone(p.measurements)
+}
+
+func (p *RCPlant) Step() int {
+	p.Lock()
+	defer p.Unlock()
+	return p.step
+}
+
+func (p *RCPlant) SetStep(seconds int) error {
+	if seconds <= 0 {
+		return fmt.Errorf("step must be positive")
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.step = seconds
+	return nil
+}
@@ -0,0 +1,173 @@
+package boptest
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/jamesryancoleman/bos/common"
+)
+
+func newRCPlant(t *testing.T, hvac HVACType) *RCPlant {
+	t.Helper()
+	p, err := NewRCPlant(DefaultRCConfig(hvac))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// advances the plant n steps with the same inputs and returns the last state
+func advanceN(t *testing.T, p Plant, n int, inputs map[string]any) map[string]any {
+	t.Helper()
+	var state map[string]any
+	for range n {
+		var err error
+		state, err = p.Advance(inputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return state
+}
+
+func TestRCPlantHeatPump(t *testing.T) {
+	p := newRCPlant(t, HVACHeatPump)
+	state, err := p.Initialize(3600*24*31, 3600*24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state["time"] != float64(3600*24*31) {
+		t.Fatalf("time is %v", state["time"])
+	}
+	for name := range p.Measurements() {
+		if _, ok := state[name]; !ok {
+			t.Errorf("missing measurement %s", name)
+		}
+	}
+
+	// the baseline control holds the zone at its setpoint over a day
+	state = advanceN(t, p, 24, map[string]any{})
+	if tZon := state["reaTZon_y"].(float64); math.Abs(tZon-294.15) > 1 {
+		t.Errorf("zone at %.2f K, want about 294.15 K", tZon)
+	}
+	if power := state["reaPHeaPum_y"].(float64); power <= 0 {
+		t.Errorf("heat pump power is %v, want > 0", power)
+	}
+	if cop := state["reaCOP_y"].(float64); cop < 1 || cop > 10 {
+		t.Errorf("cop is %v", cop)
+	}
+
+	// a raised setpoint heats the zone
+	state = advanceN(t, p, 6, map[string]any{"oveTSet_activate": "1", "oveTSet_u": "296.15"})
+	if tZon := state["reaTZon_y"].(float64); tZon < 295.15 {
+		t.Errorf("zone at %.2f K after raising the setpoint to 296.15 K", tZon)
+	}
+	if state["oveTSet_u"] != 296.15 || state["reaTSetHea_y"] != 296.15 {
+		t.Errorf("setpoint input %v and measurement %v", state["oveTSet_u"], state["reaTSetHea_y"])
+	}
+
+	// with the compressor overwritten off the zone cools
+	before := state["reaTZon_y"].(float64)
+	state = advanceN(t, p, 6, map[string]any{"oveHeaPumY_activate": 1, "oveHeaPumY_u": 0})
+	if tZon := state["reaTZon_y"].(float64); tZon >= before-0.5 {
+		t.Errorf("zone at %.2f K after 6 h off, was %.2f K", tZon, before)
+	}
+	if power := state["reaPHeaPum_y"]; power != 0.0 {
+		t.Errorf("heat pump power is %v while off", power)
+	}
+
+	if _, err := p.Advance(map[string]any{"zon_reaTRooAir_y": 1}); err == nil {
+		t.Errorf("expected an error advancing with a measurement as an input")
+	}
+	if _, err := p.Advance(map[string]any{"oveTSet_u": "warm"}); err == nil {
+		t.Errorf("expected an error advancing with a non numeric input")
+	}
+}
+
+func TestRCPlantFanCoil(t *testing.T) {
+	p := newRCPlant(t, HVACFanCoil)
+	if _, err := p.Initialize(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetStep(900); err != nil {
+		t.Fatal(err)
+	}
+
+	// the supply air temperature and fan speed drive the coil directly
+	state := advanceN(t, p, 4, map[string]any{
+		"fcu_oveTSup_activate": 1, "fcu_oveTSup_u": 308.15,
+		"fcu_oveFan_activate": 1, "fcu_oveFan_u": 1,
+	})
+	if state["time"] != 3600.0 {
+		t.Errorf("time is %v, want 3600", state["time"])
+	}
+	if flow := state["fcu_reaFloSup_y"]; flow != 0.5 {
+		t.Errorf("supply flow is %v, want 0.5", flow)
+	}
+	if heat := state["fcu_reaPHea_y"].(float64); heat <= 0 {
+		t.Errorf("heating power is %v, want > 0", heat)
+	}
+	if tRoo := state["zon_reaTRooAir_y"].(float64); tRoo <= 294.15 {
+		t.Errorf("zone at %.2f K, want above its initial 294.15 K", tRoo)
+	}
+}
+
+func TestTestCasePlant(t *testing.T) {
+	plant := newRCPlant(t, HVACFanCoil)
+	testCase, err := NewTestCase(testcase, WithPlant(plant), WithStep(1800))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	if s, _ := testCase.Step(); s != 1800 {
+		t.Errorf("step is %d, want 1800", s)
+	}
+
+	err = testCase.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !testCase.Status() {
+		t.Errorf("test case on a plant not running")
+	}
+
+	s := NewServer("127.0.0.1:0", testCase)
+	ctx := context.Background()
+	set, err := s.Set(ctx, &common.SetRequest{Pairs: []*common.SetPair{
+		{Key: "boptest:///con_oveTSetHea_activate", Value: "1"},
+		{Key: "boptest:///con_oveTSetHea_u", Value: "300.15"},
+		{Key: "boptest:///con_oveTSetHae_u", Value: "300.15"},
+		{Key: "boptest:///fcu_oveFan_u", Value: "on"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// mistyped points and values are rejected without failing the advance
+	for i, p := range set.GetPairs() {
+		if rejected := p.ErrorMsg != nil; rejected != (i >= 2) {
+			t.Errorf("pair %d: %v", i, p)
+		}
+	}
+	if err := testCase.Drain(); err != nil {
+		t.Fatal(err)
+	}
+
+	get, err := s.Get(ctx, &common.GetRequest{Keys: []string{"boptest:///time", "boptest:///con_reaTSetHea_y"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1800", "300.15"}
+	for i, p := range get.GetPairs() {
+		if p.GetValue() != want[i] {
+			t.Errorf("%s = %q, want %q", p.GetKey(), p.GetValue(), want[i])
+		}
+	}
+
+	if err := testCase.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if testCase.Status() {
+		t.Errorf("test case on a plant running after Shutdown")
+	}
+}
@@ -0,0 +1,307 @@
+package boptest
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"path"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SafetyRules protect a building from the inputs a controller writes. Writes
+// that break a rule are rejected, and inputs that break one by the time they
+// are advanced, e.g. because an interlock became active, are dropped from the
+// advance, releasing them to the baseline control.
+type SafetyRules struct {
+	RateLimits []RateLimit `json:"rate_limits" yaml:"rate_limits"`
+	MinTimes   []MinTime   `json:"min_times" yaml:"min_times"`
+	Interlocks []Interlock `json:"interlocks" yaml:"interlocks"`
+
+	// wall seconds without a write before every input is released to the
+	// baseline control, which matters in latch write mode, 0 for never
+	Watchdog float64 `json:"watchdog" yaml:"watchdog"`
+}
+
+// RateLimit bounds how fast the inputs matching Input, a path.Match pattern,
+// may change from the value last applied, or before any is from the value the
+// test case's state holds for an input it reports overridden.
+type RateLimit struct {
+	Input   string  `json:"input" yaml:"input"`
+	MaxRate float64 `json:"max_rate" yaml:"max_rate"` // per simulated hour, e.g. 2 for 2 K/h
+}
+
+// MinTime keeps binary inputs matching Input, e.g. fcu_oveFan_activate, on
+// or off for a minimum time once switched. Non-zero values are on.
+type MinTime struct {
+	Input  string  `json:"input" yaml:"input"`
+	MinOn  float64 `json:"min_on" yaml:"min_on"`   // simulated seconds
+	MinOff float64 `json:"min_off" yaml:"min_off"` // simulated seconds
+}
+
+// Interlock blocks writes to the inputs matching Block while the value
+// written to the input If is above Above and below Below, e.g. no heating
+// while the cooling override is active.
+type Interlock struct {
+	Name  string   `json:"name" yaml:"name"`
+	If    string   `json:"if" yaml:"if"`
+	Above *float64 `json:"above" yaml:"above"`
+	Below *float64 `json:"below" yaml:"below"`
+	Block []string `json:"block" yaml:"block"`
+}
+
+// Validate reports every invalid rule at once.
+func (r SafetyRules) Validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	pattern := func(field, p string) {
+		if _, err := path.Match(p, ""); err != nil || p == "" {
+			fail(field, "%q is not a pattern", p)
+		}
+	}
+	for i, l := range r.RateLimits {
+		pattern(fmt.Sprintf("rate_limits[%d].input", i), l.Input)
+		if l.MaxRate <= 0 {
+			fail(fmt.Sprintf("rate_limits[%d].max_rate", i), "must be positive")
+		}
+	}
+	for i, m := range r.MinTimes {
+		pattern(fmt.Sprintf("min_times[%d].input", i), m.Input)
+		if m.MinOn < 0 || m.MinOff < 0 || m.MinOn+m.MinOff == 0 {
+			fail(fmt.Sprintf("min_times[%d]", i), "min_on and min_off must not be negative, and one positive")
+		}
+	}
+	for i, l := range r.Interlocks {
+		field := fmt.Sprintf("interlocks[%d]", i)
+		if l.If == "" {
+			fail(field+".if", "is required")
+		}
+		if l.Above == nil && l.Below == nil {
+			fail(field, "above or below is required")
+		}
+		if len(l.Block) == 0 {
+			fail(field+".block", "at least one input is required")
+		}
+		for _, p := range l.Block {
+			pattern(field+".block", p)
+		}
+	}
+	if r.Watchdog < 0 {
+		fail("watchdog", "must not be negative")
+	}
+	return errors.Join(errs...)
+}
+
+// ErrWatchdog is why inputs are dropped once the controller stops writing.
+var ErrWatchdog = errors.New("safety watchdog expired, no writes")
+
+// Safety enforces SafetyRules on the inputs of a test case. A nil Safety
+// allows everything.
+type Safety struct {
+	rules SafetyRules
+
+	last      map[string]sample // value and simulated time each input was last applied at
+	switched  map[string]sample // on (1) or off (0) and since when, of binary inputs
+	lastWrite time.Time
+	tripped   bool // the watchdog has expired since the last write
+	now       func() time.Time
+	sync.Mutex
+}
+
+type sample struct {
+	value, time float64
+}
+
+func NewSafety(rules SafetyRules) (*Safety, error) {
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	return &Safety{
+		rules:     rules,
+		last:      make(map[string]sample),
+		switched:  make(map[string]sample),
+		lastWrite: time.Now(),
+		now:       time.Now,
+	}, nil
+}
+
+func matches(pattern, input string) bool {
+	ok, _ := path.Match(pattern, input)
+	return ok
+}
+
+// violation returns the rule writing value to input at simulated time t would
+// break, with the values written to the other inputs in written
+func (s *Safety) violation(input string, value any, t float64, written map[string]any) error {
+	for _, l := range s.rules.Interlocks {
+		if !slices.ContainsFunc(l.Block, func(p string) bool { return matches(p, input) }) {
+			continue
+		}
+		v, ok := written[l.If]
+		if !ok {
+			continue
+		}
+		x, err := InputValue(v)
+		if err != nil || (l.Above != nil && x <= *l.Above) || (l.Below != nil && x >= *l.Below) {
+			continue
+		}
+		name := l.Name
+		if name == "" {
+			name = l.If
+		}
+		return fmt.Errorf("interlock %s: %s is blocked while %s is %v", name, input, l.If, v)
+	}
+
+	var x float64
+	var numeric bool
+	number := func() error {
+		if numeric {
+			return nil
+		}
+		var err error
+		x, err = InputValue(value)
+		if err != nil {
+			return fmt.Errorf("%s is safety limited and %v is not a number", input, value)
+		}
+		numeric = true
+		return nil
+	}
+	for _, l := range s.rules.RateLimits {
+		if !matches(l.Input, input) {
+			continue
+		}
+		if err := number(); err != nil {
+			return err
+		}
+		last, ok := s.last[input]
+		if !ok {
+			continue
+		}
+		allowed := l.MaxRate * math.Max(t-last.time, 0) / 3600
+		if change := math.Abs(x - last.value); change > allowed+1e-9 {
+			return fmt.Errorf("rate limit: %s may change by %.4g by now, not %.4g from %v", input, allowed, change, last.value)
+		}
+	}
+	for _, m := range s.rules.MinTimes {
+		if !matches(m.Input, input) {
+			continue
+		}
+		if err := number(); err != nil {
+			return err
+		}
+		sw, ok := s.switched[input]
+		if !ok || (x != 0) == (sw.value != 0) {
+			continue
+		}
+		state, min := "off", m.MinOff
+		if sw.value != 0 {
+			state, min = "on", m.MinOn
+		}
+		if since := t - sw.time; since < min {
+			return fmt.Errorf("min time: %s must stay %s for another %.0fs", input, state, min-since)
+		}
+	}
+	return nil
+}
+
+// Seed takes value, the value input holds in the state of the test case, as
+// applied at simulated time t if input is rate limited and nothing has been
+// applied to it yet, so that its first write is limited too.
+func (s *Safety) Seed(input string, value any, t float64) {
+	if s == nil || !slices.ContainsFunc(s.rules.RateLimits, func(l RateLimit) bool { return matches(l.Input, input) }) {
+		return
+	}
+	x, err := InputValue(value)
+	if err != nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.last[input]; !ok {
+		s.last[input] = sample{x, t}
+	}
+}
+
+// Check returns an error if writing value to input at simulated time t, with
+// the inputs already written in pending, breaks a rule. It counts as a write
+// for the watchdog.
+func (s *Safety) Check(input string, value any, t float64, pending map[string]any) error {
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.lastWrite, s.tripped = s.now(), false
+	written := maps.Clone(pending)
+	if written == nil {
+		written = make(map[string]any)
+	}
+	written[input] = value
+	return s.violation(input, value, t, written)
+}
+
+// Apply returns the inputs to advance at simulated time t with those that
+// break a rule dropped, and why each was. If the watchdog has expired every
+// input is dropped with ErrWatchdog. The inputs returned are taken as applied.
+func (s *Safety) Apply(inputs map[string]any, t float64) (map[string]any, map[string]error) {
+	if s == nil {
+		return inputs, nil
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	dropped := make(map[string]error)
+	if w := s.rules.Watchdog; w > 0 && s.now().Sub(s.lastWrite) > time.Duration(w*float64(time.Second)) {
+		s.tripped = true
+		for input := range inputs {
+			dropped[input] = ErrWatchdog
+		}
+		return map[string]any{}, dropped
+	}
+
+	applied := make(map[string]any, len(inputs))
+	for _, input := range slices.Sorted(maps.Keys(inputs)) {
+		if err := s.violation(input, inputs[input], t, inputs); err != nil {
+			dropped[input] = err
+			continue
+		}
+		applied[input] = inputs[input]
+	}
+	for input, v := range applied {
+		x, err := InputValue(v)
+		if err != nil {
+			continue
+		}
+		if slices.ContainsFunc(s.rules.RateLimits, func(l RateLimit) bool { return matches(l.Input, input) }) {
+			s.last[input] = sample{x, t}
+		}
+		if !slices.ContainsFunc(s.rules.MinTimes, func(m MinTime) bool { return matches(m.Input, input) }) {
+			continue
+		}
+		if sw, ok := s.switched[input]; !ok || (x != 0) != (sw.value != 0) {
+			s.switched[input] = sample{x, t}
+		}
+	}
+	return applied, dropped
+}
+
+// Tripped reports whether the watchdog has expired since the last write.
+func (s *Safety) Tripped() bool {
+	if s == nil {
+		return false
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.tripped
+}
+
+// enforce safety rules on the inputs written, see SafetyRules
+func WithSafety(s *Safety) testCaseOption {
+	return func(c *TestCase) {
+		c.Safety = s
+	}
+}
@@ -0,0 +1,205 @@
+package boptest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesryancoleman/bos/common"
+)
+
+func testSafety(t *testing.T, watchdog float64) (*Safety, *time.Time) {
+	t.Helper()
+	zero := 0.0
+	s, err := NewSafety(SafetyRules{
+		RateLimits: []RateLimit{{Input: "oveTSet*_u", MaxRate: 2}},
+		MinTimes:   []MinTime{{Input: "ovePum_activate", MinOn: 600, MinOff: 300}},
+		Interlocks: []Interlock{{Name: "no heating while cooling", If: "oveCoo_activate", Above: &zero, Block: []string{"oveHea*"}}},
+		Watchdog:   watchdog,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Now()
+	s.now = func() time.Time { return clock }
+	return s, &clock
+}
+
+func TestSafety(t *testing.T) {
+	s, clock := testSafety(t, 60)
+	wantErr := func(err error, want string) {
+		t.Helper()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got %v, want an error containing %q", err, want)
+		}
+	}
+
+	if err := s.Check("oveTSetHea_u", 293.15, 0, nil); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	applied, dropped := s.Apply(map[string]any{"oveTSetHea_u": 293.15, "ovePum_activate": "1"}, 0)
+	if len(applied) != 2 || len(dropped) != 0 {
+		t.Fatalf("applied %v, dropped %v", applied, dropped)
+	}
+
+	// 2 K/h allows half a kelvin after 15 minutes
+	wantErr(s.Check("oveTSetHea_u", 294.15, 900, nil), "rate limit")
+	if err := s.Check("oveTSetHea_u", 293.65, 900, nil); err != nil {
+		t.Errorf("change within the rate limit: %v", err)
+	}
+	wantErr(s.Check("oveTSetHea_u", "warm", 900, nil), "not a number")
+
+	wantErr(s.Check("ovePum_activate", 0, 300, nil), "must stay on for another 300s")
+	if err := s.Check("ovePum_activate", 0, 600, nil); err != nil {
+		t.Errorf("switching off after the min on time: %v", err)
+	}
+	if err := s.Check("ovePum_activate", 1, 300, nil); err != nil {
+		t.Errorf("writing the state the input is in: %v", err)
+	}
+
+	wantErr(s.Check("oveHeaPum_u", 1, 600, map[string]any{"oveCoo_activate": "1"}), "no heating while cooling")
+	if err := s.Check("oveHeaPum_u", 1, 600, map[string]any{"oveCoo_activate": "0"}); err != nil {
+		t.Errorf("interlock inactive: %v", err)
+	}
+
+	// inputs written before the interlock became active are dropped
+	applied, dropped = s.Apply(map[string]any{"oveCoo_activate": "1", "oveHeaPum_u": 1, "ovePum_activate": "1"}, 900)
+	if _, ok := applied["oveHeaPum_u"]; ok || dropped["oveHeaPum_u"] == nil || len(applied) != 2 {
+		t.Errorf("applied %v, dropped %v with the interlock active", applied, dropped)
+	}
+
+	// the controller stops writing
+	*clock = clock.Add(61 * time.Second)
+	applied, dropped = s.Apply(map[string]any{"ovePum_activate": "1"}, 1800)
+	if len(applied) != 0 || !errors.Is(dropped["ovePum_activate"], ErrWatchdog) || !s.Tripped() {
+		t.Errorf("applied %v, dropped %v after the watchdog expired", applied, dropped)
+	}
+	s.Check("ovePum_activate", "1", 1800, nil)
+	if s.Tripped() {
+		t.Errorf("watchdog tripped after a write")
+	}
+
+	var none *Safety
+	if err := none.Check("oveTSetHea_u", 400, 0, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSafetyRulesValidate(t *testing.T) {
+	err := SafetyRules{
+		RateLimits: []RateLimit{{Input: "[", MaxRate: 0}},
+		MinTimes:   []MinTime{{Input: "ovePum_activate"}},
+		Interlocks: []Interlock{{}},
+		Watchdog:   -1,
+	}.Validate()
+	for _, want := range []string{
+		"rate_limits[0].input", "rate_limits[0].max_rate", "min_times[0]",
+		"interlocks[0].if", "interlocks[0]: above or below", "interlocks[0].block", "watchdog",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v does not mention %s", err, want)
+		}
+	}
+}
+
+func TestSafetyTestCase(t *testing.T) {
+	b := &recordingBackend{PlantBackend: NewPlantBackend(func(testcase string) (Plant, error) {
+		return NewRCPlant(DefaultRCConfig(HVACFor(testcase)))
+	})}
+	safety, clock := testSafety(t, 60)
+	testCase, err := NewTestCase("bestest_hydronic_heat_pump", WithBackend(b),
+		WithWriteMode(WriteLatch), WithSafety(safety))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	if err := testCase.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("127.0.0.1:0", testCase)
+
+	set := func(pairs ...*common.SetPair) []*common.SetPair {
+		t.Helper()
+		r, err := s.Set(context.Background(), &common.SetRequest{Pairs: pairs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.GetPairs()
+	}
+	pairs := set(
+		&common.SetPair{Key: "boptest:///oveTSet_activate", Value: "1"},
+		&common.SetPair{Key: "boptest:///oveTSet_u", Value: "294.15"},
+	)
+	if pairs[0].ErrorMsg != nil || pairs[1].ErrorMsg != nil {
+		t.Fatalf("first writes rejected: %v", pairs)
+	}
+	if err := testCase.Drain(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a step of 3 K within the hour that passed is rejected pair by pair
+	pairs = set(&common.SetPair{Key: "boptest:///oveTSet_u", Value: "297.15"})
+	if !strings.Contains(pairs[0].GetErrorMsg(), "rate limit") {
+		t.Errorf("got %v, want a rate limit error", pairs[0])
+	}
+	if err := testCase.SetInput("oveTSet_u", "297.15"); err == nil {
+		t.Errorf("expected SetInput to be rejected too")
+	}
+
+	// latched inputs are released once the controller stops writing
+	*clock = clock.Add(2 * time.Minute)
+	if err := testCase.advanceOnce(); err != nil {
+		t.Fatal(err)
+	}
+	if last := b.advances[len(b.advances)-1]; len(last) != 0 {
+		t.Errorf("advanced with %v after the watchdog expired", last)
+	}
+	if n := testCase.writeBuffer.Len(); n != 0 {
+		t.Errorf("%d inputs still latched after the watchdog expired", n)
+	}
+	if len(b.advances) != 2 || b.advances[0]["oveTSet_u"] != "294.15" {
+		t.Errorf("advances are %v", b.advances)
+	}
+}
+
+func TestSafetySeed(t *testing.T) {
+	start := func() *TestCase {
+		t.Helper()
+		testCase, err := NewTestCase("bestest_hydronic_heat_pump", WithPlant(newRCPlant(t, HVACHeatPump)),
+			WithWriteMode(WriteLatch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(testCase.Stop)
+		if err := testCase.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return testCase
+	}
+
+	// an inactive override is not seeded from the value it reports
+	testCase := start()
+	testCase.Safety, _ = testSafety(t, 0)
+	if err := testCase.SetInput("oveTSet_u", 300); err != nil {
+		t.Errorf("first write to an inactive override: %v", err)
+	}
+
+	// overridden before the rules apply, e.g. by the baseline experiment
+	testCase = start()
+	testCase.SetInput("oveTSet_activate", 1)
+	testCase.SetInput("oveTSet_u", 294.15)
+	if err := testCase.advanceOnce(); err != nil {
+		t.Fatal(err)
+	}
+	testCase.Safety, _ = testSafety(t, 0)
+
+	// 2 K/h allows 2 K from the value held over the hour since
+	if err := testCase.SetInput("oveTSet_u", 297.15); err == nil || !strings.Contains(err.Error(), "rate limit") {
+		t.Errorf("got %v, want a rate limit error for the first write", err)
+	}
+	if err := testCase.SetInput("oveTSet_u", 295.15); err != nil {
+		t.Errorf("change within the rate limit: %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+package boptest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScheduleFile is a set of schedules and the holidays they share, as loaded
+// by LoadSchedules.
+type ScheduleFile struct {
+	Holidays  []string   `json:"holidays" yaml:"holidays"` // dates, see ScheduleException
+	Schedules []Schedule `json:"schedules" yaml:"schedules"`
+}
+
+// Schedule writes Input by the simulated time of day, with a profile for
+// weekdays, weekends, holidays and exceptional dates. Days are those of the
+// calendar StateMap.Time maps the simulated time onto.
+type Schedule struct {
+	Name  string `json:"name" yaml:"name"`
+	Input string `json:"input" yaml:"input"` // e.g. oveTSetHea_u, its _activate input is written with it
+
+	// in effect until the first entry of a day if no day before it has any,
+	// nil to release the input
+	Default *float64 `json:"default" yaml:"default"`
+
+	Weekday []ScheduleEntry `json:"weekday" yaml:"weekday"`
+	Weekend []ScheduleEntry `json:"weekend" yaml:"weekend"`
+	Holiday []ScheduleEntry `json:"holiday" yaml:"holiday"` // the weekend profile if nil
+
+	Exceptions []ScheduleException `json:"exceptions" yaml:"exceptions"`
+}
+
+// ScheduleEntry sets the value from a time of day until the next entry, or
+// the end of the day.
+type ScheduleEntry struct {
+	At    string   `json:"at" yaml:"at"`       // e.g. 07:00 or 07:00:30
+	Value *float64 `json:"value" yaml:"value"` // nil to release the input to the baseline control
+}
+
+// ScheduleException replaces the profile of a date, either every year, e.g.
+// 12-25, or once, e.g. 2025-11-27.
+type ScheduleException struct {
+	Date    string          `json:"date" yaml:"date"`
+	Entries []ScheduleEntry `json:"entries" yaml:"entries"`
+}
+
+// returns the seconds since midnight of an entry's time of day
+func parseTimeOfDay(at string) (int, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, at); err == nil {
+			return t.Hour()*3600 + t.Minute()*60 + t.Second(), nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a time of day, e.g. 07:00", at)
+}
+
+// reports whether date, as MM-DD or YYYY-MM-DD, is the day of t
+func dateMatches(date string, t time.Time) bool {
+	if len(date) == len("01-02") {
+		return date == t.Format("01-02")
+	}
+	return date == t.Format(time.DateOnly)
+}
+
+func validDate(date string) bool {
+	if _, err := time.Parse(time.DateOnly, date); err == nil {
+		return true
+	}
+	// Feb 29 is valid in any year
+	_, err := time.Parse(time.DateOnly, "2024-"+date)
+	return err == nil && len(date) == len("01-02")
+}
+
+// Validate reports every invalid schedule, entry and date at once.
+func (f ScheduleFile) Validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	entries := func(field string, entries []ScheduleEntry) {
+		for i, e := range entries {
+			if _, err := parseTimeOfDay(e.At); err != nil {
+				fail(fmt.Sprintf("%s[%d].at", field, i), "%v", err)
+			}
+		}
+	}
+	for i, d := range f.Holidays {
+		if !validDate(d) {
+			fail(fmt.Sprintf("holidays[%d]", i), "%q is not a date, e.g. 12-25 or 2025-11-27", d)
+		}
+	}
+	names := make(map[string]bool)
+	for i, s := range f.Schedules {
+		field := fmt.Sprintf("schedules[%d]", i)
+		if s.Name == "" {
+			fail(field+".name", "is required")
+		} else if names[s.Name] {
+			fail(field+".name", "duplicate schedule %q", s.Name)
+		}
+		names[s.Name] = true
+		if s.Input == "" {
+			fail(field+".input", "is required")
+		}
+		entries(field+".weekday", s.Weekday)
+		entries(field+".weekend", s.Weekend)
+		entries(field+".holiday", s.Holiday)
+		for j, e := range s.Exceptions {
+			if !validDate(e.Date) {
+				fail(fmt.Sprintf("%s.exceptions[%d].date", field, j), "%q is not a date, e.g. 12-25 or 2025-11-27", e.Date)
+			}
+			entries(fmt.Sprintf("%s.exceptions[%d].entries", field, j), e.Entries)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// returns the entries for the day of t
+func (s Schedule) profile(t time.Time, holidays []string) []ScheduleEntry {
+	for _, e := range s.Exceptions {
+		if dateMatches(e.Date, t) {
+			return e.Entries
+		}
+	}
+	weekend := t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+	if slices.ContainsFunc(holidays, func(d string) bool { return dateMatches(d, t) }) {
+		if s.Holiday != nil {
+			return s.Holiday
+		}
+		weekend = true
+	}
+	if weekend {
+		return s.Weekend
+	}
+	return s.Weekday
+}
+
+// returns the value in effect at t, nil if the input is released. Before the
+// first entry of a day, the last value set on a day before, up to a week,
+// stays in effect.
+func (s Schedule) value(t time.Time, holidays []string) *float64 {
+	seconds := t.Hour()*3600 + t.Minute()*60 + t.Second()
+	for day := range 8 {
+		var value *float64
+		found, lastAt := false, -1
+		for _, e := range s.profile(t.AddDate(0, 0, -day), holidays) {
+			at, _ := parseTimeOfDay(e.At)
+			if (day > 0 || at <= seconds) && at >= lastAt {
+				value, lastAt, found = e.Value, at, true
+			}
+		}
+		if found {
+			return value
+		}
+	}
+	return s.Default
+}
+
+// Scheduler is a Controller that writes the inputs of its schedules through
+// the write buffer: when a value changes, or on every step in write once
+// mode, so that it holds between changes. The inputs of released entries and
+// removed schedules have their overrides deactivated. Schedules may be
+// edited while it runs, and a scheduler loaded from a file reloads it when it
+// changes.
+type Scheduler struct {
+	file    ScheduleFile
+	path    string    // reloaded from, if loaded from a file
+	modTime time.Time // of the file when loaded
+
+	written map[*TestCase]map[string]*float64 // by input, nil if released
+	sync.Mutex
+}
+
+// NewScheduler returns a scheduler for the schedules in f.
+func NewScheduler(f ScheduleFile) (*Scheduler, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return &Scheduler{file: f, written: make(map[*TestCase]map[string]*float64)}, nil
+}
+
+func readScheduleFile(path string) (ScheduleFile, error) {
+	var f ScheduleFile
+	if err := decodeFile(path, &f); err != nil {
+		return f, err
+	}
+	if err := f.Validate(); err != nil {
+		return f, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// LoadSchedules returns a scheduler for the schedules in the yaml or json
+// file at path, which it reloads when the file changes.
+func LoadSchedules(path string) (*Scheduler, error) {
+	mod, err := modTime(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := readScheduleFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, _ := NewScheduler(f)
+	s.path, s.modTime = path, mod
+	return s, nil
+}
+
+// Reload loads the file the schedules were loaded from if it changed since.
+// On an error the schedules loaded before are kept.
+func (s *Scheduler) Reload() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.reload()
+}
+
+func (s *Scheduler) reload() error {
+	if s.path == "" {
+		return nil
+	}
+	mod, err := modTime(s.path)
+	if err != nil || mod == s.modTime {
+		return err
+	}
+	f, err := readScheduleFile(s.path)
+	if err != nil {
+		return err
+	}
+	s.file, s.modTime = f, mod
+	return nil
+}
+
+// Schedules returns a copy of the schedules and holidays.
+func (s *Scheduler) Schedules() ScheduleFile {
+	s.Lock()
+	defer s.Unlock()
+	return ScheduleFile{
+		Holidays:  slices.Clone(s.file.Holidays),
+		Schedules: slices.Clone(s.file.Schedules),
+	}
+}
+
+// Set adds schedule, or replaces the one with the same name.
+func (s *Scheduler) Set(schedule Schedule) error {
+	s.Lock()
+	defer s.Unlock()
+	f := ScheduleFile{Holidays: s.file.Holidays, Schedules: slices.Clone(s.file.Schedules)}
+	if i := slices.IndexFunc(f.Schedules, func(x Schedule) bool { return x.Name == schedule.Name }); i >= 0 {
+		f.Schedules[i] = schedule
+	} else {
+		f.Schedules = append(f.Schedules, schedule)
+	}
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	s.file = f
+	return nil
+}
+
+// Remove removes the schedule called name, releasing its input on the next
+// step, and reports whether there was one.
+func (s *Scheduler) Remove(name string) bool {
+	s.Lock()
+	defer s.Unlock()
+	n := len(s.file.Schedules)
+	s.file.Schedules = slices.DeleteFunc(slices.Clone(s.file.Schedules), func(x Schedule) bool { return x.Name == name })
+	return len(s.file.Schedules) < n
+}
+
+// SetHolidays replaces the holidays.
+func (s *Scheduler) SetHolidays(dates ...string) error {
+	s.Lock()
+	defer s.Unlock()
+	f := ScheduleFile{Holidays: dates, Schedules: s.file.Schedules}
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	s.file = f
+	return nil
+}
+
+// writes value to input, activating its override, or releases it if value
+// is nil
+func (s *Scheduler) write(c *TestCase, input string, value *float64) error {
+	rec := AuditRecord{Caller: "schedule"}
+	overrides := strings.HasSuffix(input, "_"+SuffixOverride)
+	if value == nil {
+		if !overrides {
+			return nil
+		}
+		return c.setInput(activation(input), 0, rec)
+	}
+	if overrides {
+		if err := c.setInput(activation(input), 1, rec); err != nil {
+			return err
+		}
+	}
+	return c.setInput(input, *value, rec)
+}
+
+// Step writes the value of each schedule at the simulated time of c's state.
+func (s *Scheduler) Step(c *TestCase) error {
+	now, err := c.State.Time()
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := s.reload(); err != nil {
+		c.logger().Error("unable to reload schedules, using those loaded before", "path", s.path, "error", err)
+	}
+
+	written := s.written[c]
+	if written == nil {
+		written = make(map[string]*float64)
+		s.written[c] = written
+	}
+	var errs []error
+	scheduled := make(map[string]bool)
+	for _, schedule := range s.file.Schedules {
+		scheduled[schedule.Input] = true
+		v := schedule.value(now, s.file.Holidays)
+		last, ok := written[schedule.Input]
+		if ok && equalValues(last, v) && (c.writeMode != WriteOnce || v == nil) {
+			continue
+		}
+		if err := s.write(c, schedule.Input, v); err != nil {
+			errs = append(errs, fmt.Errorf("schedule %s: %w", schedule.Name, err))
+			continue
+		}
+		written[schedule.Input] = v
+	}
+	for input, v := range written {
+		if scheduled[input] {
+			continue
+		}
+		// the schedule was removed
+		if v != nil {
+			if err := s.write(c, input, nil); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		delete(written, input)
+	}
+	return errors.Join(errs...)
+}
+
+func equalValues(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (s *Scheduler) String() string {
+	if s.path != "" {
+		return "schedules " + s.path
+	}
+	return "schedules"
+}
@@ -0,0 +1,194 @@
+package boptest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ptr(v float64) *float64 { return &v }
+
+// the first Monday of month in the simulated year, at hour
+func monday(month time.Month, hour int) time.Time {
+	t := time.Date(time.Now().Year(), month, 1, hour, 0, 0, 0, time.Local)
+	for t.Weekday() != time.Monday {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+func testSchedules() ScheduleFile {
+	day := monday(time.March, 0).AddDate(0, 0, 2) // a Wednesday
+	return ScheduleFile{
+		Holidays: []string{day.Format("01-02")},
+		Schedules: []Schedule{
+			{
+				Name:    "heating",
+				Input:   "oveTSet_u",
+				Default: ptr(289.15),
+				Weekday: []ScheduleEntry{{At: "19:00", Value: ptr(289.15)}, {At: "07:00", Value: ptr(294.15)}},
+				Weekend: []ScheduleEntry{{At: "10:00", Value: ptr(292.15)}},
+				Exceptions: []ScheduleException{{
+					Date:    day.AddDate(0, 0, 1).Format(time.DateOnly),
+					Entries: []ScheduleEntry{{At: "08:30", Value: ptr(295.15)}},
+				}},
+			},
+			{
+				Name:    "pump",
+				Input:   "ovePum_u",
+				Weekday: []ScheduleEntry{{At: "07:00", Value: ptr(1)}, {At: "19:00"}},
+			},
+		},
+	}
+}
+
+func TestScheduleValue(t *testing.T) {
+	f := testSchedules()
+	heating, pump := f.Schedules[0], f.Schedules[1]
+	mon := monday(time.March, 0)
+	at := func(days int, clock string) time.Time {
+		seconds, err := parseTimeOfDay(clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mon.AddDate(0, 0, days).Add(time.Duration(seconds) * time.Second)
+	}
+	for _, c := range []struct {
+		name string
+		t    time.Time
+		want *float64
+	}{
+		{"before the first entry on Monday, from the weekend", at(0, "06:59"), ptr(292.15)},
+		{"occupied", at(0, "07:00"), ptr(294.15)},
+		{"set back", at(0, "19:00"), ptr(289.15)},
+		{"Tuesday night, from Monday", at(1, "03:00"), ptr(289.15)},
+		{"holiday on Wednesday, the weekend profile", at(2, "12:00"), ptr(292.15)},
+		{"exception on Thursday", at(3, "08:00"), ptr(292.15)},
+		{"exception on Thursday, after its entry", at(3, "09:00"), ptr(295.15)},
+		{"Saturday morning", at(5, "09:59:59"), ptr(289.15)},
+		{"Saturday", at(5, "10:00"), ptr(292.15)},
+	} {
+		if got := heating.value(c.t, f.Holidays); !equalValues(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, deref(got), deref(c.want))
+		}
+	}
+
+	if v := pump.value(at(0, "12:00"), f.Holidays); !equalValues(v, ptr(1)) {
+		t.Errorf("pump at noon is %v", deref(v))
+	}
+	if v := pump.value(at(0, "20:00"), f.Holidays); v != nil {
+		t.Errorf("pump not released in the evening: %v", *v)
+	}
+	if v := pump.value(monday(time.January, 0), nil); v != nil {
+		t.Errorf("pump without entries or a default is %v", *v)
+	}
+}
+
+func deref(v *float64) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func TestScheduleFileValidate(t *testing.T) {
+	err := ScheduleFile{
+		Holidays: []string{"12-32"},
+		Schedules: []Schedule{
+			{Name: "a", Input: "oveTSet_u", Weekday: []ScheduleEntry{{At: "7am"}}},
+			{Name: "a", Exceptions: []ScheduleException{{Date: "tomorrow", Entries: []ScheduleEntry{{At: "25:00"}}}}},
+			{Input: "ovePum_u"},
+		},
+	}.Validate()
+	for _, want := range []string{
+		"holidays[0]", "schedules[0].weekday[0].at", "schedules[1].name: duplicate", "schedules[1].input",
+		"schedules[1].exceptions[0].date", "schedules[1].exceptions[0].entries[0].at", "schedules[2].name",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v does not mention %s", err, want)
+		}
+	}
+	if err := (ScheduleFile{Holidays: []string{"02-29", "2025-11-27"}}).Validate(); err != nil {
+		t.Errorf("valid holidays: %v", err)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	if err := os.WriteFile(path, []byte(`{"schedules": [{"name": "heating", "input": "oveTSet_u", "default": 289.15,
+		"weekday": [{"at": "07:00", "value": 294.15}, {"at": "19:00", "value": 289.15}]}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	scheduler, err := LoadSchedules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := monday(time.March, 6).Add(30 * time.Minute)
+	testCase, err := NewTestCase("bestest_hydronic_heat_pump", WithPlant(newRCPlant(t, HVACHeatPump)),
+		WithStartTime(int(SimSeconds(start))), WithStep(900), WithController(scheduler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	if err := testCase.Start(); err != nil {
+		t.Fatal(err)
+	}
+	applied := func() map[string]any {
+		t.Helper()
+		if err := testCase.advanceOnce(); err != nil {
+			t.Fatal(err)
+		}
+		all := testCase.History.All()
+		return all[len(all)-1].Applied
+	}
+
+	// 06:30 to 06:45 and 06:45 to 07:00 set back since Friday, then occupied
+	for i, want := range []float64{289.15, 289.15, 294.15} {
+		if inputs := applied(); inputs["oveTSet_u"] != want || inputs["oveTSet_activate"] != 1 {
+			t.Fatalf("advance %d applied %v, want oveTSet_u=%v", i, inputs, want)
+		}
+	}
+
+	// edits apply from the next step
+	if err := scheduler.Set(Schedule{Name: "pump", Input: "ovePum_u", Default: ptr(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.Set(Schedule{Name: "pump", Weekday: []ScheduleEntry{{At: "noon"}}}); err == nil {
+		t.Errorf("expected an invalid schedule to be rejected")
+	}
+	applied()
+	if inputs := applied(); inputs["ovePum_u"] != 1.0 || inputs["oveTSet_u"] != 294.15 {
+		t.Errorf("applied %v after adding a schedule", inputs)
+	}
+	if !scheduler.Remove("pump") || scheduler.Remove("pump") {
+		t.Errorf("expected the schedule to be removed once")
+	}
+	applied()
+	if inputs := applied(); inputs["ovePum_activate"] != 0 {
+		t.Errorf("applied %v after removing a schedule, want the override released", inputs)
+	}
+
+	// the file changed
+	if err := os.WriteFile(path, []byte(`{"schedules": [{"name": "heating", "input": "oveTSet_u", "default": 291.15}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	applied()
+	if inputs := applied(); inputs["oveTSet_u"] != 291.15 {
+		t.Errorf("applied %v after the file changed", inputs)
+	}
+	if err := os.WriteFile(path, []byte(`{"schedules": [{"name": "heating"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, later.Add(time.Second), later.Add(time.Second))
+	if err := scheduler.Reload(); err == nil {
+		t.Errorf("expected an invalid file to fail to reload")
+	}
+	if s := scheduler.Schedules().Schedules; len(s) != 1 || s[0].Input != "oveTSet_u" {
+		t.Errorf("schedules after a failed reload are %v", s)
+	}
+}
@@ -2,13 +2,20 @@ package boptest
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
 	"net"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/jamesryancoleman/bos/common"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -21,138 +28,555 @@ func WithAddr(addr string) serverOption {
 	}
 }
 
+// the test case used for uris with an empty authority, e.g. boptest:///point
+func WithDefaultTestCase(name string) serverOption {
+	return func(s *Server) {
+		s.defaultCase = name
+	}
+}
+
+// serve over TLS using a PEM encoded certificate and key, reloaded when the
+// files change
+func WithTLS(certFile, keyFile string) serverOption {
+	return func(s *Server) {
+		s.certFile = certFile
+		s.keyFile = keyFile
+	}
+}
+
+// advance every test case once more on shutdown if it has pending writes
+func WithFinalAdvance() serverOption {
+	return func(s *Server) {
+		s.finalAdvance = true
+	}
+}
+
+// unary interceptors run, in order, around every RPC
+func WithInterceptors(interceptors ...grpc.UnaryServerInterceptor) serverOption {
+	return func(s *Server) {
+		s.interceptors = append(s.interceptors, interceptors...)
+	}
+}
+
+// emulate latency, failures and timeouts of the network between controllers
+// and the server, after any other interceptors
+func WithNetworkConditions(n NetworkConditions) serverOption {
+	return func(s *Server) {
+		s.network = &n
+	}
+}
+
+// log to l rather than the default logger, see slog.SetDefault. Test cases
+// keep their own, see WithLogger.
+func WithServerLogger(l *slog.Logger) serverOption {
+	return func(s *Server) {
+		s.log = l
+	}
+}
+
+// Server is a registry of named test cases. Requests are routed by the
+// authority of their uri, i.e. boptest://{testCase}/{point}.
 type Server struct {
 	common.UnimplementedDeviceControlServer
 
-	Addr     string
-	TestCase *TestCase
+	Addr string
+
+	testCases   map[string]*TestCase
+	defaultCase string
+	started     bool
+	sync.RWMutex
+
+	certFile string
+	keyFile  string
+	caFile   string // of client certificates, for mutual TLS
+
+	grpcServer   *grpc.Server
+	serveErr     chan error // receives the error Serve returned
+	finalAdvance bool
+
+	interceptors []grpc.UnaryServerInterceptor
+	network      *NetworkConditions // emulated if not nil
+	metrics      *Metrics           // exported if not nil
+	policy       *Policy            // authorizes reads and writes, everything if nil
+	audit        *AuditLog          // records every Set pair, nil for none
+	heartbeats   heartbeats         // of clients that declared an interval
+
+	health         *health.Server
+	healthInterval time.Duration
+	healthStop     chan struct{} // closed to stop checking health
+	reflection     bool
+
+	log *slog.Logger // the default logger if nil
+}
+
+func (s *Server) logger() *slog.Logger {
+	return orDefault(s.log)
 }
 
+// NewServer returns a server that listens on listenAddr. testCase may be nil,
+// otherwise it is registered under its name and used as the default.
 func NewServer(listenAddr string, testCase *TestCase, opts ...serverOption) *Server {
 	var s Server
 	s.Addr = listenAddr
+	s.testCases = make(map[string]*TestCase)
+	s.healthInterval = DefaultHealthInterval
+	if testCase != nil {
+		s.testCases[testCase.Name] = testCase
+		s.defaultCase = testCase.Name
+		testCase.registered.Store(&testCase.Name)
+	}
 	// apply optional parameters
 	for _, opt := range opts {
 		opt(&s)
 	}
+	if s.metrics != nil && testCase != nil {
+		testCase.metrics.Store(s.metrics.testCase(testCase.Name))
+	}
+	if s.audit != nil && testCase != nil {
+		testCase.audit.CompareAndSwap(nil, s.audit)
+	}
 
-	s.TestCase = testCase
 	return &s
 }
 
-func (s *Server) Start() error {
-	// start the simulation up the simulation
-	err := s.TestCase.Start()
+// AddTestCase registers testCase under name. If the server has already been
+// started the test case is started too.
+func (s *Server) AddTestCase(name string, testCase *TestCase) error {
+	s.Lock()
+	_, exists := s.testCases[name]
+	started := s.started
+	s.Unlock()
+	if exists {
+		return fmt.Errorf("test case %q already exists", name)
+	}
+
+	testCase.registered.Store(&name)
+	if started {
+		// outside the lock, initializing and warming up takes as long as the
+		// simulation does
+		if err := testCase.Start(); err != nil {
+			return err
+		}
+	}
+
+	s.Lock()
+	if _, ok := s.testCases[name]; ok {
+		// taken while the test case was starting
+		s.Unlock()
+		if started {
+			testCase.Stop()
+		}
+		return fmt.Errorf("test case %q already exists", name)
+	}
+	defer s.Unlock()
+	s.testCases[name] = testCase
+	if s.metrics != nil {
+		testCase.metrics.Store(s.metrics.testCase(name))
+	}
+	if s.audit != nil {
+		testCase.audit.CompareAndSwap(nil, s.audit)
+	}
+	if s.defaultCase == "" {
+		s.defaultCase = name
+	}
+	s.logger().Info("added test case", "name", name, "id", testCase.ID)
+	return nil
+}
+
+// CreateTestCase selects a new boptest test case and registers it under name.
+func (s *Server) CreateTestCase(name, testcase string, opts ...testCaseOption) (*TestCase, error) {
+	if _, ok := s.TestCase(name); ok {
+		return nil, fmt.Errorf("test case %q already exists", name)
+	}
+	testCase, err := NewTestCase(testcase, opts...)
 	if err != nil {
-		FileLog.Error(err.Error())
-		return err
+		if testCase != nil {
+			// selected, but failed to begin
+			testCase.Stop()
+		}
+		return nil, err
+	}
+	err = s.AddTestCase(name, testCase)
+	if err != nil {
+		testCase.Stop()
+		return nil, err
+	}
+	return testCase, nil
+}
+
+// StopTestCase stops the test case registered under name and removes it.
+func (s *Server) StopTestCase(name string) error {
+	s.Lock()
+	testCase, ok := s.testCases[name]
+	delete(s.testCases, name)
+	if s.defaultCase == name {
+		s.defaultCase = ""
+	}
+	s.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown test case %q", name)
+	}
+	testCase.Stop()
+	if s.metrics != nil {
+		s.metrics.forget(name)
+	}
+	if s.health != nil {
+		s.health.SetServingStatus(name, healthpb.HealthCheckResponse_SERVICE_UNKNOWN)
+	}
+	s.logger().Info("removed test case", "name", name, "id", testCase.ID)
+	return nil
+}
+
+// returns the test case registered under name, the empty name is the default
+func (s *Server) TestCase(name string) (*TestCase, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	if name == "" {
+		name = s.defaultCase
+	}
+	testCase, ok := s.testCases[name]
+	return testCase, ok
+}
+
+// returns the names of the registered test cases
+func (s *Server) TestCases() []string {
+	s.RLock()
+	defer s.RUnlock()
+	return slices.Sorted(maps.Keys(s.testCases))
+}
+
+func (s *Server) Start() error {
+	// start the simulations up
+	s.Lock()
+	for name, testCase := range s.testCases {
+		err := testCase.Start()
+		if err != nil {
+			s.Unlock()
+			s.logger().Error(err.Error(), "test_case", name)
+			return err
+		}
 	}
+	s.started = true
+	s.Unlock()
 
 	// server set up
 	lis, err := net.Listen("tcp", s.Addr)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		s.logger().Error("failed to listen", "error", err)
 		return err
 	}
+	s.Addr = lis.Addr().String() // resolves port 0
 
 	// create the grpc server
-	server := grpc.NewServer()
-	common.RegisterDeviceControlServer(server, s)
+	var opts []grpc.ServerOption
+	if s.caFile != "" && s.certFile == "" {
+		lis.Close()
+		return fmt.Errorf("mutual tls requires a server certificate")
+	}
+	if s.certFile != "" {
+		creds, err := newReloadingCredentials(s.certFile, s.keyFile, s.caFile, s.log)
+		if err != nil {
+			lis.Close()
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	interceptors := s.interceptors
+	if s.metrics != nil {
+		// outermost, so that emulated latency and failures are measured
+		interceptors = append([]grpc.UnaryServerInterceptor{s.metrics.Interceptor()}, interceptors...)
+	}
+	if s.network != nil {
+		emulator, err := NewNetworkEmulator(*s.network)
+		if err != nil {
+			lis.Close()
+			return err
+		}
+		emulator.log = s.log
+		interceptors = append(slices.Clone(interceptors), emulator.Interceptors()...)
+	}
+	if len(interceptors) > 0 {
+		opts = append(opts, grpc.ChainUnaryInterceptor(interceptors...))
+	}
+	// before the grpc server, so that a failed Start leaves no server for
+	// Shutdown to wait on
+	if s.metrics != nil && s.metrics.Addr != "" {
+		if err := s.metrics.Start(); err != nil {
+			lis.Close()
+			return err
+		}
+	}
+	grpcServer := grpc.NewServer(opts...)
+	common.RegisterDeviceControlServer(grpcServer, s)
+
+	// report the liveness of the test cases over grpc.health.v1
+	s.health = health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, s.health)
+	s.checkHealth(s.health)
+	healthStop := make(chan struct{})
+	go s.watchHealth(s.health, s.healthInterval, healthStop)
+
+	if s.reflection {
+		reflection.Register(grpcServer)
+	}
 
 	// start the blocking gRPC server in a go routine
+	serveErr := make(chan error, 1)
 	go func() {
-		if err := server.Serve(lis); err != nil {
-			log.Fatalf("failed to serve: %v", err)
+		err := grpcServer.Serve(lis)
+		if err != nil {
+			s.logger().Error("failed to serve", "error", err)
 		}
+		serveErr <- err
 	}()
+	s.Lock()
+	s.grpcServer, s.serveErr, s.healthStop = grpcServer, serveErr, healthStop
+	s.Unlock()
 
 	// log successs
-	common.TermLog.Info("server started", "listenAddr", s.Addr)
-	common.FileLog.Info("server started", "listen_addr", s.Addr)
+	s.logger().Info("server started", "listen_addr", s.Addr)
 
 	return nil
 }
 
-func (s *Server) Get(ctx context.Context, req *common.GetRequest) (*common.GetResponse, error) {
-	header := req.GetHeader()
-	header.Dst = header.GetSrc()
-	header.Src = header.GetDst()
+// Shutdown stops accepting RPCs and waits for those in flight to finish, then
+// applies pending writes if WithFinalAdvance was given and stops every test
+// case. If ctx expires first the remaining RPCs are cancelled. Every error encountered is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	// taken under the lock, so that a second Shutdown finds nothing to stop
+	s.Lock()
+	grpcServer, serveErr, healthStop := s.grpcServer, s.serveErr, s.healthStop
+	s.grpcServer, s.serveErr, s.healthStop = nil, nil, nil
+	s.Unlock()
+
+	if healthStop != nil {
+		// probes see the server as not serving while rpcs drain
+		close(healthStop)
+		s.health.Shutdown()
+	}
+
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+			errs = append(errs, fmt.Errorf("rpcs cancelled: %w", ctx.Err()))
+		}
+		if err := <-serveErr; err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if s.metrics != nil {
+		errs = append(errs, s.metrics.Shutdown(ctx))
+	}
+	s.stopHeartbeats()
+
+	s.Lock()
+	testCases := maps.Clone(s.testCases)
+	clear(s.testCases)
+	s.started = false
+	s.Unlock()
+
+	// ctx may be used up draining rpcs, the test cases are stopped regardless
+	stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultShutdownTimeout*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for name, testCase := range testCases {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if s.finalAdvance {
+				err = testCase.Drain()
+			}
+			err = errors.Join(err, testCase.Shutdown(stopCtx))
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("test case %q: %w", name, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.logger().Info("server stopped", "listen_addr", s.Addr)
 
-	// set the header time based on the state machine
-	t, err := s.TestCase.State.Time()
-	fmt.Printf("simulation time %s\n", t.Format(time.RFC3339))
+	return errors.Join(errs...)
+}
+
+// resolves a boptest:// uri to its test case and point name
+func (s *Server) route(uri string) (*TestCase, string, error) {
+	matches := schemaRe.FindStringSubmatch(uri)
+	if matches == nil {
+		return nil, "", fmt.Errorf("invalid uri %q", uri)
+	}
+	name := matches[schemaRe.SubexpIndex("testCase")]
+	testCase, ok := s.TestCase(name)
+	if !ok {
+		return nil, "", fmt.Errorf("unknown test case %q", name)
+	}
+	return testCase, matches[schemaRe.SubexpIndex("point")], nil
+}
+
+// returns the current simulated time of a test case, or now if it has none
+func simulationTime(testCase *TestCase) time.Time {
+	t, err := testCase.State.Time()
 	if err != nil {
-		TermLog.Warn("no time in State map")
-		t = time.Now()
+		testCase.logger().Warn("no time in State map", "test_case", testCase.Name)
+		return time.Now()
 	}
-	header.Time = timestamppb.New(t)
+	return t
+}
+
+func (s *Server) Get(ctx context.Context, req *common.GetRequest) (*common.GetResponse, error) {
+	caller, err := s.policy.caller(ctx, req.GetHeader())
+	if err != nil {
+		return nil, err
+	}
+
+	header := req.GetHeader()
+	if header == nil {
+		header = &common.Header{}
+	}
+	header.Dst, header.Src = header.GetSrc(), header.GetDst()
 
-	// extract keys from uri strings
 	keys := req.GetKeys()
-	TermLog.Info(fmt.Sprintf("received keys: %v", keys))
-	var points []string
-	pointToUri := make(map[string]string, len(keys))
-	for _, k := range keys {
-		p := schemaRe.FindStringSubmatch(k)[2]
-		points = append(points, p)
-		pointToUri[p] = k
-	}
-
-	// get the state from the simulation
-	m := s.TestCase.State.GetMultiple(points)
-
-	// package the results
-	pairs := make([]*common.GetPair, len(points))
-	var i int
-	for p, v := range m {
-		if u, ok := pointToUri[p]; ok {
-			pairs[i] = &common.GetPair{
-				Key:   u,
-				Value: fmt.Sprintf("%v", v),
-				Time:  timestamppb.New(t),
-			}
+	s.logger().Info(fmt.Sprintf("received keys: %v", keys))
+
+	// the header time is that of the first test case in the request
+	var headerTime *time.Time
+
+	pairs := make([]*common.GetPair, len(keys))
+	for i, k := range keys {
+		testCase, point, err := s.route(k)
+		if err != nil {
+			pairs[i] = getErrorPair(k, err)
+			continue
+		}
+		if err := s.policy.CanRead(caller, s.policyPoint(k)); err != nil {
+			pairs[i] = getErrorPair(k, err)
+			continue
+		}
+
+		t := simulationTime(testCase)
+		if headerTime == nil {
+			headerTime = &t
+		}
+
+		// get the state from the simulation, as seen through any sensor faults
+		v, err := testCase.Read(point)
+		if err != nil {
+			pairs[i] = getErrorPair(k, err)
+			continue
+		}
+		pairs[i] = &common.GetPair{
+			Key:   k,
+			Value: fmt.Sprintf("%v", v),
+			Time:  timestamppb.New(t),
 		}
-		i++
 	}
 
-	fmt.Printf("header time %s\n", header.Time.AsTime().Format(time.RFC3339))
+	if headerTime == nil {
+		now := time.Now()
+		headerTime = &now
+	}
+	header.Time = timestamppb.New(*headerTime)
 
-	// fetch values from simulation
 	return &common.GetResponse{
 		Header: header,
 		Pairs:  pairs,
 	}, nil
 }
 
+func getErrorPair(key string, err error) *common.GetPair {
+	errMsg := err.Error()
+	return &common.GetPair{
+		Key:      key,
+		Error:    common.GetError_GET_ERROR_UNSPECIFIED.Enum(),
+		ErrorMsg: &errMsg,
+	}
+}
+
+func setErrorPair(pair *common.SetPair, err error) *common.SetPair {
+	errMsg := err.Error()
+	return &common.SetPair{
+		Key:      pair.GetKey(),
+		Value:    pair.GetValue(),
+		Error:    common.SetError_SET_ERROR_UNSPECIFIED.Enum(),
+		ErrorMsg: &errMsg,
+	}
+}
+
 func (s *Server) Set(ctx context.Context, req *common.SetRequest) (*common.SetResponse, error) {
+	caller, err := s.policy.caller(ctx, req.GetHeader())
+	if err != nil {
+		return nil, err
+	}
+	interval, declared, err := heartbeatInterval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	header := req.GetHeader()
-	header.Dst = header.GetSrc()
-	header.Src = header.GetDst()
+	if header == nil {
+		header = &common.Header{}
+	}
+	rec := AuditRecord{
+		Caller:   caller.String(),
+		Identity: caller.Identity,
+		Src:      header.GetSrc(),
+		Dst:      header.GetDst(),
+	}
+	header.Dst, header.Src = header.GetSrc(), header.GetDst()
 
 	// TODO: confirm if setting a time is necessary
 
-	// extract keys, convert to internal name, and reassociate with values
 	pairs := req.GetPairs()
-	TermLog.Info("set request received", "num_pairs", len(pairs))
-
-	// create a look up table to convert external to internal keys
-	externalToInternal := make(map[string]string, len(pairs))
+	s.logger().Info("set request received", "num_pairs", len(pairs))
 
-	payload := make(map[string]string, len(pairs))
-	for i := range pairs {
-		external := pairs[i].GetKey()
-		internal := schemaRe.FindStringSubmatch(external)[2]
-		payload[internal] = pairs[i].GetValue()
-		externalToInternal[external] = internal
+	results := make([]*common.SetPair, len(pairs))
+	written := make(map[*TestCase][]string)
+	for i, pair := range pairs {
+		// extract keys, convert to internal name and route to the test case
+		rec.Key, rec.Point, rec.Value = pair.GetKey(), "", pair.GetValue()
+		testCase, point, err := s.route(pair.GetKey())
+		if err != nil {
+			s.auditRejected(nil, rec, err)
+			results[i] = setErrorPair(pair, err)
+			continue
+		}
+		rec.Point = point
+		if err := s.policy.CanWrite(caller, s.policyPoint(pair.GetKey()), pair.GetValue()); err != nil {
+			s.logger().Warn("write denied", "key", pair.GetKey(), "value", pair.GetValue(), "caller", caller.String())
+			s.auditRejected(testCase, rec, err)
+			results[i] = setErrorPair(pair, err)
+			continue
+		}
 
 		// write to the simulation
-		s.TestCase.SetInput(internal, pairs[i].GetValue())
+		if err := testCase.setInput(point, pair.GetValue(), rec); err != nil {
+			results[i] = setErrorPair(pair, err)
+			continue
+		}
+		written[testCase] = append(written[testCase], point)
+		results[i] = pair
 	}
+	s.beat(caller.client(), interval, declared, written)
 
 	// return
 	return &common.SetResponse{
 		Header: header,
-		Pairs:  pairs,
+		Pairs:  results,
 	}, nil
 }
 
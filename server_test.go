@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"testing"
 	"time"
 
@@ -16,11 +18,11 @@ func TestStartServer(t *testing.T) {
 	// create boptest test case
 	testCase, err := NewTestCase(testcase,
 		WithStartTime(3600*24*31),
-		WithStep(2),              // seconds
-		WithHost("0.0.0.0:1025"), // the boptest docker container
+		WithStep(2), // seconds
+		WithHost(host),
 	)
 	if err != nil {
-		FileLog.Error(err.Error())
+		slog.Error(err.Error())
 	}
 	defer testCase.Stop()
 
@@ -55,13 +57,13 @@ func TestGetRpc(t *testing.T) {
 
 	// create boptest test case
 	testCase, err := NewTestCase(testcase,
-		WithHost("0.0.0.0:1025"), // the boptest docker container
+		WithHost(host),
 		WithStartTime(3600*24*31),
 		WithStep(60*15), // seconds
 		WithStartNow(),
 	)
 	if err != nil {
-		FileLog.Error(err.Error())
+		slog.Error(err.Error())
 	}
 	defer testCase.Stop()
 
@@ -86,10 +88,10 @@ func TestGetRpc(t *testing.T) {
 		for {
 			select {
 			case <-after:
-				TermLog.Info("test completed")
+				slog.Info("test completed")
 				return
 			default:
-				TermLog.Info("tick")
+				slog.Info("tick")
 				r, err := c.Get(ctx, &common.GetRequest{
 					Header: &common.Header{Src: "test.local", Dst: s.Addr},
 					Keys:   points})
@@ -144,13 +146,13 @@ func TestSetRpc(t *testing.T) {
 
 	// create boptest test case
 	testCase, err := NewTestCase(testcase,
-		WithHost("0.0.0.0:1025"), // the boptest docker container
+		WithHost(host),
 		WithStartTime(0),
 		WithStep(60*15), // seconds
 		WithStartNow(),
 	)
 	if err != nil {
-		FileLog.Error(err.Error())
+		slog.Error(err.Error())
 	}
 	defer testCase.Stop()
 
@@ -178,7 +180,7 @@ func TestSetRpc(t *testing.T) {
 		for {
 			select {
 			case <-timeout:
-				TermLog.Info("test completed")
+				slog.Info("test completed")
 				return
 			case <-action1:
 				r, err := c.Set(ctx, &common.SetRequest{
@@ -198,7 +200,7 @@ func TestSetRpc(t *testing.T) {
 					}
 				}
 			case <-ticker.C:
-				TermLog.Info("tick")
+				slog.Info("tick")
 				r, err := c.Get(ctx, &common.GetRequest{
 					Header: &common.Header{Src: "test.local", Dst: s.Addr},
 					Keys:   readPts})
@@ -218,3 +220,261 @@ func TestSetRpc(t *testing.T) {
 		}
 	}()
 }
+
+// a test case that is never selected on a boptest server
+func offlineTestCase(name string, state map[string]any) *TestCase {
+	testCase := &TestCase{Name: name}
+	testCase.State.SetAll(state)
+	return testCase
+}
+
+func TestServerRouting(t *testing.T) {
+	a := offlineTestCase("bestest_air", map[string]any{"time": 0.0, "zon_reaTRooAir_y": 294.0})
+	b := offlineTestCase("bestest_hydronic", map[string]any{"time": 3600.0, "reaTZon_y": 291.0})
+
+	s := NewServer("0.0.0.0:50070", a)
+	err := s.AddTestCase("hydronic", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTestCase("hydronic", b); err == nil {
+		t.Errorf("expected an error registering a name twice")
+	}
+	if names := s.TestCases(); len(names) != 2 {
+		t.Errorf("got test cases %v", names)
+	}
+
+	r, err := s.Get(context.Background(), &common.GetRequest{
+		Header: &common.Header{Src: "test.local", Dst: s.Addr},
+		Keys: []string{
+			"boptest:///zon_reaTRooAir_y",
+			"boptest://hydronic/reaTZon_y",
+			"boptest://bestest_air/reaTZon_y",
+			"boptest://campus/zon_reaTRooAir_y",
+			"https://google.com",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs := r.GetPairs()
+	if pairs[0].GetValue() != "294" || pairs[0].GetError() != 0 {
+		t.Errorf("default test case: got %v", pairs[0])
+	}
+	if pairs[1].GetValue() != "291" || !pairs[1].Time.AsTime().Equal(SimTime(3600)) {
+		t.Errorf("named test case: got %v", pairs[1])
+	}
+	for _, p := range pairs[2:] {
+		if p.ErrorMsg == nil {
+			t.Errorf("expected an error for %s", p.GetKey())
+		}
+	}
+
+	sr, err := s.Set(context.Background(), &common.SetRequest{
+		Header: &common.Header{Src: "test.local", Dst: s.Addr},
+		Pairs: []*common.SetPair{
+			{Key: "boptest://hydronic/oveTSet_u", Value: "295"},
+			{Key: "boptest://campus/oveTSet_u", Value: "295"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.GetPairs()[0].ErrorMsg != nil || sr.GetPairs()[1].ErrorMsg == nil {
+		t.Errorf("unexpected set pairs %v", sr.GetPairs())
+	}
+	if v := b.writeBuffer.GetAll()["oveTSet_u"]; v != "295" {
+		t.Errorf("got %v in the write buffer of the routed test case", v)
+	}
+	if len(a.writeBuffer.GetAll()) != 0 {
+		t.Errorf("write was routed to the default test case")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	s := NewServer("127.0.0.1:0", nil)
+	err := s.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := grpc.NewClient(s.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := common.NewDeviceControlClient(conn)
+
+	_, err = c.Get(context.Background(), &common.GetRequest{Keys: []string{"boptest:///time"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Get(ctx, &common.GetRequest{Keys: []string{"boptest:///time"}})
+	if err == nil {
+		t.Errorf("expected an error after shutdown")
+	}
+
+	// a second shutdown has nothing left to stop
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("second shutdown: %v", err)
+	}
+}
+
+func TestServerStartFailed(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	s := NewServer("127.0.0.1:0", nil, WithMetrics(NewMetrics(taken.Addr().String())))
+	if err := s.Start(); err == nil {
+		t.Fatal("expected an error with the metrics address in use")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown blocked after a failed Start")
+	}
+}
+
+func TestServerGetSetFake(t *testing.T) {
+	fake := startFake(t)
+	testCase, err := NewTestCase(testcase, WithHost(fake.Host()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testCase.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer("127.0.0.1:0", testCase)
+	ctx := context.Background()
+	defer s.Shutdown(ctx)
+
+	set, err := s.Set(ctx, &common.SetRequest{Pairs: []*common.SetPair{
+		{Key: "boptest://bestest_air/con_oveTSetHea_activate", Value: "1"},
+		{Key: "boptest://bestest_air/con_oveTSetHea_u", Value: "298.15"},
+		{Key: "boptest://other/con_oveTSetHea_u", Value: "298.15"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range set.GetPairs() {
+		if failed := p.Error != nil; failed != (i == 2) {
+			t.Errorf("pair %d: error %v %q", i, p.GetError(), p.GetErrorMsg())
+		}
+	}
+
+	err = testCase.Drain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get, err := s.Get(ctx, &common.GetRequest{Keys: []string{
+		"boptest:///zon_reaTRooAir_y",
+		"boptest://bestest_air/time",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"298.15", "3600"}
+	for i, p := range get.GetPairs() {
+		if p.GetValue() != want[i] {
+			t.Errorf("%s = %q, want %q", p.GetKey(), p.GetValue(), want[i])
+		}
+	}
+	if got := get.GetHeader().GetTime().AsTime(); !got.Equal(SimTime(3600)) {
+		t.Errorf("header time is %v, want %v", got, SimTime(3600))
+	}
+}
+
+func TestServerShutdownExpired(t *testing.T) {
+	fake := startFake(t)
+	testCase, err := NewTestCase(testcase, WithHost(fake.Host()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("127.0.0.1:0", testCase)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// used up, e.g. waiting for rpcs to drain
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Shutdown(ctx)
+	if fake.Running(testCase.ID) {
+		t.Errorf("%s still running after shutdown", testCase.ID)
+	}
+}
+
+// a Backend on RC plants whose Initialize waits until released
+type slowInitBackend struct {
+	*PlantBackend
+	release chan struct{}
+}
+
+func (b *slowInitBackend) Initialize(id string, start, warmup int) (map[string]any, error) {
+	<-b.release
+	return b.PlantBackend.Initialize(id, start, warmup)
+}
+
+func TestAddTestCaseStarting(t *testing.T) {
+	b := &slowInitBackend{
+		PlantBackend: NewPlantBackend(func(testcase string) (Plant, error) {
+			return NewRCPlant(DefaultRCConfig(HVACFor(testcase)))
+		}),
+		release: make(chan struct{}),
+	}
+	air := offlineTestCase("bestest_air", map[string]any{"time": 0.0, "zon_reaTRooAir_y": 294.0})
+	s := NewServer("127.0.0.1:0", air)
+	s.started = true // without listening
+	slow, err := NewTestCase("bestest_hydronic_heat_pump", WithBackend(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Stop()
+
+	added := make(chan error)
+	go func() { added <- s.AddTestCase("hp", slow) }()
+
+	// the server answers while the test case warms up
+	time.Sleep(20 * time.Millisecond)
+	r, err := s.Get(context.Background(), &common.GetRequest{Keys: []string{"boptest:///zon_reaTRooAir_y"}})
+	if err != nil || r.GetPairs()[0].GetValue() != "294" {
+		t.Errorf("got %v, %v while adding a test case", r, err)
+	}
+	if _, ok := s.TestCase("hp"); ok {
+		t.Errorf("test case registered before it started")
+	}
+	// a name taken while the test case started is not overwritten
+	s.Lock()
+	s.testCases["hp"] = offlineTestCase("bestest_hydronic", nil)
+	s.Unlock()
+
+	close(b.release)
+	if err := <-added; err == nil {
+		t.Errorf("expected an error adding a test case under a name taken while it started")
+	}
+	if testCase, _ := s.TestCase("hp"); testCase == slow {
+		t.Errorf("test case registered over the one added while it started")
+	}
+	if slow.Stopped.IsZero() {
+		t.Errorf("test case started for a name that was taken is still running")
+	}
+}
@@ -0,0 +1,251 @@
+package boptest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SeriesFormat is the file format of a SeriesRecorder.
+type SeriesFormat string
+
+const (
+	SeriesCSV    SeriesFormat = "csv"    // one row per point: time,wall,test_case,id,kind,point,value
+	SeriesJSONL  SeriesFormat = "jsonl"  // one object per advance
+	SeriesInflux SeriesFormat = "influx" // InfluxDB line protocol, one line each for inputs, commanded inputs and state
+)
+
+const DefaultSeriesFiles = 5 // rotated files kept
+
+var seriesCSVHeader = []string{"time", "wall", "test_case", "id", "kind", "point", "value"}
+
+// SeriesConfig describes where and how a SeriesRecorder writes.
+type SeriesConfig struct {
+	Path     string       `json:"path" yaml:"path"`
+	Format   SeriesFormat `json:"format" yaml:"format"`       // csv, jsonl or influx
+	Points   []string     `json:"points" yaml:"points"`       // path.Match patterns of the points to record, all if empty
+	MaxBytes int64        `json:"max_bytes" yaml:"max_bytes"` // rotate the file beyond this size, 0 for never
+	MaxFiles int          `json:"max_files" yaml:"max_files"` // rotated files kept, DefaultSeriesFiles if 0
+}
+
+func (c SeriesConfig) Validate() error {
+	var errs []error
+	if c.Path == "" {
+		errs = append(errs, fmt.Errorf("path is required"))
+	}
+	switch c.Format {
+	case SeriesCSV, SeriesJSONL, SeriesInflux:
+	default:
+		errs = append(errs, fmt.Errorf("format must be %q, %q or %q, not %q", SeriesCSV, SeriesJSONL, SeriesInflux, c.Format))
+	}
+	for _, p := range c.Points {
+		if _, err := path.Match(p, ""); err != nil {
+			errs = append(errs, fmt.Errorf("point %q: %w", p, err))
+		}
+	}
+	if c.MaxBytes < 0 || c.MaxFiles < 0 {
+		errs = append(errs, fmt.Errorf("max_bytes and max_files must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// SeriesRecord is one advance of a test case: the inputs sent and the state
+// returned.
+type SeriesRecord struct {
+	TestCase string         `json:"test_case"`
+	ID       string         `json:"id"`
+	Time     float64        `json:"time"` // simulated seconds since the start of the year
+	Wall     time.Time      `json:"wall"`
+	Inputs   map[string]any `json:"inputs"`
+	State    map[string]any `json:"state"`
+
+	// the inputs as written by clients, if safety rules or actuator faults
+	// changed them before they were sent
+	Commanded map[string]any `json:"commanded,omitempty"`
+}
+
+// SeriesRecorder appends the inputs and state of every advance of the test
+// cases it is given to a rolling file. It may be shared by test cases. A
+// nil SeriesRecorder records nothing.
+type SeriesRecorder struct {
+	config SeriesConfig
+	file   *rotatingFile
+}
+
+// NewSeriesRecorder opens, or creates, the file at config.Path for appending.
+func NewSeriesRecorder(config SeriesConfig) (*SeriesRecorder, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.MaxFiles == 0 {
+		config.MaxFiles = DefaultSeriesFiles
+	}
+	var header []byte
+	if config.Format == SeriesCSV {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write(seriesCSVHeader)
+		w.Flush()
+		header = buf.Bytes()
+	}
+	file, err := openRotatingFile(config.Path, config.MaxBytes, config.MaxFiles, header)
+	if err != nil {
+		return nil, err
+	}
+	return &SeriesRecorder{config: config, file: file}, nil
+}
+
+// true if point is selected for recording
+func (r *SeriesRecorder) selected(point string) bool {
+	if point == "time" {
+		return false // recorded as the timestamp
+	}
+	if len(r.config.Points) == 0 {
+		return true
+	}
+	for _, p := range r.config.Points {
+		if ok, _ := path.Match(p, point); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the selected points of m
+func (r *SeriesRecorder) filter(m map[string]any) map[string]any {
+	selected := make(map[string]any, len(m))
+	for k, v := range m {
+		if r.selected(k) {
+			selected[k] = v
+		}
+	}
+	return selected
+}
+
+// Record appends rec, rotating the file first if it would grow beyond
+// MaxBytes.
+func (r *SeriesRecorder) Record(rec SeriesRecord) error {
+	if r == nil {
+		return nil
+	}
+	rec.Inputs = r.filter(rec.Inputs)
+	rec.State = r.filter(rec.State)
+	rec.Commanded = r.filter(rec.Commanded)
+
+	var b []byte
+	var err error
+	switch r.config.Format {
+	case SeriesCSV:
+		b, err = seriesCSV(rec)
+	case SeriesJSONL:
+		b, err = json.Marshal(rec)
+		b = append(b, '\n')
+	case SeriesInflux:
+		b = seriesInflux(rec)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = r.file.Write(b)
+	return err
+}
+
+// Close closes the file. Later records fail.
+func (r *SeriesRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// returns the points of rec of kind input, commanded or state
+func (rec SeriesRecord) of(kind string) map[string]any {
+	switch kind {
+	case "input":
+		return rec.Inputs
+	case "commanded":
+		return rec.Commanded
+	}
+	return rec.State
+}
+
+func seriesCSV(rec SeriesRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	t := strconv.FormatFloat(rec.Time, 'f', -1, 64)
+	wall := rec.Wall.Format(time.RFC3339Nano)
+	for _, kind := range []string{"input", "commanded", "state"} {
+		m := rec.of(kind)
+		for _, point := range slices.Sorted(maps.Keys(m)) {
+			w.Write([]string{t, wall, rec.TestCase, rec.ID, kind, point, fmt.Sprintf("%v", m[point])})
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+var (
+	// escapes commas, spaces and equals signs in measurements, tags and field keys
+	influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+	// escapes string field values
+	influxQuoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// formats a field value: numbers as floats, bools as is and anything else,
+// unless it parses as a number, as a quoted string
+func influxValue(v any) string {
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		return `"` + influxQuoter.Replace(v) + `"`
+	}
+	if f, err := InputValue(v); err == nil {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return `"` + influxQuoter.Replace(fmt.Sprintf("%v", v)) + `"`
+}
+
+// one line each for the inputs and commanded inputs, if any, and the state,
+// timestamped with the simulated time and with the wall clock time as a field
+func seriesInflux(rec SeriesRecord) []byte {
+	var buf bytes.Buffer
+	ts := SimTime(rec.Time).UnixNano()
+	for _, kind := range []string{"input", "commanded", "state"} {
+		m := rec.of(kind)
+		if kind != "state" && len(m) == 0 {
+			continue
+		}
+		buf.WriteString("boptest")
+		for _, tag := range [][2]string{{"test_case", rec.TestCase}, {"id", rec.ID}, {"kind", kind}} {
+			if tag[1] != "" { // tags must not be empty
+				fmt.Fprintf(&buf, ",%s=%s", tag[0], influxEscaper.Replace(tag[1]))
+			}
+		}
+		buf.WriteByte(' ')
+		for _, point := range slices.Sorted(maps.Keys(m)) {
+			fmt.Fprintf(&buf, "%s=%s,", influxEscaper.Replace(point), influxValue(m[point]))
+		}
+		fmt.Fprintf(&buf, "wall=%di %d\n", rec.Wall.UnixNano(), ts)
+	}
+	return buf.Bytes()
+}
+
+// record every advance of the test case with r
+func WithSeriesRecorder(r *SeriesRecorder) testCaseOption {
+	return func(c *TestCase) {
+		c.series = r
+	}
+}
@@ -0,0 +1,206 @@
+package boptest
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSeriesRecorder(t *testing.T, config SeriesConfig) *SeriesRecorder {
+	t.Helper()
+	config.Path = filepath.Join(t.TempDir(), "series."+string(config.Format))
+	r, err := NewSeriesRecorder(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func seriesRecord(seconds float64) SeriesRecord {
+	return SeriesRecord{
+		TestCase: "bestest_air",
+		ID:       "abc",
+		Time:     seconds,
+		Wall:     time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		Inputs:   map[string]any{"fcu_oveFan_u": "0.5", "fcu_oveFan_activate": "1"},
+		State:    map[string]any{"time": seconds, "zon_reaTRooAir_y": 294.5, "fcu_oveFan_u": 0.5},
+	}
+}
+
+func TestSeriesRecorder(t *testing.T) {
+	points := []string{"zon_*", "*_u"}
+
+	r := newSeriesRecorder(t, SeriesConfig{Format: SeriesCSV, Points: points})
+	for _, s := range []float64{3600, 7200} {
+		if err := r.Record(seriesRecord(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(r.config.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 7 || strings.Join(rows[0], ",") != "time,wall,test_case,id,kind,point,value" {
+		t.Fatalf("rows are %v", rows)
+	}
+	want := "3600,2026-10-18T12:00:00Z,bestest_air,abc,input,fcu_oveFan_u,0.5"
+	if got := strings.Join(rows[1], ","); got != want {
+		t.Errorf("first row is %s, want %s", got, want)
+	}
+	if got := strings.Join(rows[3], ","); !strings.HasSuffix(got, "state,zon_reaTRooAir_y,294.5") {
+		t.Errorf("third row is %s", got)
+	}
+
+	r = newSeriesRecorder(t, SeriesConfig{Format: SeriesJSONL, Points: points})
+	if err := r.Record(seriesRecord(3600)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(r.config.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec SeriesRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Time != 3600 || len(rec.Inputs) != 1 || len(rec.State) != 2 || rec.State["zon_reaTRooAir_y"] != 294.5 {
+		t.Errorf("recorded %+v", rec)
+	}
+
+	r = newSeriesRecorder(t, SeriesConfig{Format: SeriesInflux})
+	rec = seriesRecord(3600)
+	rec.State["name"] = `say "hi"`
+	rec.Commanded = map[string]any{"fcu_oveFan_u": "0.8"}
+	if err := r.Record(rec); err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.ReadFile(r.config.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := SimTime(3600).UnixNano()
+	wall := rec.Wall.UnixNano()
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	for i, want := range []string{
+		fmt.Sprintf("boptest,test_case=bestest_air,id=abc,kind=input fcu_oveFan_activate=1,fcu_oveFan_u=0.5,wall=%di %d", wall, ts),
+		fmt.Sprintf("boptest,test_case=bestest_air,id=abc,kind=commanded fcu_oveFan_u=0.8,wall=%di %d", wall, ts),
+		fmt.Sprintf(`boptest,test_case=bestest_air,id=abc,kind=state fcu_oveFan_u=0.5,name="say \"hi\"",zon_reaTRooAir_y=294.5,wall=%di %d`, wall, ts),
+	} {
+		if i >= len(lines) || lines[i] != want {
+			t.Errorf("lines are\n%s\nwant\n%s", b, want)
+		}
+	}
+
+	var none *SeriesRecorder
+	if err := none.Record(rec); err != nil {
+		t.Errorf("nil recorder: %v", err)
+	}
+	for _, config := range []SeriesConfig{
+		{Format: SeriesCSV},
+		{Path: "series.parquet", Format: "parquet"},
+		{Path: "series.csv", Format: SeriesCSV, Points: []string{"["}},
+	} {
+		if _, err := NewSeriesRecorder(config); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
+
+func TestSeriesRecorderRotation(t *testing.T) {
+	r := newSeriesRecorder(t, SeriesConfig{Format: SeriesCSV, MaxBytes: 600, MaxFiles: 2})
+	for i := range 20 {
+		if err := r.Record(seriesRecord(float64(i * 3600))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{r.config.Path, r.config.Path + ".1", r.config.Path + ".2"} {
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, _ := bufio.NewReader(f).ReadString('\n')
+		f.Close()
+		if first != "time,wall,test_case,id,kind,point,value\n" {
+			t.Errorf("%s starts with %q, want the header", p, first)
+		}
+		if info, _ := os.Stat(p); info.Size() > 600 {
+			t.Errorf("%s is %d bytes, more than 600", p, info.Size())
+		}
+	}
+	if _, err := os.Stat(r.config.Path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more than 2 rotated files kept")
+	}
+
+	r.Close()
+	if err := r.Record(seriesRecord(0)); err == nil {
+		t.Errorf("expected an error recording after close")
+	}
+}
+
+func TestTestCaseSeries(t *testing.T) {
+	r := newSeriesRecorder(t, SeriesConfig{Format: SeriesJSONL, Points: []string{"oveTSet_u", "reaTZon_y"}})
+	plant := newRCPlant(t, HVACHeatPump)
+	testCase, err := NewTestCase("bestest_hydronic_heat_pump", WithPlant(plant), WithSeriesRecorder(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCase.Stop()
+	if err := testCase.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCase.SetInput("oveTSet_activate", "1")
+	testCase.SetInput("oveTSet_u", "295.15")
+	if err := testCase.Drain(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(r.config.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec SeriesRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.TestCase != "bestest_hydronic_heat_pump" || rec.ID != testCase.ID || rec.Time != 3600 {
+		t.Errorf("recorded %+v", rec)
+	}
+	if rec.Inputs["oveTSet_u"] != "295.15" || rec.State["reaTZon_y"] == nil || len(rec.State) != 2 || rec.Commanded != nil {
+		t.Errorf("recorded inputs %v, commanded %v and state %v", rec.Inputs, rec.Commanded, rec.State)
+	}
+
+	// what was written is recorded alongside what a fault made of it
+	testCase.ActuatorFaults, err = NewActuatorFaults(ActuatorFault{Input: "oveTSet_u", Kind: FaultStuck, Value: 290.15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCase.SetInput("oveTSet_u", "295.15")
+	if err := testCase.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.ReadFile(r.config.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	rec = SeriesRecord{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Inputs["oveTSet_u"] != 290.15 || rec.Commanded["oveTSet_u"] != "295.15" {
+		t.Errorf("recorded inputs %v and commanded %v", rec.Inputs, rec.Commanded)
+	}
+}
@@ -0,0 +1,156 @@
+package boptest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// verify client certificates against the PEM encoded CAs in caFile, i.e.
+// mutual TLS, which requires WithTLS
+func WithClientCA(caFile string) serverOption {
+	return func(s *Server) {
+		s.caFile = caFile
+	}
+}
+
+// tlsFiles holds a certificate, key and optional client CAs loaded from disk
+// and reloads them when the files change, so that rotated certificates are
+// used for new connections without a restart.
+type tlsFiles struct {
+	certFile, keyFile, caFile string
+
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	certMod [2]time.Time // of the cert and key files when loaded
+	caMod   time.Time
+	sync.Mutex
+
+	log *slog.Logger // the default logger if nil
+}
+
+func modTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// reload loads the files that changed since they were last loaded. On an
+// error, e.g. halfway through a rotation, the files loaded before are kept.
+func (f *tlsFiles) reload() error {
+	f.Lock()
+	defer f.Unlock()
+
+	certMod, err := modTime(f.certFile)
+	if err != nil {
+		return err
+	}
+	keyMod, err := modTime(f.keyFile)
+	if err != nil {
+		return err
+	}
+	if mod := [2]time.Time{certMod, keyMod}; f.cert == nil || mod != f.certMod {
+		cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			return err
+		}
+		if f.cert != nil {
+			orDefault(f.log).Info("reloaded tls certificate", "cert_file", f.certFile)
+		}
+		f.cert, f.certMod = &cert, mod
+	}
+
+	if f.caFile == "" {
+		return nil
+	}
+	caMod, err := modTime(f.caFile)
+	if err != nil {
+		return err
+	}
+	if f.pool == nil || caMod != f.caMod {
+		b, err := os.ReadFile(f.caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificates in %s", f.caFile)
+		}
+		if f.pool != nil {
+			orDefault(f.log).Info("reloaded client cas", "ca_file", f.caFile)
+		}
+		f.pool, f.caMod = pool, caMod
+	}
+	return nil
+}
+
+// returns the config for a new connection, with the current files
+func (f *tlsFiles) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	if err := f.reload(); err != nil {
+		orDefault(f.log).Error("unable to reload tls files, using those loaded before", "error", err)
+	}
+	f.Lock()
+	defer f.Unlock()
+	config := &tls.Config{
+		Certificates: []tls.Certificate{*f.cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2"}, // required by grpc
+	}
+	if f.pool != nil {
+		config.ClientCAs = f.pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// returns server credentials that reload the files on change
+func newReloadingCredentials(certFile, keyFile, caFile string, log *slog.Logger) (credentials.TransportCredentials, error) {
+	f := &tlsFiles{certFile: certFile, keyFile: keyFile, caFile: caFile, log: log}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(&tls.Config{GetConfigForClient: f.configForClient}), nil
+}
+
+// Identity is who a client is according to its verified certificate.
+type Identity struct {
+	CommonName   string   `json:"common_name"`
+	Organization []string `json:"organization,omitempty"`
+	DNSNames     []string `json:"dns_names,omitempty"`
+	URIs         []string `json:"uris,omitempty"` // e.g. SPIFFE ids
+	Serial       string   `json:"serial"`
+}
+
+// PeerIdentity returns the identity of the client of an RPC from its verified
+// certificate, or false if it did not present one, e.g. without mutual TLS.
+func PeerIdentity(ctx context.Context) (Identity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return Identity{}, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return Identity{}, false
+	}
+	cert := info.State.VerifiedChains[0][0]
+	id := Identity{
+		CommonName:   cert.Subject.CommonName,
+		Organization: cert.Subject.Organization,
+		DNSNames:     cert.DNSNames,
+		Serial:       cert.SerialNumber.String(),
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+	return id, true
+}
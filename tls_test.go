@@ -0,0 +1,188 @@
+package boptest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jamesryancoleman/bos/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// a certificate and key, signed by parent or self-signed if it is nil
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, template x509.Certificate) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template.SerialNumber = serial
+	template.Subject.CommonName = cn
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := &template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func newTestCA(t *testing.T) *testCert {
+	return newTestCert(t, "test ca", nil, x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+}
+
+func newServerCert(t *testing.T, cn string, ca *testCert) *testCert {
+	return newTestCert(t, cn, ca, x509.Certificate{
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+// writes the certificate and key as PEM files and returns their paths
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	for path, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: c.der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newServerCert(t, "driver", ca).write(t, dir, "server")
+
+	// records the identity of the client of every rpc
+	identities := make(chan Identity, 10)
+	capture := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if id, ok := PeerIdentity(ctx); ok {
+			identities <- id
+		}
+		return handler(ctx, req)
+	}
+	s := NewServer("127.0.0.1:0", nil, WithTLS(certFile, keyFile), WithClientCA(caFile), WithInterceptors(capture))
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	defer s.Shutdown(ctx)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	dial := func(config *tls.Config) error {
+		t.Helper()
+		conn, err := grpc.NewClient(s.Addr, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, err = common.NewDeviceControlClient(conn).Get(ctx, &common.GetRequest{Keys: []string{"boptest:///time"}})
+		return err
+	}
+
+	spiffe, _ := url.Parse("spiffe://building/bos")
+	client := newTestCert(t, "bos", ca, x509.Certificate{
+		Subject:     pkix.Name{Organization: []string{"facilities"}},
+		URIs:        []*url.URL{spiffe},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	err := dial(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{client.tlsCertificate()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case id := <-identities:
+		if id.CommonName != "bos" || id.Organization[0] != "facilities" || id.URIs[0] != "spiffe://building/bos" {
+			t.Errorf("identity is %+v", id)
+		}
+	default:
+		t.Errorf("no identity for a client with a certificate")
+	}
+
+	if err := dial(&tls.Config{RootCAs: roots}); err == nil {
+		t.Errorf("expected an error for a client without a certificate")
+	}
+	other := newTestCert(t, "mallory", newTestCA(t), x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err := dial(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{other.tlsCertificate()}}); err == nil {
+		t.Errorf("expected an error for a client certificate from another ca")
+	}
+
+	// a rotated server certificate is used for new connections
+	newServerCert(t, "driver rotated", ca).write(t, dir, "server")
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn, err := tls.Dial("tcp", s.Addr, &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{client.tlsCertificate()},
+		NextProtos:   []string{"h2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "driver rotated" {
+		t.Errorf("server presented %q after rotation", cn)
+	}
+}
+
+func TestTLSOptions(t *testing.T) {
+	s := NewServer("127.0.0.1:0", nil, WithClientCA("ca.crt"))
+	if err := s.Start(); err == nil {
+		s.Shutdown(context.Background())
+		t.Errorf("expected an error for mutual tls without a server certificate")
+	}
+	s = NewServer("127.0.0.1:0", nil, WithTLS("missing.crt", "missing.key"))
+	if err := s.Start(); err == nil {
+		s.Shutdown(context.Background())
+		t.Errorf("expected an error for a missing certificate")
+	}
+}